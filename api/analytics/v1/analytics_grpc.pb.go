@@ -0,0 +1,204 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: api/analytics/v1/analytics.proto
+
+package analyticsv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	AnalyticsService_IngestMetrics_FullMethodName  = "/analytics.v1.AnalyticsService/IngestMetrics"
+	AnalyticsService_GetAnalytics_FullMethodName   = "/analytics.v1.AnalyticsService/GetAnalytics"
+	AnalyticsService_WatchAnomalies_FullMethodName = "/analytics.v1.AnalyticsService/WatchAnomalies"
+)
+
+// AnalyticsServiceClient is the client API for AnalyticsService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// AnalyticsService прием метрик и аналитика устройств
+type AnalyticsServiceClient interface {
+	// IngestMetrics принимает поток метрик и возвращает итог по завершении потока
+	IngestMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, IngestSummary], error)
+	// GetAnalytics возвращает последний результат аналитики устройства
+	GetAnalytics(ctx context.Context, in *GetAnalyticsRequest, opts ...grpc.CallOption) (*AnalyticsResult, error)
+	// WatchAnomalies передает аномалии по мере обнаружения
+	WatchAnomalies(ctx context.Context, in *WatchAnomaliesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AnalyticsResult], error)
+}
+
+type analyticsServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAnalyticsServiceClient(cc grpc.ClientConnInterface) AnalyticsServiceClient {
+	return &analyticsServiceClient{cc}
+}
+
+func (c *analyticsServiceClient) IngestMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, IngestSummary], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AnalyticsService_ServiceDesc.Streams[0], AnalyticsService_IngestMetrics_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Metric, IngestSummary]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AnalyticsService_IngestMetricsClient = grpc.ClientStreamingClient[Metric, IngestSummary]
+
+func (c *analyticsServiceClient) GetAnalytics(ctx context.Context, in *GetAnalyticsRequest, opts ...grpc.CallOption) (*AnalyticsResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AnalyticsResult)
+	err := c.cc.Invoke(ctx, AnalyticsService_GetAnalytics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *analyticsServiceClient) WatchAnomalies(ctx context.Context, in *WatchAnomaliesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[AnalyticsResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &AnalyticsService_ServiceDesc.Streams[1], AnalyticsService_WatchAnomalies_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchAnomaliesRequest, AnalyticsResult]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AnalyticsService_WatchAnomaliesClient = grpc.ServerStreamingClient[AnalyticsResult]
+
+// AnalyticsServiceServer is the server API for AnalyticsService service.
+// All implementations must embed UnimplementedAnalyticsServiceServer
+// for forward compatibility.
+//
+// AnalyticsService прием метрик и аналитика устройств
+type AnalyticsServiceServer interface {
+	// IngestMetrics принимает поток метрик и возвращает итог по завершении потока
+	IngestMetrics(grpc.ClientStreamingServer[Metric, IngestSummary]) error
+	// GetAnalytics возвращает последний результат аналитики устройства
+	GetAnalytics(context.Context, *GetAnalyticsRequest) (*AnalyticsResult, error)
+	// WatchAnomalies передает аномалии по мере обнаружения
+	WatchAnomalies(*WatchAnomaliesRequest, grpc.ServerStreamingServer[AnalyticsResult]) error
+	mustEmbedUnimplementedAnalyticsServiceServer()
+}
+
+// UnimplementedAnalyticsServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAnalyticsServiceServer struct{}
+
+func (UnimplementedAnalyticsServiceServer) IngestMetrics(grpc.ClientStreamingServer[Metric, IngestSummary]) error {
+	return status.Errorf(codes.Unimplemented, "method IngestMetrics not implemented")
+}
+func (UnimplementedAnalyticsServiceServer) GetAnalytics(context.Context, *GetAnalyticsRequest) (*AnalyticsResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAnalytics not implemented")
+}
+func (UnimplementedAnalyticsServiceServer) WatchAnomalies(*WatchAnomaliesRequest, grpc.ServerStreamingServer[AnalyticsResult]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchAnomalies not implemented")
+}
+func (UnimplementedAnalyticsServiceServer) mustEmbedUnimplementedAnalyticsServiceServer() {}
+func (UnimplementedAnalyticsServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeAnalyticsServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AnalyticsServiceServer will
+// result in compilation errors.
+type UnsafeAnalyticsServiceServer interface {
+	mustEmbedUnimplementedAnalyticsServiceServer()
+}
+
+func RegisterAnalyticsServiceServer(s grpc.ServiceRegistrar, srv AnalyticsServiceServer) {
+	// If the following call pancis, it indicates UnimplementedAnalyticsServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&AnalyticsService_ServiceDesc, srv)
+}
+
+func _AnalyticsService_IngestMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AnalyticsServiceServer).IngestMetrics(&grpc.GenericServerStream[Metric, IngestSummary]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AnalyticsService_IngestMetricsServer = grpc.ClientStreamingServer[Metric, IngestSummary]
+
+func _AnalyticsService_GetAnalytics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAnalyticsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AnalyticsServiceServer).GetAnalytics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AnalyticsService_GetAnalytics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AnalyticsServiceServer).GetAnalytics(ctx, req.(*GetAnalyticsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AnalyticsService_WatchAnomalies_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchAnomaliesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AnalyticsServiceServer).WatchAnomalies(m, &grpc.GenericServerStream[WatchAnomaliesRequest, AnalyticsResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type AnalyticsService_WatchAnomaliesServer = grpc.ServerStreamingServer[AnalyticsResult]
+
+// AnalyticsService_ServiceDesc is the grpc.ServiceDesc for AnalyticsService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AnalyticsService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "analytics.v1.AnalyticsService",
+	HandlerType: (*AnalyticsServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetAnalytics",
+			Handler:    _AnalyticsService_GetAnalytics_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "IngestMetrics",
+			Handler:       _AnalyticsService_IngestMetrics_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchAnomalies",
+			Handler:       _AnalyticsService_WatchAnomalies_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/analytics/v1/analytics.proto",
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-service/internal/backfill"
+)
+
+func main() {
+	file := flag.String("file", "", "CSV or NDJSON file with historical metrics")
+	serverURL := flag.String("url", "http://localhost:8080", "service base URL")
+	format := flag.String("format", "", "file format: csv or ndjson (detected from extension by default)")
+	apiKey := flag.String("key", os.Getenv("API_KEY"), "admin API key or token; metrics are imported into its tenant")
+	flag.Parse()
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "Usage: backfill -file metrics.csv [-url http://localhost:8080] [-format csv|ndjson] [-key KEY]")
+		os.Exit(2)
+	}
+
+	// Проверяем формат до загрузки, чтобы не отправлять файл впустую
+	detected, err := backfill.DetectFormat(*format, *file, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to detect format: %v\n", err)
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open file: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to stat file: %v\n", err)
+		os.Exit(1)
+	}
+
+	endpoint := strings.TrimRight(*serverURL, "/") + "/admin/backfill?" + url.Values{
+		"format":   {string(detected)},
+		"filename": {filepath.Base(*file)},
+	}.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create request: %v\n", err)
+		os.Exit(1)
+	}
+	req.ContentLength = info.Size()
+	if *apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+*apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Backfill request failed: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		fmt.Fprintf(os.Stderr, "Backfill rejected: %s %s\n", resp.Status, strings.TrimSpace(body))
+		os.Exit(1)
+	}
+
+	// Сервер присылает строку прогресса каждые несколько тысяч метрик
+	var last backfill.Progress
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid progress line: %v\n", err)
+			continue
+		}
+		percent := 100.0
+		if info.Size() > 0 {
+			percent = float64(last.BytesRead) / float64(info.Size()) * 100
+		}
+		fmt.Printf("%5.1f%%  processed=%d failed=%d anomalies=%d\n",
+			percent, last.Processed, last.Failed, last.Anomalies)
+		if last.LastError != "" && !last.Done {
+			fmt.Printf("        last error: %s\n", last.LastError)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read progress: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case !last.Done:
+		fmt.Fprintln(os.Stderr, "Backfill interrupted before completion")
+		os.Exit(1)
+	case last.Error != "":
+		fmt.Fprintf(os.Stderr, "Backfill aborted: %s\n", last.Error)
+		os.Exit(1)
+	case last.Failed > 0:
+		fmt.Printf("Done with %d failed records, last error: %s\n", last.Failed, last.LastError)
+	default:
+		fmt.Println("Done")
+	}
+}
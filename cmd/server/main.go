@@ -3,15 +3,28 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"go-service/internal/analytics"
+	"go-service/internal/audit"
+	"go-service/internal/auth"
 	"go-service/internal/cache"
+	"go-service/internal/concurrency"
+	"go-service/internal/config"
+	"go-service/internal/grpcapi"
 	"go-service/internal/handlers"
+	"go-service/internal/idempotency"
+	"go-service/internal/ingest"
+	"go-service/internal/ratelimit"
+	"go-service/internal/storage"
+	"go-service/internal/tenant"
+	"go-service/internal/tsdb"
 	"go-service/pkg/metrics"
 
 	"github.com/gorilla/mux"
@@ -34,40 +47,322 @@ func main() {
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
-	// Инициализация Redis
-	redisClient := cache.NewRedisClient()
-	defer redisClient.Close()
+	cfg, err := config.Load()
+	if err != nil {
+		sugar.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Проверка подключения к Redis
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Инициализация метрик Prometheus
+	metrics.InitMetrics()
 
-	if err := redisClient.Ping(ctx); err != nil {
-		sugar.Errorf("Failed to connect to Redis: %v", err)
-		sugar.Warn("Continuing without Redis cache")
-	} else {
-		sugar.Info("Connected to Redis successfully")
+	// Инициализация Redis, если он настроен или нужен хранилищу, чтению
+	// потока или общим для узлов ключам API
+	var redisClient *cache.RedisClient
+	if cfg.RedisHost != "" || cfg.StorageBackend == "redis" || cfg.StreamEnabled || cfg.AuthEnabled {
+		redisClient = cache.NewRedisClient()
+		defer redisClient.Close()
+
+		// Проверка подключения к Redis
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := redisClient.Ping(ctx); err != nil {
+			if cfg.AuthEnabled {
+				sugar.Fatalf("Failed to connect to Redis, required for API keys: %v", err)
+			}
+			sugar.Errorf("Failed to connect to Redis: %v", err)
+			sugar.Warn("Continuing without Redis cache")
+		} else {
+			sugar.Info("Connected to Redis successfully")
+		}
+		cancel()
+	}
+
+	// Инициализация хранилища
+	store, err := newStorage(cfg, redisClient, sugar)
+	if err != nil {
+		sugar.Fatalf("Failed to initialize %s storage: %v", cfg.StorageBackend, err)
 	}
+	sugar.Infof("Using %s storage", cfg.StorageBackend)
 
 	// Инициализация аналитики
-	analyticsService := analytics.NewAnalyticsService(redisClient, 50, 2.0)
+	analyticsService := analytics.NewAnalyticsService(store, cfg.WindowSize, cfg.AnomalyThreshold)
 	analyticsService.SetLogger(sugar)
+	analyticsService.SetMaxWindowSamples(cfg.WindowMaxSamples)
+	defaultWindow := analytics.WindowConfig{Size: cfg.WindowSize, Duration: cfg.WindowDuration}
+	if err := defaultWindow.Validate(); err != nil {
+		sugar.Fatalf("Invalid default window: %v", err)
+	}
+	analyticsService.SetWindow(defaultWindow)
+	latePolicy, err := analytics.ParseLatePolicy(cfg.LatePolicy)
+	if err != nil {
+		sugar.Fatalf("Invalid late metric policy: %v", err)
+	}
+	analyticsService.SetLateness(cfg.AllowedLateness, latePolicy)
+	analyticsService.SetMaxClockSkew(cfg.MaxClockSkew)
+	if cfg.IdempotencyWindow > 0 {
+		analyticsService.SetIdempotency(newIdempotencyStore(cfg, redisClient, sugar))
+	}
+	for name, value := range cfg.FieldSpecs {
+		spec, err := analytics.ParseFieldSpec(value)
+		if err == nil {
+			err = analyticsService.SetFieldSpec(name, spec)
+		}
+		if err != nil {
+			sugar.Fatalf("Invalid spec for field %s: %v", name, err)
+		}
+	}
+	for deviceID, value := range cfg.WindowOverrides {
+		window, err := analytics.ParseWindowConfig(value)
+		if err != nil {
+			sugar.Fatalf("Invalid window for device %s: %v", deviceID, err)
+		}
+		analyticsService.SetDeviceWindow(context.Background(), deviceID, window)
+	}
+	for tenantID, value := range cfg.TenantWindows {
+		window, err := analytics.ParseWindowConfig(value)
+		if err == nil {
+			err = tenant.Validate(tenantID)
+		}
+		if err != nil {
+			sugar.Fatalf("Invalid window for tenant %s: %v", tenantID, err)
+		}
+		analyticsService.SetTenantWindow(tenantID, window)
+	}
+	for tenantID, value := range cfg.TenantMaxDevices {
+		maxDevices, err := strconv.Atoi(value)
+		if err == nil && maxDevices < 0 {
+			err = fmt.Errorf("negative limit %d", maxDevices)
+		}
+		if err == nil {
+			err = tenant.Validate(tenantID)
+		}
+		if err != nil {
+			sugar.Fatalf("Invalid device limit for tenant %s: %v", tenantID, err)
+		}
+		analyticsService.SetTenantQuota(tenantID, maxDevices)
+	}
 
-	// Инициализация метрик Prometheus
-	metrics.InitMetrics()
+	// Хранилище истории сырых метрик
+	var history *tsdb.DB
+	if cfg.HistoryEnabled {
+		rollups := tsdb.DefaultRollupLevels()
+		rollups[0].Retention = cfg.RollupRetention1m
+		rollups[1].Retention = cfg.RollupRetention1h
+		rollups[2].Retention = cfg.RollupRetention1d
 
-	// Создание роутера
+		history, err = tsdb.Open(tsdb.Options{
+			Dir:           cfg.HistoryPath,
+			Partition:     cfg.HistoryPartition,
+			Retention:     cfg.HistoryRetention,
+			FlushInterval: cfg.HistoryFlush,
+			Rollups:       rollups,
+			MaxClockSkew:  cfg.MaxClockSkew,
+		}, sugar)
+		if err != nil {
+			sugar.Fatalf("Failed to open history store: %v", err)
+		}
+		analyticsService.SetHistory(history)
+	}
+
+	// Восстановление окон устройств после перезапуска
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if restored, err := analyticsService.Restore(ctx); err != nil {
+		sugar.Errorf("Failed to restore device windows: %v", err)
+	} else if restored > 0 {
+		sugar.Infof("Restored windows for %d devices", restored)
+	}
+	cancel()
+
+	// Подписка на метрики устройств из MQTT
+	var mqttSubscriber *ingest.MQTTSubscriber
+	if cfg.MQTTBroker != "" {
+		mqttSubscriber, err = ingest.NewMQTTSubscriber(ingest.MQTTConfig{
+			Broker:         cfg.MQTTBroker,
+			ClientID:       cfg.MQTTClientID,
+			Username:       cfg.MQTTUsername,
+			Password:       cfg.MQTTPassword,
+			Topics:         cfg.MQTTTopics,
+			ConnectTimeout: 10 * time.Second,
+			MaxReconnect:   cfg.MQTTMaxReconnect,
+		}, analyticsService, sugar)
+		if err != nil {
+			sugar.Fatalf("Invalid MQTT configuration: %v", err)
+		}
+		mqttSubscriber.Start()
+		sugar.Infof("Subscribing to MQTT broker %s", cfg.MQTTBroker)
+	}
+
+	// Чтение метрик из потока Redis
+	var streamConsumer *ingest.StreamConsumer
+	if cfg.StreamEnabled {
+		streamConsumer, err = ingest.NewStreamConsumer(redisClient, ingest.StreamConfig{
+			Stream:        cfg.StreamName,
+			Group:         cfg.StreamGroup,
+			Consumer:      cfg.StreamConsumer,
+			BatchSize:     int64(cfg.StreamBatchSize),
+			Block:         cfg.StreamBlock,
+			ClaimIdle:     cfg.StreamClaimIdle,
+			MaxDeliveries: int64(cfg.StreamMaxDeliveries),
+		}, analyticsService, sugar)
+		if err != nil {
+			sugar.Fatalf("Invalid stream configuration: %v", err)
+		}
+		streamConsumer.Start()
+		sugar.Infof("Consuming stream %s as %s/%s", cfg.StreamName, cfg.StreamGroup, cfg.StreamConsumer)
+	}
+
+	// Журнал административных действий: общий в Redis, иначе файл узла
+	var auditStore audit.Store
+	if redisClient != nil {
+		auditStore = audit.NewRedis(redisClient)
+	} else {
+		auditFile, err := audit.OpenFile(cfg.AuditPath)
+		if err != nil {
+			sugar.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer auditFile.Close()
+		auditStore = auditFile
+	}
+	auditLog := audit.NewLog(auditStore, sugar)
+
+	// Ограничение скорости приема метрик: корзины в Redis, иначе в памяти узла
+	deviceLimit := ratelimit.Limit{Rate: cfg.RateLimitDevice, Burst: cfg.RateLimitDeviceBurst}
+	keyLimit := ratelimit.Limit{Rate: cfg.RateLimitKey, Burst: cfg.RateLimitKeyBurst}
+	if err := deviceLimit.Validate(); err != nil {
+		sugar.Fatalf("Invalid device rate limit: %v", err)
+	}
+	if err := keyLimit.Validate(); err != nil {
+		sugar.Fatalf("Invalid API key rate limit: %v", err)
+	}
+	var limiter *ratelimit.Limiter
+	if deviceLimit.Enabled() || keyLimit.Enabled() {
+		var buckets ratelimit.Store
+		if redisClient != nil {
+			buckets = ratelimit.NewRedis(redisClient)
+		} else {
+			sugar.Warn("Rate-limit buckets are kept in this node's memory without Redis: " +
+				"each replica allows the full rate, set REDIS_HOST to share them")
+			buckets = ratelimit.NewMemory()
+		}
+		limiter = ratelimit.New(buckets, deviceLimit, keyLimit, sugar)
+	}
+
+	// Создание роутера. Права маршрутов объявляются при регистрации обработчиков.
 	r := mux.NewRouter()
+	routes := auth.NewRoutes()
+
+	// Ограничение одновременных запросов до проверки ключей: при
+	// перегрузке запрос отклоняется, не обращаясь к хранилищу ключей.
+	// Запрос без действующего ключа тоже занимает место, но только на время
+	// проверки ключа, после чего получает 401 или 403.
+	if cfg.MaxConcurrentRequests > 0 {
+		concurrencyCfg := concurrency.Config{
+			MaxLimit:  cfg.MaxConcurrentRequests,
+			MinLimit:  min(cfg.ConcurrencyMinLimit, cfg.MaxConcurrentRequests),
+			Tolerance: cfg.ConcurrencyTolerance,
+		}
+		if err := concurrencyCfg.Validate(); err != nil {
+			sugar.Fatalf("Invalid concurrency limit: %v", err)
+		}
+		r.Use(concurrency.NewLimiter(concurrencyCfg, routes.Priority, sugar).Middleware)
+		sugar.Infof("Limiting concurrent requests to %d", cfg.MaxConcurrentRequests)
+	}
+
+	// Проверка ключей API и прав маршрутов, кроме публичных проверок здоровья и /prometheus.
+	// Те же ключи проверяет gRPC API.
+	var authenticator *auth.Authenticator
+	if cfg.AuthEnabled {
+		// Ключи общие для узлов: выданный на одном узле действует на всех,
+		// отозванный перестает действовать везде
+		keys := auth.NewRedis(redisClient)
+		if cfg.AuthAdminKey == "" {
+			sugar.Warn("AUTH_ADMIN_KEY is not set, API keys cannot be issued")
+		}
+		authenticator = auth.NewAuthenticator(keys, routes, cfg.AuthAdminKey, sugar)
+		if cfg.JWTJWKSURL != "" || cfg.JWTJWKSFile != "" {
+			jwks, err := auth.NewJWKS(cfg.JWTJWKSURL, cfg.JWTJWKSFile, cfg.JWTJWKSRefresh, sugar)
+			if err != nil {
+				sugar.Fatalf("Failed to load JWKS: %v", err)
+			}
+			defer jwks.Close()
+
+			roles := make(map[string]auth.Role, len(cfg.JWTRoles))
+			for claimRole, value := range cfg.JWTRoles {
+				role, err := auth.ParseRole(value)
+				if err != nil {
+					sugar.Fatalf("Invalid JWT role mapping for %s: %v", claimRole, err)
+				}
+				roles[claimRole] = role
+			}
+			verifier, err := auth.NewJWTVerifier(auth.JWTConfig{
+				Issuer:        cfg.JWTIssuer,
+				Audience:      cfg.JWTAudience,
+				ClockSkew:     cfg.JWTClockSkew,
+				RoleClaim:     cfg.JWTRoleClaim,
+				Roles:         roles,
+				TenantClaim:   cfg.JWTTenantClaim,
+				DefaultTenant: cfg.JWTDefaultTenant,
+			}, jwks)
+			if err != nil {
+				sugar.Fatalf("Invalid JWT configuration: %v", err)
+			}
+			authenticator.SetJWT(verifier)
+			sugar.Info("Accepting JWT bearer tokens")
+		}
+		r.Use(authenticator.Middleware)
+		handlers.RegisterKeyHandlers(r, routes, authenticator, auditLog, sugar)
+	}
+
+	if !cfg.AuthEnabled && (cfg.RemoteWriteEnabled || cfg.InfluxEnabled || cfg.OTLPEnabled || cfg.GRPCPort != "") {
+		sugar.Warn("Authentication is disabled, enabled ingestion endpoints accept writes from anyone")
+	}
 
 	// Регистрация обработчиков
-	handlers.RegisterHandlers(r, analyticsService, redisClient, sugar)
+	handlers.RegisterHandlers(r, routes, analyticsService, redisClient, auditLog, limiter, sugar)
+	handlers.RegisterAuditHandlers(r, routes, auditLog, sugar)
+	if history != nil {
+		handlers.RegisterHistoryHandlers(r, routes, history, sugar)
+	}
+	sockets := handlers.NewDeviceSockets(analyticsService, handlers.WebSocketConfig{
+		RateLimit:    cfg.WSRateLimit,
+		RateBurst:    cfg.WSRateBurst,
+		PingInterval: cfg.WSPingInterval,
+	}, limiter, sugar)
+	handlers.RegisterWebSocketHandlers(r, routes, sockets)
+	if cfg.RemoteWriteEnabled {
+		mapping := ingest.RemoteWriteMapping{DeviceLabel: cfg.RemoteWriteDeviceLabel, Fields: cfg.RemoteWriteFields}
+		if err := mapping.Validate(); err != nil {
+			sugar.Fatalf("Invalid remote write mapping: %v", err)
+		}
+		handlers.RegisterRemoteWriteHandlers(r, routes, analyticsService, mapping, limiter, sugar)
+	}
+	var influxUDP *ingest.UDPListener
+	if cfg.InfluxEnabled {
+		mapping := ingest.InfluxMapping{DeviceTag: cfg.InfluxDeviceTag, Fields: cfg.InfluxFields}
+		if err := mapping.Validate(); err != nil {
+			sugar.Fatalf("Invalid line protocol mapping: %v", err)
+		}
+		handlers.RegisterInfluxHandlers(r, routes, analyticsService, mapping, limiter, sugar)
 
-	// Настройка сервера
-	port := os.Getenv("SERVER_PORT")
-	if port == "" {
-		port = "8080"
+		if cfg.InfluxUDPAddr != "" {
+			influxUDP, err = ingest.ListenUDP(cfg.InfluxUDPAddr, analyticsService, mapping, sugar)
+			if err != nil {
+				sugar.Fatalf("Failed to listen for line protocol on UDP %s: %v", cfg.InfluxUDPAddr, err)
+			}
+			sugar.Warnf("Accepting unauthenticated line protocol on UDP %s into the default tenant, trusted network only", influxUDP.Addr())
+		}
+	}
+
+	if cfg.OTLPEnabled {
+		mapping := ingest.OTLPMapping{DeviceAttributes: cfg.OTLPDeviceAttributes, Fields: cfg.OTLPFields}
+		if err := mapping.Validate(); err != nil {
+			sugar.Fatalf("Invalid OTLP mapping: %v", err)
+		}
+		handlers.RegisterOTLPHandlers(r, routes, analyticsService, mapping, limiter, sugar)
 	}
 
+	// Настройка сервера
+	port := cfg.ServerPort
+
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", port),
 		Handler:      r,
@@ -76,6 +371,22 @@ func main() {
 		IdleTimeout:  120 * time.Second,
 	}
 
+	// gRPC API на отдельном порту
+	var grpcServer *grpcapi.Server
+	if cfg.GRPCPort != "" {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%s", cfg.GRPCPort))
+		if err != nil {
+			sugar.Fatalf("Failed to listen for gRPC on port %s: %v", cfg.GRPCPort, err)
+		}
+		grpcServer = grpcapi.NewServer(analyticsService, authenticator, limiter, sugar)
+		go func() {
+			sugar.Infof("Starting gRPC server on port %s", cfg.GRPCPort)
+			if err := grpcServer.Serve(lis); err != nil {
+				sugar.Fatalf("gRPC server failed: %v", err)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
@@ -96,6 +407,79 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		sugar.Errorf("Server shutdown failed: %v", err)
 	}
+	sockets.Close()
+	if grpcServer != nil {
+		grpcServer.Shutdown(ctx)
+	}
+	if influxUDP != nil {
+		influxUDP.Close()
+	}
+	if mqttSubscriber != nil {
+		mqttSubscriber.Close()
+	}
+	if streamConsumer != nil {
+		streamConsumer.Close()
+	}
+
+	// Сохраняем окна и сбрасываем накопленные результаты после остановки приема запросов
+	if err := analyticsService.Snapshot(ctx); err != nil {
+		sugar.Errorf("Failed to snapshot device windows: %v", err)
+	}
+	if err := store.Close(ctx); err != nil {
+		sugar.Errorf("Storage close failed: %v", err)
+	}
+	if history != nil {
+		if err := history.Close(); err != nil {
+			sugar.Errorf("History store close failed: %v", err)
+		}
+	}
 
 	sugar.Info("Server stopped")
 }
+
+// newStorage создает хранилище, выбранное в конфигурации
+func newStorage(cfg *config.Config, redisClient *cache.RedisClient, logger *zap.SugaredLogger) (storage.Storage, error) {
+	switch cfg.StorageBackend {
+	case "memory":
+		return storage.NewMemory(), nil
+	case "disk":
+		return storage.NewDisk(cfg.StoragePath)
+	case "redis":
+		// Отложенная пакетная запись результатов в Redis
+		writeBehind := cache.NewWriteBehind(
+			redisClient,
+			cfg.ResultTTL,
+			cfg.WriteBehindInterval,
+			cfg.WriteBehindBatchSize,
+			cfg.WriteBehindMaxPending,
+			logger,
+		)
+		store := storage.NewRedis(redisClient, writeBehind, cfg.ResultTTL)
+		// Записи, сохраненные до разделения по арендаторам, переходят к
+		// арендатору по умолчанию до приема метрик: запись по новым ключам
+		// до переноса разошлась бы с прежними данными
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		moved, err := store.MigrateLegacyKeys(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("migrate legacy keys: %w", err)
+		}
+		if moved > 0 {
+			logger.Infof("Migrated %d legacy Redis keys to tenant %s", moved, tenant.Default)
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
+
+// newIdempotencyStore создает хранилище ключей дедупликации: общее в Redis,
+// если он используется, иначе в памяти узла
+func newIdempotencyStore(cfg *config.Config, redisClient *cache.RedisClient, logger *zap.SugaredLogger) idempotency.Store {
+	if redisClient != nil {
+		return idempotency.NewRedis(redisClient, cfg.IdempotencyWindow)
+	}
+	logger.Warn("Idempotency keys are kept in this node's memory without Redis: " +
+		"retries that reach another replica or arrive after a restart are processed again, set REDIS_HOST to share them")
+	return idempotency.NewMemory(cfg.IdempotencyWindow)
+}
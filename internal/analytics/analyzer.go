@@ -2,122 +2,341 @@ package analytics
 
 import (
 	"context"
+	"errors"
 	"math"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"go-service/internal/cache"
+	"go-service/internal/idempotency"
 	"go-service/internal/models"
+	"go-service/internal/storage"
+	"go-service/internal/tenant"
+	"go-service/pkg/metrics"
 
 	"go.uber.org/zap"
 )
 
+// HistoryWriter принимает сырые метрики для долговременного хранения
+type HistoryWriter interface {
+	Append(metric models.Metric) error
+}
+
 // AnalyticsService предоставляет сервис аналитики
 type AnalyticsService struct {
-	mu           sync.RWMutex
-	redis        *cache.RedisClient
-	stats        *Statistics
-	windowSize   int
-	threshold    float64
-	metricsCache map[string][]models.Metric
-	logger       *zap.SugaredLogger
-	anomalyChan  chan models.AnalyticsResult
+	store       storage.Storage
+	history     HistoryWriter
+	idempotency idempotency.Store
+	stats       *Statistics
+	threshold   float64
+
+	windowMu      sync.RWMutex
+	window        WindowConfig            // Окно по умолчанию
+	tenantWindows map[string]WindowConfig // Окна по умолчанию арендаторов
+	overrides     map[string]WindowConfig // Окна отдельных устройств по ключам tenant.Scope
+	maxSamples    int                     // Предел окна, ограниченного только временем
+
+	quotas tenantQuotas
+
+	allowedLateness time.Duration
+	latePolicy      LatePolicy
+	maxClockSkew    time.Duration
+
+	fieldSpecs map[string]FieldSpec // Единицы и диапазоны полей
+
+	shards      *deviceShards
+	logger      atomic.Pointer[zap.SugaredLogger]
+	anomalyChan chan models.AnalyticsResult
+	subscribers anomalySubscribers
 }
 
 // NewAnalyticsService создает новый сервис аналитики
-func NewAnalyticsService(redis *cache.RedisClient, windowSize int, threshold float64) *AnalyticsService {
-	return &AnalyticsService{
-		redis:        redis,
-		stats:        NewStatistics(),
-		windowSize:   windowSize,
-		threshold:    threshold,
-		metricsCache: make(map[string][]models.Metric),
-		anomalyChan:  make(chan models.AnalyticsResult, 100),
-		logger:       zap.NewNop().Sugar(), // Инициализируем заглушкой
+func NewAnalyticsService(store storage.Storage, windowSize int, threshold float64) *AnalyticsService {
+	a := &AnalyticsService{
+		store:         store,
+		stats:         NewStatistics(),
+		threshold:     threshold,
+		window:        WindowConfig{Size: windowSize},
+		tenantWindows: make(map[string]WindowConfig),
+		overrides:     make(map[string]WindowConfig),
+		maxSamples:    DefaultMaxWindowSamples,
+
+		quotas: tenantQuotas{maxDevices: make(map[string]int), devices: make(map[string]int)},
+
+		allowedLateness: DefaultAllowedLateness,
+		latePolicy:      LatePolicyDrop,
+		maxClockSkew:    DefaultMaxClockSkew,
+
+		fieldSpecs: make(map[string]FieldSpec),
+
+		shards:      newDeviceShards(),
+		anomalyChan: make(chan models.AnalyticsResult, 100),
+		subscribers: anomalySubscribers{subs: make(map[chan models.AnalyticsResult]anomalyFilter)},
+	}
+	a.logger.Store(zap.NewNop().Sugar()) // Инициализируем заглушкой
+	return a
+}
+
+// DefaultMaxWindowSamples предел количества метрик в окне, ограниченном только временем
+const DefaultMaxWindowSamples = 10000
+
+// SetWindow задает окно по умолчанию для устройств без собственного окна
+func (a *AnalyticsService) SetWindow(cfg WindowConfig) {
+	a.windowMu.Lock()
+	a.window = cfg
+	a.windowMu.Unlock()
+
+	a.applyWindows()
+}
+
+// SetMaxWindowSamples задает предел количества метрик в окне
+func (a *AnalyticsService) SetMaxWindowSamples(maxSamples int) {
+	a.windowMu.Lock()
+	a.maxSamples = maxSamples
+	a.windowMu.Unlock()
+}
+
+// SetDeviceWindow задает собственное окно устройства арендатора из контекста
+func (a *AnalyticsService) SetDeviceWindow(ctx context.Context, deviceID string, cfg WindowConfig) {
+	key := tenant.Scope(tenant.FromContext(ctx), deviceID)
+	a.windowMu.Lock()
+	a.overrides[key] = cfg
+	a.windowMu.Unlock()
+
+	a.applyWindow(key)
+}
+
+// ResetDeviceWindow возвращает устройству окно по умолчанию
+func (a *AnalyticsService) ResetDeviceWindow(ctx context.Context, deviceID string) {
+	key := tenant.Scope(tenant.FromContext(ctx), deviceID)
+	a.windowMu.Lock()
+	delete(a.overrides, key)
+	a.windowMu.Unlock()
+
+	a.applyWindow(key)
+}
+
+// DeviceWindow возвращает действующее окно устройства и признак собственного окна
+func (a *AnalyticsService) DeviceWindow(ctx context.Context, deviceID string) (WindowConfig, bool) {
+	return a.windowConfig(tenant.Scope(tenant.FromContext(ctx), deviceID))
+}
+
+// windowConfig возвращает окно устройства по ключу tenant.Scope: собственное,
+// арендатора или общее
+func (a *AnalyticsService) windowConfig(key string) (WindowConfig, bool) {
+	a.windowMu.RLock()
+	defer a.windowMu.RUnlock()
+	if cfg, exists := a.overrides[key]; exists {
+		return cfg, true
+	}
+	tenantID, _, _ := tenant.Split(key)
+	if cfg, exists := a.tenantWindows[tenantID]; exists {
+		return cfg, false
+	}
+	return a.window, false
+}
+
+// newWindow создает окно устройства с действующими ограничениями
+func (a *AnalyticsService) newWindow(key string) *metricWindow {
+	cfg, _ := a.windowConfig(key)
+	a.windowMu.RLock()
+	defer a.windowMu.RUnlock()
+	return newMetricWindow(cfg, a.maxSamples)
+}
+
+// applyWindow применяет действующие ограничения к окну устройства
+func (a *AnalyticsService) applyWindow(key string) {
+	cfg, _ := a.windowConfig(key)
+	shard := a.shards.get(key)
+	shard.mu.Lock()
+	if window, exists := shard.windows[key]; exists {
+		window.SetLimits(cfg)
+	}
+	shard.mu.Unlock()
+}
+
+// applyWindows применяет действующие ограничения ко всем окнам
+func (a *AnalyticsService) applyWindows() {
+	for _, shard := range a.shards {
+		shard.mu.Lock()
+		for key, window := range shard.windows {
+			cfg, _ := a.windowConfig(key)
+			window.SetLimits(cfg)
+		}
+		shard.mu.Unlock()
 	}
 }
 
 // SetLogger устанавливает логгер
 func (a *AnalyticsService) SetLogger(logger *zap.SugaredLogger) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	a.logger = logger
+	a.logger.Store(logger)
 }
 
-// ProcessMetric обрабатывает метрику
+// SetHistory включает запись сырых метрик в историю
+func (a *AnalyticsService) SetHistory(history HistoryWriter) {
+	a.history = history
+}
+
+// log возвращает текущий логгер
+func (a *AnalyticsService) log() *zap.SugaredLogger {
+	return a.logger.Load()
+}
+
+// ProcessMetric обрабатывает метрику по времени события. Метрика без
+// временной метки получает время приема. Опоздавшая метрика встает в окно
+// на свое место, если опоздала не больше допустимого, иначе возвращается
+// *LateMetricError. Метрика с полем вне допустимого диапазона отклоняется
+// с *InvalidMetricError. Повтор метрики с уже обработанным MessageID возвращает
+// исходный результат с Duplicate, не попадая в окно; повтор опоздавшей
+// метрики, сохраненной в историю, — результат с Diverted. Метрика относится к
+// арендатору из контекста, новое устройство сверх его предела отклоняется
+// с *QuotaExceededError.
 func (a *AnalyticsService) ProcessMetric(ctx context.Context, metric models.Metric) (*models.AnalyticsResult, error) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+	if metric.MessageID == "" || a.idempotency == nil {
+		return a.process(ctx, metric, false)
+	}
+	return a.processOnce(ctx, metric)
+}
 
-	deviceID := metric.DeviceID
+// Backfill загружает историческую метрику в окно и историю устройства.
+// Метрика обязана иметь временную метку, ограничение опоздания не
+// применяется, аномалии вычисляются, но не публикуются и не попадают в журнал.
+func (a *AnalyticsService) Backfill(ctx context.Context, metric models.Metric) (*models.AnalyticsResult, error) {
+	if metric.Timestamp.IsZero() {
+		return nil, errors.New("backfill metric must have a timestamp")
+	}
+	return a.process(ctx, metric, true)
+}
 
-	// Добавляем метрику в кэш
-	a.metricsCache[deviceID] = append(a.metricsCache[deviceID], metric)
+// process добавляет метрику в окно и вычисляет результат
+func (a *AnalyticsService) process(ctx context.Context, metric models.Metric, backfill bool) (*models.AnalyticsResult, error) {
+	receivedAt := time.Now()
+	if metric.Timestamp.IsZero() {
+		metric.Timestamp = receivedAt
+	}
+	if err := a.validate(&metric); err != nil {
+		return nil, err
+	}
+	if err := a.checkClockSkew(metric, receivedAt); err != nil {
+		return nil, err
+	}
 
-	// Ограничиваем размер окна
-	if len(a.metricsCache[deviceID]) > a.windowSize {
-		a.metricsCache[deviceID] = a.metricsCache[deviceID][1:]
+	deviceID := metric.DeviceID
+	tenantID := tenant.FromContext(ctx)
+	key := tenant.Scope(tenantID, deviceID)
+	shard := a.shards.get(key)
+
+	// Под блокировкой полосы только обновляем окно, сетевые вызовы выполняются после
+	shard.mu.Lock()
+	window, exists := shard.windows[key]
+	if !exists {
+		if err := a.reserveDevice(tenantID); err != nil {
+			shard.mu.Unlock()
+			return nil, err
+		}
+		window = a.newWindow(key)
+		shard.windows[key] = window
 	}
 
-	// Извлекаем значения RPS для анализа
-	rpsValues := make([]float64, len(a.metricsCache[deviceID]))
-	for i, m := range a.metricsCache[deviceID] {
-		rpsValues[i] = m.RPS
+	latest, hasLatest := window.Latest()
+	lateness := latest.Timestamp.Sub(metric.Timestamp)
+	if hasLatest && lateness > a.allowedLateness && !backfill {
+		shard.mu.Unlock()
+		return nil, a.handleLateMetric(tenantID, metric, lateness)
+	}
+	isLatest := !hasLatest || lateness <= 0
+	if !isLatest && !backfill {
+		metrics.RecordLateMetric("reordered")
 	}
 
-	// Вычисляем статистики
-	mean := a.stats.CalculateMean(rpsValues)
-	stdDev := a.stats.CalculateStdDev(rpsValues, mean)
-	zScore := a.stats.CalculateZScore(metric.RPS, mean, stdDev)
+	window.Push(metric)
+	evaluated := a.evaluate(window, metric)
+	shard.mu.Unlock()
 
-	isAnomaly := math.Abs(zScore) > a.threshold
+	result := &evaluated
+	result.Tenant = tenantID
+	result.ProcessedAt = receivedAt
+	isAnomaly := result.IsAnomaly
 
-	result := &models.AnalyticsResult{
-		Timestamp:      time.Now(),
-		DeviceID:       deviceID,
-		RollingAverage: mean,
-		StdDev:         stdDev,
-		ZScore:         zScore,
-		IsAnomaly:      isAnomaly,
-		CurrentValue:   metric.RPS,
+	// Сохраняем сырую метрику в историю
+	if a.history != nil {
+		if err := a.appendHistory(tenantID, metric); err != nil {
+			a.log().Errorf("Failed to append metric to history: %v", err)
+		}
 	}
 
-	// Сохраняем в Redis
-	key := "analytics:" + deviceID
-	if err := a.redis.Set(ctx, key, result, 5*time.Minute); err != nil {
-		a.logger.Errorf("Failed to cache analytics result: %v", err)
+	// Последним результатом устройства остается результат самой новой метрики
+	if isLatest {
+		if err := a.store.SaveResult(ctx, result); err != nil {
+			a.log().Errorf("Failed to save analytics result: %v", err)
+		}
 	}
 
-	// Отправляем аномалию в канал
-	if isAnomaly {
+	// Сохраняем аномалию в журнал и отправляем в канал
+	if isAnomaly && !backfill {
+		if err := a.store.SaveAnomaly(ctx, result); err != nil {
+			a.log().Errorf("Failed to save anomaly: %v", err)
+		}
+
 		select {
 		case a.anomalyChan <- *result:
-			a.logger.Infof("Anomaly detected for device %s: z-score=%.2f", deviceID, zScore)
+			a.log().Infof("Anomaly detected for device %s: z-score=%.2f", deviceID, result.ZScore)
 		default:
-			a.logger.Warn("Anomaly channel is full")
+			a.log().Warn("Anomaly channel is full")
 		}
+		a.publishAnomaly(*result)
 	}
 
 	return result, nil
 }
 
-// GetAnalytics возвращает аналитику для устройства
+// handleLateMetric применяет политику к метрике старше допустимого опоздания
+func (a *AnalyticsService) handleLateMetric(tenantID string, metric models.Metric, lateness time.Duration) error {
+	lateErr := &LateMetricError{DeviceID: metric.DeviceID, Lateness: lateness}
+
+	if a.latePolicy == LatePolicyHistory && a.history != nil {
+		if err := a.appendHistory(tenantID, metric); err != nil {
+			a.log().Errorf("Failed to divert late metric to history: %v", err)
+		} else {
+			lateErr.Diverted = true
+		}
+	}
+
+	if lateErr.Diverted {
+		metrics.RecordLateMetric("diverted")
+	} else {
+		metrics.RecordLateMetric("dropped")
+	}
+	return lateErr
+}
+
+// appendHistory сохраняет метрику в историю под ключом tenant.Scope,
+// чтобы ряды одноименных устройств разных арендаторов не смешивались
+func (a *AnalyticsService) appendHistory(tenantID string, metric models.Metric) error {
+	metric.DeviceID = tenant.Scope(tenantID, metric.DeviceID)
+	return a.history.Append(metric)
+}
+
+// GetAnalytics возвращает аналитику для устройства арендатора из контекста
 func (a *AnalyticsService) GetAnalytics(ctx context.Context, deviceID string) (*models.AnalyticsResult, error) {
-	a.mu.RLock()
-	defer a.mu.RUnlock()
+	tenantID := tenant.FromContext(ctx)
 
-	// Пытаемся получить из кэша
-	key := "analytics:" + deviceID
-	var result models.AnalyticsResult
-	if err := a.redis.Get(ctx, key, &result); err == nil {
-		return &result, nil
+	// Пытаемся получить из хранилища
+	if result, err := a.store.GetResult(ctx, tenantID, deviceID); err == nil {
+		return result, nil
 	}
 
 	// Если нет в кэше, вычисляем
-	metrics, exists := a.metricsCache[deviceID]
-	if !exists || len(metrics) == 0 {
+	key := tenant.Scope(tenantID, deviceID)
+	shard := a.shards.get(key)
+	shard.mu.RLock()
+	window, exists := shard.windows[key]
+	if !exists || window.Len() == 0 {
+		shard.mu.RUnlock()
 		return &models.AnalyticsResult{
 			DeviceID:       deviceID,
+			Tenant:         tenantID,
 			RollingAverage: 0,
 			StdDev:         0,
 			ZScore:         0,
@@ -125,27 +344,97 @@ func (a *AnalyticsService) GetAnalytics(ctx context.Context, deviceID string) (*
 		}, nil
 	}
 
-	rpsValues := make([]float64, len(metrics))
-	for i, m := range metrics {
-		rpsValues[i] = m.RPS
-	}
+	latestMetric, _ := window.Latest()
+	result := a.evaluate(window, latestMetric)
+	shard.mu.RUnlock()
 
-	latestMetric := metrics[len(metrics)-1]
-	mean := a.stats.CalculateMean(rpsValues)
-	stdDev := a.stats.CalculateStdDev(rpsValues, mean)
-	zScore := a.stats.CalculateZScore(latestMetric.RPS, mean, stdDev)
+	result.Tenant = tenantID
+	result.ProcessedAt = time.Now()
+	return &result, nil
+}
+
+// evaluate вычисляет результат метрики по статистике окна: z-score по rps
+// и по остальным полям из fieldResults. Вызывается под блокировкой полосы.
+func (a *AnalyticsService) evaluate(window *metricWindow, metric models.Metric) models.AnalyticsResult {
+	rpsStats := window.Stats(fieldRPS)
+	mean := rpsStats.Mean()
+	stdDev := rpsStats.StdDev()
+	zScore := a.stats.CalculateZScore(metric.RPS, mean, stdDev)
 
-	result = models.AnalyticsResult{
-		Timestamp:      time.Now(),
-		DeviceID:       deviceID,
+	result := models.AnalyticsResult{
+		Timestamp:      metric.Timestamp,
+		DeviceID:       metric.DeviceID,
 		RollingAverage: mean,
 		StdDev:         stdDev,
 		ZScore:         zScore,
 		IsAnomaly:      math.Abs(zScore) > a.threshold,
-		CurrentValue:   latestMetric.RPS,
+		CurrentValue:   metric.RPS,
+		Fields:         a.fieldResults(window, metric),
+	}
+	for _, field := range result.Fields {
+		result.IsAnomaly = result.IsAnomaly || field.IsAnomaly
 	}
+	return result
+}
 
-	return &result, nil
+// GetAnomalies возвращает последние аномалии устройства арендатора из
+// контекста, новые первыми
+func (a *AnalyticsService) GetAnomalies(ctx context.Context, deviceID string, limit int) ([]models.AnalyticsResult, error) {
+	return a.store.ListAnomalies(ctx, tenant.FromContext(ctx), deviceID, limit)
+}
+
+// Restore загружает сохраненные окна устройств из хранилища. Восстановленные
+// устройства учитываются в пределах арендаторов, но не отклоняются.
+func (a *AnalyticsService) Restore(ctx context.Context) (int, error) {
+	windows, err := a.store.LoadWindows(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for key, metrics := range windows {
+		tenantID, _, ok := tenant.Split(key)
+		if !ok {
+			a.log().Warnf("Skipping saved window with invalid key %q", key)
+			continue
+		}
+		window := a.newWindow(key)
+		for _, m := range metrics {
+			window.Push(m)
+		}
+
+		shard := a.shards.get(key)
+		shard.mu.Lock()
+		if _, exists := shard.windows[key]; !exists {
+			a.quotas.mu.Lock()
+			a.quotas.devices[tenantID]++
+			a.quotas.mu.Unlock()
+		}
+		shard.windows[key] = window
+		shard.mu.Unlock()
+		restored++
+	}
+	return restored, nil
+}
+
+// Snapshot сохраняет окна всех устройств в хранилище
+func (a *AnalyticsService) Snapshot(ctx context.Context) error {
+	for _, shard := range a.shards {
+		shard.mu.RLock()
+		windows := make(map[string][]models.Metric, len(shard.windows))
+		for key, window := range shard.windows {
+			windows[key] = window.Metrics()
+		}
+		shard.mu.RUnlock()
+
+		for key, metrics := range windows {
+			tenantID, deviceID, _ := tenant.Split(key)
+			if err := a.store.SaveWindow(ctx, tenantID, deviceID, metrics); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 // GetAnomalyChannel возвращает канал аномалий
@@ -153,41 +442,44 @@ func (a *AnalyticsService) GetAnomalyChannel() <-chan models.AnalyticsResult {
 	return a.anomalyChan
 }
 
-// GetSummary возвращает сводную статистику
-func (a *AnalyticsService) GetSummary() map[string]interface{} {
-	a.mu.RLock()
-	defer a.mu.RUnlock()
-
+// GetSummary возвращает сводную статистику по устройствам арендатора из контекста
+func (a *AnalyticsService) GetSummary(ctx context.Context) map[string]interface{} {
 	summary := make(map[string]interface{})
+	tenantID := tenant.FromContext(ctx)
+	prefix := tenant.Scope(tenantID, "")
 
+	var totalDevices int
 	var totalMetrics int
 	var anomalyCount int
 
-	for _, metrics := range a.metricsCache {
-		totalMetrics += len(metrics)
-
-		if len(metrics) > 0 {
-			rpsValues := make([]float64, len(metrics))
-			for i, m := range metrics {
-				rpsValues[i] = m.RPS
+	// Полосы блокируются по очереди, поэтому сводка не останавливает прием метрик
+	for _, shard := range a.shards {
+		shard.mu.RLock()
+		for key, window := range shard.windows {
+			if !strings.HasPrefix(key, prefix) {
+				continue
 			}
+			totalDevices++
+			totalMetrics += window.Len()
 
-			mean := a.stats.CalculateMean(rpsValues)
-			stdDev := a.stats.CalculateStdDev(rpsValues, mean)
-
-			if len(rpsValues) > 0 {
-				zScore := a.stats.CalculateZScore(rpsValues[len(rpsValues)-1], mean, stdDev)
-				if math.Abs(zScore) > a.threshold {
-					anomalyCount++
-				}
+			latestMetric, ok := window.Latest()
+			if !ok {
+				continue
+			}
+			if a.evaluate(window, latestMetric).IsAnomaly {
+				anomalyCount++
 			}
 		}
+		shard.mu.RUnlock()
 	}
 
-	summary["total_devices"] = len(a.metricsCache)
+	summary["total_devices"] = totalDevices
 	summary["total_metrics"] = totalMetrics
 	summary["anomaly_count"] = anomalyCount
-	summary["window_size"] = a.windowSize
+	summary["tenant"] = tenantID
+	window, _ := a.windowConfig(prefix)
+	summary["window_size"] = window.Size
+	summary["window_duration"] = window.Duration.String()
 	summary["threshold"] = a.threshold
 
 	return summary
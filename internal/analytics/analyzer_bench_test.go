@@ -0,0 +1,122 @@
+package analytics
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-service/internal/models"
+	"go-service/internal/storage"
+	"go-service/pkg/metrics"
+)
+
+// Запуск с разным числом процессоров показывает масштабирование приема:
+//
+//	go test ./internal/analytics -run='^$' -bench=. -cpu=1,2,4,8
+
+const benchDevices = 10000
+
+var benchDeviceIDs = func() []string {
+	ids := make([]string, benchDevices)
+	for i := range ids {
+		ids[i] = "device-" + strconv.Itoa(i)
+	}
+	return ids
+}()
+
+func benchMetric(deviceID string, i int) models.Metric {
+	return models.Metric{
+		DeviceID:  deviceID,
+		Timestamp: time.Unix(int64(i), 0),
+		CPU:       float64(i % 100),
+		Memory:    float64(i % 80),
+		RPS:       float64(100 + i%50),
+		Network:   float64(i % 10),
+	}
+}
+
+// newBenchService создает сервис без ограничения опоздания: параллельные
+// горутины отправляют метрики одного устройства не по порядку
+func newBenchService() *AnalyticsService {
+	metrics.InitMetrics()
+	service := NewAnalyticsService(storage.NewMemory(), 50, 2.0)
+	service.SetLateness(time.Duration(math.MaxInt64), LatePolicyDrop)
+	return service
+}
+
+// BenchmarkProcessMetricParallel поток метрик от множества устройств
+func BenchmarkProcessMetricParallel(b *testing.B) {
+	service := newBenchService()
+	ctx := context.Background()
+	var counter atomic.Int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(counter.Add(1))
+			if _, err := service.ProcessMetric(ctx, benchMetric(benchDeviceIDs[i%benchDevices], i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkProcessMetricSingleDevice худший случай: все метрики от одного устройства
+func BenchmarkProcessMetricSingleDevice(b *testing.B) {
+	service := newBenchService()
+	ctx := context.Background()
+	var counter atomic.Int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(counter.Add(1))
+			if _, err := service.ProcessMetric(ctx, benchMetric(benchDeviceIDs[0], i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkGetSummary построение сводки при заполненных окнах
+func BenchmarkGetSummary(b *testing.B) {
+	service := newBenchService()
+	ctx := context.Background()
+	for i := 0; i < benchDevices*10; i++ {
+		service.ProcessMetric(ctx, benchMetric(benchDeviceIDs[i%benchDevices], i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		service.GetSummary(ctx)
+	}
+}
+
+// BenchmarkGetSummaryDuringIngest сводка под параллельной нагрузкой приема
+func BenchmarkGetSummaryDuringIngest(b *testing.B) {
+	service := newBenchService()
+	ctx := context.Background()
+	for i := 0; i < benchDevices; i++ {
+		service.ProcessMetric(ctx, benchMetric(benchDeviceIDs[i], i))
+	}
+
+	var counter atomic.Int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(counter.Add(1))
+			if i%100 == 0 {
+				service.GetSummary(ctx)
+				continue
+			}
+			service.ProcessMetric(ctx, benchMetric(benchDeviceIDs[i%benchDevices], i))
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go-service/internal/idempotency"
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+	"go-service/pkg/metrics"
+)
+
+// SetIdempotency включает подавление повторов метрик с одинаковым MessageID.
+// Вызывается при настройке сервиса до начала приема.
+func (a *AnalyticsService) SetIdempotency(store idempotency.Store) {
+	a.idempotency = store
+}
+
+// processOnce обрабатывает метрику не больше одного раза за окно
+// дедупликации. Ключи различаются по арендаторам и устройствам.
+func (a *AnalyticsService) processOnce(ctx context.Context, metric models.Metric) (*models.AnalyticsResult, error) {
+	key := tenant.Scope(tenant.FromContext(ctx), metric.DeviceID) + ":" + metric.MessageID
+
+	original, err := a.idempotency.Reserve(ctx, key)
+	if errors.Is(err, idempotency.ErrInProgress) {
+		return nil, err
+	}
+	if err != nil {
+		// Недоступность хранилища ключей не должна останавливать прием
+		a.log().Errorf("Failed to check idempotency key %s: %v", key, err)
+		return a.process(ctx, metric, false)
+	}
+	if original != nil {
+		metrics.RecordDuplicateMetric()
+		original.Duplicate = true
+		return original, nil
+	}
+
+	result, err := a.process(ctx, metric, false)
+	var lateErr *LateMetricError
+	if errors.As(err, &lateErr) && lateErr.Diverted {
+		// Метрика уже в истории: повтор получит этот результат, а не
+		// запишет ее туда еще раз
+		a.complete(ctx, key, &models.AnalyticsResult{
+			Timestamp:   metric.Timestamp,
+			ProcessedAt: time.Now(),
+			DeviceID:    metric.DeviceID,
+			Tenant:      tenant.FromContext(ctx),
+			Diverted:    true,
+		})
+		return nil, err
+	}
+	if err != nil {
+		// Отклоненную метрику можно отправить повторно
+		if releaseErr := a.idempotency.Release(ctx, key); releaseErr != nil {
+			a.log().Errorf("Failed to release idempotency key %s: %v", key, releaseErr)
+		}
+		return nil, err
+	}
+
+	a.complete(ctx, key, result)
+	return result, nil
+}
+
+// complete сохраняет результат для повторов метрики
+func (a *AnalyticsService) complete(ctx context.Context, key string, result *models.AnalyticsResult) {
+	if err := a.idempotency.Complete(ctx, key, result); err != nil {
+		a.log().Errorf("Failed to store result for idempotency key %s: %v", key, err)
+	}
+}
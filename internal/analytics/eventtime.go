@@ -0,0 +1,73 @@
+package analytics
+
+import (
+	"fmt"
+	"time"
+
+	"go-service/internal/models"
+)
+
+// LatePolicy определяет судьбу метрик старше допустимого опоздания
+type LatePolicy string
+
+const (
+	// LatePolicyDrop отбрасывает опоздавшую метрику
+	LatePolicyDrop LatePolicy = "drop"
+	// LatePolicyHistory сохраняет опоздавшую метрику только в историю, без анализа
+	LatePolicyHistory LatePolicy = "history"
+)
+
+// DefaultAllowedLateness допустимое опоздание метрики по умолчанию
+const DefaultAllowedLateness = time.Minute
+
+// DefaultMaxClockSkew насколько метка метрики может опережать время
+// сервера по умолчанию
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// ParseLatePolicy разбирает политику опоздавших метрик
+func ParseLatePolicy(value string) (LatePolicy, error) {
+	switch policy := LatePolicy(value); policy {
+	case LatePolicyDrop, LatePolicyHistory:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown late metric policy %q", value)
+}
+
+// LateMetricError возвращается для метрики, опоздавшей больше допустимого
+// относительно последней метрики устройства
+type LateMetricError struct {
+	DeviceID string
+	Lateness time.Duration
+	Diverted bool // Метрика сохранена в историю
+}
+
+func (e *LateMetricError) Error() string {
+	return fmt.Sprintf("metric for device %s is %s late", e.DeviceID, e.Lateness)
+}
+
+// SetMaxClockSkew задает, насколько метка метрики может опережать время
+// приема. Метрика из будущего стала бы последней метрикой устройства, и все
+// следующие настоящие метрики считались бы опоздавшими, поэтому она
+// отклоняется как InvalidMetricError. Вызывается при настройке сервиса до
+// начала приема.
+func (a *AnalyticsService) SetMaxClockSkew(skew time.Duration) {
+	a.maxClockSkew = skew
+}
+
+// checkClockSkew проверяет, что метка не опережает время приема больше допустимого
+func (a *AnalyticsService) checkClockSkew(metric models.Metric, receivedAt time.Time) error {
+	if ahead := metric.Timestamp.Sub(receivedAt); ahead > a.maxClockSkew {
+		return &InvalidMetricError{
+			DeviceID: metric.DeviceID,
+			Reason:   fmt.Sprintf("timestamp is %s ahead of server time, at most %s allowed", ahead.Round(time.Second), a.maxClockSkew),
+		}
+	}
+	return nil
+}
+
+// SetLateness задает допустимое опоздание и политику для более поздних
+// метрик. Вызывается при настройке сервиса до начала приема.
+func (a *AnalyticsService) SetLateness(allowed time.Duration, policy LatePolicy) {
+	a.allowedLateness = allowed
+	a.latePolicy = policy
+}
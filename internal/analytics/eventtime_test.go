@@ -0,0 +1,137 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-service/internal/idempotency"
+	"go-service/internal/models"
+	"go-service/internal/storage"
+	"go-service/pkg/metrics"
+)
+
+// recordingHistory запоминает метрики, записанные в историю
+type recordingHistory struct {
+	metrics []models.Metric
+}
+
+func (h *recordingHistory) Append(metric models.Metric) error {
+	h.metrics = append(h.metrics, metric)
+	return nil
+}
+
+func newEventTimeService(policy LatePolicy) (*AnalyticsService, *recordingHistory) {
+	metrics.InitMetrics()
+	service := NewAnalyticsService(storage.NewMemory(), 10, 2.0)
+	service.SetLateness(time.Minute, policy)
+	history := &recordingHistory{}
+	service.SetHistory(history)
+	return service, history
+}
+
+func TestLateMetrics(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		name        string
+		policy      LatePolicy
+		lateness    time.Duration
+		wantErr     bool
+		wantDiverts bool
+	}{
+		{name: "reordered within lateness", policy: LatePolicyDrop, lateness: 30 * time.Second},
+		{name: "dropped", policy: LatePolicyDrop, lateness: 2 * time.Minute, wantErr: true},
+		{name: "diverted", policy: LatePolicyHistory, lateness: 2 * time.Minute, wantErr: true, wantDiverts: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, history := newEventTimeService(tt.policy)
+			ctx := context.Background()
+
+			if _, err := service.ProcessMetric(ctx, models.Metric{DeviceID: "d1", RPS: 10, Timestamp: now}); err != nil {
+				t.Fatal(err)
+			}
+			_, err := service.ProcessMetric(ctx, models.Metric{DeviceID: "d1", RPS: 20, Timestamp: now.Add(-tt.lateness)})
+
+			var lateErr *LateMetricError
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("ProcessMetric: %v", err)
+				}
+				window := service.shards.get("default:d1").windows["default:d1"]
+				if latest, _ := window.Latest(); !latest.Timestamp.Equal(now) || window.Len() != 2 {
+					t.Errorf("window has %d metrics, latest at %s; want 2 with latest at %s", window.Len(), latest.Timestamp, now)
+				}
+				if len(history.metrics) != 2 {
+					t.Errorf("history has %d metrics, want 2", len(history.metrics))
+				}
+				return
+			}
+			if !errors.As(err, &lateErr) {
+				t.Fatalf("ProcessMetric error = %v, want LateMetricError", err)
+			}
+			if lateErr.Diverted != tt.wantDiverts {
+				t.Errorf("Diverted = %v, want %v", lateErr.Diverted, tt.wantDiverts)
+			}
+			wantHistory := 1
+			if tt.wantDiverts {
+				wantHistory = 2
+			}
+			if len(history.metrics) != wantHistory {
+				t.Errorf("history has %d metrics, want %d", len(history.metrics), wantHistory)
+			}
+		})
+	}
+}
+
+func TestDivertedRetryIsNotDuplicated(t *testing.T) {
+	service, history := newEventTimeService(LatePolicyHistory)
+	service.SetIdempotency(idempotency.NewMemory(time.Minute))
+	ctx := context.Background()
+	now := time.Now()
+
+	if _, err := service.ProcessMetric(ctx, models.Metric{DeviceID: "d1", RPS: 10, Timestamp: now}); err != nil {
+		t.Fatal(err)
+	}
+	late := models.Metric{DeviceID: "d1", RPS: 20, Timestamp: now.Add(-time.Hour), MessageID: "m1"}
+	var lateErr *LateMetricError
+	if _, err := service.ProcessMetric(ctx, late); !errors.As(err, &lateErr) || !lateErr.Diverted {
+		t.Fatalf("late metric: %v, want diverted LateMetricError", err)
+	}
+
+	result, err := service.ProcessMetric(ctx, late)
+	if err != nil {
+		t.Fatalf("retry of diverted metric: %v", err)
+	}
+	if !result.Duplicate || !result.Diverted {
+		t.Errorf("retry result = %+v, want duplicate of diverted metric", result)
+	}
+	if len(history.metrics) != 2 {
+		t.Errorf("history has %d metrics, want 2 without the retry", len(history.metrics))
+	}
+}
+
+func TestMaxClockSkew(t *testing.T) {
+	service, history := newEventTimeService(LatePolicyDrop)
+	service.SetMaxClockSkew(time.Minute)
+	ctx := context.Background()
+
+	var invalidErr *InvalidMetricError
+	_, err := service.ProcessMetric(ctx, models.Metric{DeviceID: "d1", RPS: 10, Timestamp: time.Now().Add(time.Hour)})
+	if !errors.As(err, &invalidErr) {
+		t.Fatalf("metric from the future: %v, want InvalidMetricError", err)
+	}
+	if len(history.metrics) != 0 {
+		t.Errorf("rejected metric written to history")
+	}
+
+	// Отклоненная метрика не становится последней: текущие не считаются опоздавшими
+	if _, err := service.ProcessMetric(ctx, models.Metric{DeviceID: "d1", RPS: 10, Timestamp: time.Now().Add(30 * time.Second)}); err != nil {
+		t.Fatalf("metric within skew: %v", err)
+	}
+	if _, err := service.ProcessMetric(ctx, models.Metric{DeviceID: "d1", RPS: 10, Timestamp: time.Now()}); err != nil {
+		t.Fatalf("current metric after skewed one: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"go-service/internal/models"
+)
+
+// FieldSpec описание поля метрики: единица измерения и допустимый
+// диапазон. Метрика со значением вне диапазона отклоняется.
+type FieldSpec struct {
+	Unit string   `json:"unit,omitempty"`
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+}
+
+// ParseFieldSpec разбирает описание вида "celsius:-40..125", "V",
+// "0..24" или "percent:..100". Любая граница диапазона может быть опущена.
+func ParseFieldSpec(value string) (FieldSpec, error) {
+	var spec FieldSpec
+	unit, bounds, hasBounds := strings.Cut(value, ":")
+	if !hasBounds && strings.Contains(value, "..") {
+		unit, bounds, hasBounds = "", value, true
+	}
+	spec.Unit = strings.TrimSpace(unit)
+	if !hasBounds {
+		return spec, nil
+	}
+
+	low, high, ok := strings.Cut(bounds, "..")
+	if !ok {
+		return FieldSpec{}, fmt.Errorf("invalid field spec %q: range must be min..max", value)
+	}
+	parse := func(s string) (*float64, error) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(v) {
+			return nil, fmt.Errorf("invalid field spec %q: bad bound %q", value, s)
+		}
+		return &v, nil
+	}
+	var err error
+	if spec.Min, err = parse(low); err != nil {
+		return FieldSpec{}, err
+	}
+	if spec.Max, err = parse(high); err != nil {
+		return FieldSpec{}, err
+	}
+	if spec.Min != nil && spec.Max != nil && *spec.Min > *spec.Max {
+		return FieldSpec{}, fmt.Errorf("invalid field spec %q: min is greater than max", value)
+	}
+	return spec, nil
+}
+
+// String возвращает описание в формате ParseFieldSpec
+func (s FieldSpec) String() string {
+	if s.Min == nil && s.Max == nil {
+		return s.Unit
+	}
+	bound := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'g', -1, 64)
+	}
+	return s.Unit + ":" + bound(s.Min) + ".." + bound(s.Max)
+}
+
+// check проверяет значение по диапазону
+func (s FieldSpec) check(value float64) error {
+	if s.Min != nil && value < *s.Min {
+		return fmt.Errorf("below minimum %g", *s.Min)
+	}
+	if s.Max != nil && value > *s.Max {
+		return fmt.Errorf("above maximum %g", *s.Max)
+	}
+	return nil
+}
+
+// InvalidMetricError возвращается для метрики с недопустимым полем.
+// Повтор такой метрики не исправит.
+type InvalidMetricError struct {
+	DeviceID string
+	Field    string
+	Reason   string
+}
+
+func (e *InvalidMetricError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid metric for device %s: %s", e.DeviceID, e.Reason)
+	}
+	return fmt.Sprintf("invalid metric for device %s: field %s %s", e.DeviceID, e.Field, e.Reason)
+}
+
+// SetFieldSpec задает описание поля. Вызывается при настройке сервиса
+// до начала приема.
+func (a *AnalyticsService) SetFieldSpec(name string, spec FieldSpec) error {
+	if !models.ValidFieldName(name) {
+		return fmt.Errorf("invalid field name %q", name)
+	}
+	a.fieldSpecs[name] = spec
+	return nil
+}
+
+// FieldSpecs возвращает описания полей
+func (a *AnalyticsService) FieldSpecs() map[string]FieldSpec {
+	specs := make(map[string]FieldSpec, len(a.fieldSpecs))
+	for name, spec := range a.fieldSpecs {
+		specs[name] = spec
+	}
+	return specs
+}
+
+// validate приводит метрику к каноническому виду и проверяет поля
+func (a *AnalyticsService) validate(metric *models.Metric) error {
+	if err := metric.Normalize(); err != nil {
+		return &InvalidMetricError{DeviceID: metric.DeviceID, Reason: err.Error()}
+	}
+
+	var invalid *InvalidMetricError
+	metric.EachField(func(name string, value float64) {
+		if invalid != nil {
+			return
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			invalid = &InvalidMetricError{DeviceID: metric.DeviceID, Field: name, Reason: "is not a finite number"}
+			return
+		}
+		if spec, ok := a.fieldSpecs[name]; ok {
+			if err := spec.check(value); err != nil {
+				invalid = &InvalidMetricError{DeviceID: metric.DeviceID, Field: name, Reason: err.Error()}
+			}
+		}
+	})
+	if invalid != nil {
+		return invalid
+	}
+	return nil
+}
+
+// fieldResults вычисляет статистику дополнительных полей метрики и
+// основных полей с описанием. rps в основном результате не повторяется.
+// Вызывается под блокировкой полосы устройства.
+func (a *AnalyticsService) fieldResults(window *metricWindow, metric models.Metric) map[string]models.FieldResult {
+	var results map[string]models.FieldResult
+	add := func(name string, value float64) {
+		stats, ok := window.FieldStats(name)
+		if !ok {
+			return
+		}
+		zScore := a.stats.CalculateZScore(value, stats.Mean(), stats.StdDev())
+		if results == nil {
+			results = make(map[string]models.FieldResult)
+		}
+		results[name] = models.FieldResult{
+			Value:          value,
+			Unit:           a.fieldSpecs[name].Unit,
+			RollingAverage: stats.Mean(),
+			StdDev:         stats.StdDev(),
+			ZScore:         zScore,
+			IsAnomaly:      math.Abs(zScore) > a.threshold,
+			Samples:        stats.Count(),
+		}
+	}
+
+	metric.EachField(func(name string, value float64) {
+		if name == "rps" {
+			return
+		}
+		if _, described := a.fieldSpecs[name]; described || !models.IsLegacyField(name) {
+			add(name, value)
+		}
+	})
+	return results
+}
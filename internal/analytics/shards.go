@@ -0,0 +1,35 @@
+package analytics
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// shardCount количество полос блокировки для окон устройств
+const shardCount = 64
+
+// deviceShard группа окон устройств под отдельной блокировкой
+type deviceShard struct {
+	mu      sync.RWMutex
+	windows map[string]*metricWindow
+}
+
+// deviceShards распределяет устройства по полосам, чтобы метрики
+// разных устройств обрабатывались параллельно
+type deviceShards [shardCount]*deviceShard
+
+// newDeviceShards создает пустые полосы
+func newDeviceShards() *deviceShards {
+	var shards deviceShards
+	for i := range shards {
+		shards[i] = &deviceShard{windows: make(map[string]*metricWindow)}
+	}
+	return &shards
+}
+
+// get возвращает полосу для устройства
+func (s *deviceShards) get(deviceID string) *deviceShard {
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+	return s[h.Sum32()%shardCount]
+}
@@ -0,0 +1,72 @@
+package analytics
+
+import (
+	"context"
+	"sync"
+
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+)
+
+// anomalySubscribers рассылает аномалии подписчикам их арендатора
+type anomalySubscribers struct {
+	mu   sync.RWMutex
+	subs map[chan models.AnalyticsResult]anomalyFilter
+}
+
+// anomalyFilter выбирает аномалии для подписчика
+type anomalyFilter struct {
+	tenant  string
+	devices map[string]bool // Пусто — все устройства арендатора
+}
+
+func (f anomalyFilter) match(result models.AnalyticsResult) bool {
+	return f.tenant == result.Tenant && (len(f.devices) == 0 || f.devices[result.DeviceID])
+}
+
+// SubscribeAnomalies подписывает на аномалии арендатора из контекста с
+// буфером buffer. Если переданы devices, приходят аномалии только этих
+// устройств, и чужие аномалии не занимают буфер подписчика. Медленный
+// подписчик теряет аномалии, не задерживая обработку метрик. Возвращенную
+// функцию нужно вызвать для отписки, после нее канал закрывается.
+func (a *AnalyticsService) SubscribeAnomalies(ctx context.Context, buffer int, devices ...string) (<-chan models.AnalyticsResult, func()) {
+	ch := make(chan models.AnalyticsResult, buffer)
+	filter := anomalyFilter{tenant: tenant.FromContext(ctx)}
+	if len(devices) > 0 {
+		filter.devices = make(map[string]bool, len(devices))
+		for _, id := range devices {
+			filter.devices[id] = true
+		}
+	}
+
+	a.subscribers.mu.Lock()
+	a.subscribers.subs[ch] = filter
+	a.subscribers.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			a.subscribers.mu.Lock()
+			delete(a.subscribers.subs, ch)
+			a.subscribers.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publishAnomaly отправляет аномалию подписчикам без ожидания
+func (a *AnalyticsService) publishAnomaly(result models.AnalyticsResult) {
+	a.subscribers.mu.RLock()
+	defer a.subscribers.mu.RUnlock()
+
+	for ch, filter := range a.subscribers.subs {
+		if !filter.match(result) {
+			continue
+		}
+		select {
+		case ch <- result:
+		default:
+			a.log().Warnf("Anomaly subscriber is full, dropping anomaly for device %s", result.DeviceID)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+)
+
+func TestSubscribeAnomaliesFiltersDevices(t *testing.T) {
+	a := NewAnalyticsService(nil, 10, 2)
+	ctx := tenant.WithTenant(context.Background(), "acme")
+
+	// Буфер на одну аномалию: чужие устройства не должны его занимать
+	device, unsubscribeDevice := a.SubscribeAnomalies(ctx, 1, "sensor-1")
+	defer unsubscribeDevice()
+	all, unsubscribeAll := a.SubscribeAnomalies(ctx, 4)
+	defer unsubscribeAll()
+
+	for _, id := range []string{"sensor-2", "sensor-3", "sensor-1"} {
+		a.publishAnomaly(models.AnalyticsResult{Tenant: "acme", DeviceID: id})
+	}
+	a.publishAnomaly(models.AnalyticsResult{Tenant: "other", DeviceID: "sensor-1"})
+
+	if got := len(device); got != 1 {
+		t.Fatalf("device subscriber received %d anomalies, want 1", got)
+	}
+	if anomaly := <-device; anomaly.DeviceID != "sensor-1" {
+		t.Errorf("device subscriber received anomaly for %s, want sensor-1", anomaly.DeviceID)
+	}
+	if got := len(all); got != 3 {
+		t.Errorf("tenant subscriber received %d anomalies, want 3", got)
+	}
+}
@@ -0,0 +1,95 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go-service/internal/tenant"
+)
+
+// tenantQuotas пределы и учет устройств арендаторов
+type tenantQuotas struct {
+	mu         sync.Mutex
+	maxDevices map[string]int // Арендатор -> предел устройств, 0 без предела
+	devices    map[string]int // Арендатор -> устройств с окном
+}
+
+// QuotaExceededError возвращается для метрики нового устройства, когда
+// у арендатора уже максимальное количество устройств
+type QuotaExceededError struct {
+	Tenant     string
+	MaxDevices int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("tenant %s reached its limit of %d devices", e.Tenant, e.MaxDevices)
+}
+
+// TenantInfo настройки и использование арендатора
+type TenantInfo struct {
+	Tenant     string `json:"tenant"`
+	Devices    int    `json:"devices"`
+	MaxDevices int    `json:"max_devices"` // 0 — без ограничения
+	WindowSize int    `json:"window_size"`
+	Duration   string `json:"window_duration"`
+	Custom     bool   `json:"custom_window"` // Окно арендатора отличается от общего
+}
+
+// SetTenantWindow задает окно по умолчанию для устройств арендатора без
+// собственного окна
+func (a *AnalyticsService) SetTenantWindow(tenantID string, cfg WindowConfig) {
+	a.windowMu.Lock()
+	a.tenantWindows[tenantID] = cfg
+	a.windowMu.Unlock()
+
+	a.applyWindows()
+}
+
+// SetTenantQuota задает предел количества устройств арендатора, 0 снимает
+// предел. Уже принятые устройства сохраняются.
+func (a *AnalyticsService) SetTenantQuota(tenantID string, maxDevices int) {
+	a.quotas.mu.Lock()
+	defer a.quotas.mu.Unlock()
+	if maxDevices > 0 {
+		a.quotas.maxDevices[tenantID] = maxDevices
+	} else {
+		delete(a.quotas.maxDevices, tenantID)
+	}
+}
+
+// Tenant возвращает настройки и использование арендатора из контекста
+func (a *AnalyticsService) Tenant(ctx context.Context) TenantInfo {
+	tenantID := tenant.FromContext(ctx)
+
+	a.windowMu.RLock()
+	window, custom := a.tenantWindows[tenantID]
+	if !custom {
+		window = a.window
+	}
+	a.windowMu.RUnlock()
+
+	a.quotas.mu.Lock()
+	defer a.quotas.mu.Unlock()
+	return TenantInfo{
+		Tenant:     tenantID,
+		Devices:    a.quotas.devices[tenantID],
+		MaxDevices: a.quotas.maxDevices[tenantID],
+		WindowSize: window.Size,
+		Duration:   window.Duration.String(),
+		Custom:     custom,
+	}
+}
+
+// reserveDevice учитывает новое устройство арендатора или возвращает
+// *QuotaExceededError. Вызывается под блокировкой полосы устройства.
+func (a *AnalyticsService) reserveDevice(tenantID string) error {
+	a.quotas.mu.Lock()
+	defer a.quotas.mu.Unlock()
+	limit := a.quotas.maxDevices[tenantID]
+	if limit > 0 && a.quotas.devices[tenantID] >= limit {
+		return &QuotaExceededError{Tenant: tenantID, MaxDevices: limit}
+	}
+	a.quotas.devices[tenantID]++
+	return nil
+}
@@ -0,0 +1,334 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-service/internal/models"
+)
+
+// Индексы полей метрики, для которых ведется скользящая статистика
+const (
+	fieldCPU = iota
+	fieldMemory
+	fieldRPS
+	fieldNetwork
+	fieldCount
+)
+
+// fieldValues извлекает значения полей метрики в порядке индексов
+func fieldValues(m models.Metric) [fieldCount]float64 {
+	return [fieldCount]float64{m.CPU, m.Memory, m.RPS, m.Network}
+}
+
+// fieldIndex возвращает индекс основного поля по имени
+func fieldIndex(name string) (int, bool) {
+	switch name {
+	case "cpu":
+		return fieldCPU, true
+	case "memory":
+		return fieldMemory, true
+	case "rps":
+		return fieldRPS, true
+	case "network":
+		return fieldNetwork, true
+	}
+	return 0, false
+}
+
+// RunningStats накапливает среднее и дисперсию по алгоритму Уэлфорда
+// с поддержкой удаления значений для скользящего окна
+type RunningStats struct {
+	n    int
+	mean float64
+	m2   float64
+}
+
+// Add добавляет значение в статистику
+func (s *RunningStats) Add(x float64) {
+	s.n++
+	delta := x - s.mean
+	s.mean += delta / float64(s.n)
+	s.m2 += delta * (x - s.mean)
+}
+
+// Remove исключает ранее добавленное значение из статистики
+func (s *RunningStats) Remove(x float64) {
+	if s.n <= 1 {
+		s.Reset()
+		return
+	}
+	s.n--
+	delta := x - s.mean
+	s.mean -= delta / float64(s.n)
+	s.m2 -= delta * (x - s.mean)
+	// Погрешность округления не должна давать отрицательную дисперсию
+	if s.m2 < 0 {
+		s.m2 = 0
+	}
+}
+
+// Reset обнуляет статистику
+func (s *RunningStats) Reset() {
+	*s = RunningStats{}
+}
+
+// Count возвращает количество значений
+func (s *RunningStats) Count() int {
+	return s.n
+}
+
+// Mean возвращает среднее значение
+func (s *RunningStats) Mean() float64 {
+	return s.mean
+}
+
+// StdDev возвращает выборочное стандартное отклонение
+func (s *RunningStats) StdDev() float64 {
+	if s.n < 2 {
+		return 0
+	}
+	return math.Sqrt(s.m2 / float64(s.n-1))
+}
+
+// WindowConfig ограничивает окно устройства количеством метрик и/или
+// промежутком времени по временным меткам метрик. Нулевое значение
+// ограничения отключает его.
+type WindowConfig struct {
+	Size     int
+	Duration time.Duration
+}
+
+// ParseWindowConfig разбирает окно вида "100", "15m" или "100/15m"
+func ParseWindowConfig(value string) (WindowConfig, error) {
+	var cfg WindowConfig
+	for _, part := range strings.Split(value, "/") {
+		part = strings.TrimSpace(part)
+		if size, err := strconv.Atoi(part); err == nil {
+			cfg.Size = size
+			continue
+		}
+		duration, err := time.ParseDuration(part)
+		if err != nil {
+			return WindowConfig{}, fmt.Errorf("invalid window %q: expected sample count or duration", value)
+		}
+		cfg.Duration = duration
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate проверяет, что окно ограничено хотя бы одним способом
+func (c WindowConfig) Validate() error {
+	if c.Size < 0 || c.Duration < 0 {
+		return errors.New("window limits must not be negative")
+	}
+	if c.Size == 0 && c.Duration == 0 {
+		return errors.New("window must have a size or a duration")
+	}
+	return nil
+}
+
+// String возвращает окно в формате ParseWindowConfig
+func (c WindowConfig) String() string {
+	switch {
+	case c.Size > 0 && c.Duration > 0:
+		return fmt.Sprintf("%d/%s", c.Size, c.Duration)
+	case c.Duration > 0:
+		return c.Duration.String()
+	default:
+		return strconv.Itoa(c.Size)
+	}
+}
+
+// metricWindow хранит последние метрики устройства в кольцевом буфере
+// и поддерживает статистику по каждому полю за O(1) на метрику.
+// Статистика дополнительного поля ведется только по метрикам, в которых
+// оно есть. Буфер растет до лимита по количеству или до maxSamples, если
+// окно ограничено только временем.
+type metricWindow struct {
+	buf        []models.Metric
+	start      int
+	size       int
+	stats      [fieldCount]RunningStats
+	extra      map[string]*RunningStats // Статистика дополнительных полей
+	removals   int
+	limits     WindowConfig
+	maxSamples int
+}
+
+// initialWindowCapacity начальная емкость окна, ограниченного временем
+const initialWindowCapacity = 16
+
+// newMetricWindow создает окно с заданными ограничениями
+func newMetricWindow(limits WindowConfig, maxSamples int) *metricWindow {
+	w := &metricWindow{limits: limits, maxSamples: maxSamples}
+	w.buf = make([]models.Metric, min(initialWindowCapacity, w.capacityLimit()))
+	return w
+}
+
+// capacityLimit максимальная емкость буфера
+func (w *metricWindow) capacityLimit() int {
+	limit := w.maxSamples
+	if w.limits.Size > 0 && (limit <= 0 || w.limits.Size < limit) {
+		limit = w.limits.Size
+	}
+	return max(limit, 1)
+}
+
+// SetLimits меняет ограничения окна, сразу вытесняет лишнее и сжимает
+// буфер до нового лимита
+func (w *metricWindow) SetLimits(limits WindowConfig) {
+	w.limits = limits
+	for w.size > w.capacityLimit() {
+		w.evict()
+	}
+	if len(w.buf) > w.capacityLimit() {
+		w.resize(w.capacityLimit())
+	}
+	w.trim()
+}
+
+// Push добавляет метрику в порядке временных меток, вытесняя старые
+// за пределами окна. Опоздавшая метрика сдвигается на свое место за
+// время, пропорциональное числу более новых метрик.
+func (w *metricWindow) Push(m models.Metric) {
+	if w.size >= w.capacityLimit() {
+		w.evict()
+	} else if w.size == len(w.buf) {
+		w.resize(min(2*len(w.buf), w.capacityLimit()))
+	}
+
+	i := w.size
+	for ; i > 0; i-- {
+		prev := w.buf[(w.start+i-1)%len(w.buf)]
+		if !m.Timestamp.Before(prev.Timestamp) {
+			break
+		}
+		w.buf[(w.start+i)%len(w.buf)] = prev
+	}
+	w.buf[(w.start+i)%len(w.buf)] = m
+	w.size++
+
+	w.add(m)
+	w.trim()
+}
+
+// trim вытесняет метрики старше временного окна относительно последней
+func (w *metricWindow) trim() {
+	if w.limits.Duration <= 0 || w.size == 0 {
+		return
+	}
+	latest, _ := w.Latest()
+	cutoff := latest.Timestamp.Add(-w.limits.Duration)
+	for w.size > 1 && w.buf[w.start].Timestamp.Before(cutoff) {
+		w.evict()
+	}
+}
+
+// resize переносит метрики окна в буфер емкостью capacity >= size
+func (w *metricWindow) resize(capacity int) {
+	buf := make([]models.Metric, capacity)
+	for i := 0; i < w.size; i++ {
+		buf[i] = w.buf[(w.start+i)%len(w.buf)]
+	}
+	w.buf = buf
+	w.start = 0
+}
+
+// evict удаляет самую старую метрику из окна
+func (w *metricWindow) evict() {
+	old := w.buf[w.start]
+	w.buf[w.start] = models.Metric{}
+	w.start = (w.start + 1) % len(w.buf)
+	w.size--
+
+	values := fieldValues(old)
+	for i := range w.stats {
+		w.stats[i].Remove(values[i])
+	}
+	for name, value := range old.Values {
+		stats := w.extra[name]
+		stats.Remove(value)
+		if stats.Count() == 0 {
+			delete(w.extra, name)
+		}
+	}
+
+	// Периодически пересчитываем статистику с нуля, чтобы
+	// ошибка округления от удалений не накапливалась
+	w.removals++
+	if w.removals >= len(w.buf) {
+		w.recompute()
+	}
+}
+
+// recompute пересчитывает статистику по содержимому окна
+func (w *metricWindow) recompute() {
+	w.removals = 0
+	for i := range w.stats {
+		w.stats[i].Reset()
+	}
+	w.extra = nil
+	for i := 0; i < w.size; i++ {
+		w.add(w.buf[(w.start+i)%len(w.buf)])
+	}
+}
+
+// add учитывает поля метрики в статистике
+func (w *metricWindow) add(m models.Metric) {
+	values := fieldValues(m)
+	for i := range w.stats {
+		w.stats[i].Add(values[i])
+	}
+	for name, value := range m.Values {
+		stats, exists := w.extra[name]
+		if !exists {
+			if w.extra == nil {
+				w.extra = make(map[string]*RunningStats)
+			}
+			stats = &RunningStats{}
+			w.extra[name] = stats
+		}
+		stats.Add(value)
+	}
+}
+
+// Len возвращает количество метрик в окне
+func (w *metricWindow) Len() int {
+	return w.size
+}
+
+// Latest возвращает метрику с самой поздней временной меткой
+func (w *metricWindow) Latest() (models.Metric, bool) {
+	if w.size == 0 {
+		return models.Metric{}, false
+	}
+	return w.buf[(w.start+w.size-1)%len(w.buf)], true
+}
+
+// Metrics возвращает копию метрик окна от старых к новым
+func (w *metricWindow) Metrics() []models.Metric {
+	metrics := make([]models.Metric, w.size)
+	for i := range metrics {
+		metrics[i] = w.buf[(w.start+i)%len(w.buf)]
+	}
+	return metrics
+}
+
+// Stats возвращает статистику по индексу поля
+func (w *metricWindow) Stats(field int) *RunningStats {
+	return &w.stats[field]
+}
+
+// FieldStats возвращает статистику поля по имени, если поле есть в окне
+func (w *metricWindow) FieldStats(name string) (*RunningStats, bool) {
+	if i, ok := fieldIndex(name); ok {
+		return &w.stats[i], true
+	}
+	stats, ok := w.extra[name]
+	return stats, ok
+}
@@ -0,0 +1,179 @@
+package analytics
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"go-service/internal/models"
+)
+
+// pushSamples добавляет в окно n метрик с возрастающими метками
+func pushSamples(w *metricWindow, from, n int) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := from; i < from+n; i++ {
+		w.Push(models.Metric{DeviceID: "device", CPU: float64(i), Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+}
+
+func TestWindowShrinkKeepsNewLimit(t *testing.T) {
+	w := newMetricWindow(WindowConfig{Size: 100}, DefaultMaxWindowSamples)
+	pushSamples(w, 0, 64)
+
+	w.SetLimits(WindowConfig{Size: 10})
+	if w.Len() != 10 {
+		t.Fatalf("after shrink Len() = %d, want 10", w.Len())
+	}
+
+	pushSamples(w, 64, 36)
+	if w.Len() != 10 {
+		t.Fatalf("after 36 more pushes Len() = %d, want 10", w.Len())
+	}
+	if len(w.buf) != 10 {
+		t.Errorf("buffer capacity = %d, want 10", len(w.buf))
+	}
+
+	metrics := w.Metrics()
+	if first, last := metrics[0].CPU, metrics[len(metrics)-1].CPU; first != 90 || last != 99 {
+		t.Errorf("window holds %v..%v, want 90..99", first, last)
+	}
+	if mean := w.Stats(fieldCPU).Mean(); mean != 94.5 {
+		t.Errorf("cpu mean = %v, want 94.5", mean)
+	}
+}
+
+func TestWindowGrowAfterShrink(t *testing.T) {
+	w := newMetricWindow(WindowConfig{Size: 100}, DefaultMaxWindowSamples)
+	pushSamples(w, 0, 50)
+	w.SetLimits(WindowConfig{Size: 5})
+	w.SetLimits(WindowConfig{Size: 40})
+
+	pushSamples(w, 50, 100)
+	if w.Len() != 40 {
+		t.Fatalf("Len() = %d, want 40", w.Len())
+	}
+	if latest, _ := w.Latest(); latest.CPU != 149 {
+		t.Errorf("latest cpu = %v, want 149", latest.CPU)
+	}
+}
+
+// twoPass возвращает среднее и выборочное отклонение прямым расчетом
+func twoPass(values []float64) (float64, float64) {
+	s := NewStatistics()
+	mean := s.CalculateMean(values)
+	return mean, s.CalculateStdDev(values, mean)
+}
+
+// closeTo сравнивает с относительной погрешностью tol
+func closeTo(got, want, tol float64) bool {
+	return math.Abs(got-want) <= tol*math.Max(1, math.Abs(want))
+}
+
+func TestRunningStatsAddRemove(t *testing.T) {
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9, 13, -3}
+	var s RunningStats
+	for _, v := range values {
+		s.Add(v)
+	}
+	mean, stdDev := twoPass(values)
+	if s.Count() != len(values) || !closeTo(s.Mean(), mean, 1e-12) || !closeTo(s.StdDev(), stdDev, 1e-12) {
+		t.Fatalf("after add: n=%d mean=%v stddev=%v, want n=%d mean=%v stddev=%v",
+			s.Count(), s.Mean(), s.StdDev(), len(values), mean, stdDev)
+	}
+
+	// Удаление в порядке добавления, как при вытеснении из окна
+	for i, v := range values[:len(values)-2] {
+		s.Remove(v)
+		mean, stdDev := twoPass(values[i+1:])
+		if !closeTo(s.Mean(), mean, 1e-12) || !closeTo(s.StdDev(), stdDev, 1e-12) {
+			t.Fatalf("after removing %d values: mean=%v stddev=%v, want %v and %v", i+1, s.Mean(), s.StdDev(), mean, stdDev)
+		}
+	}
+
+	s.Remove(values[len(values)-2])
+	if s.Count() != 1 || s.Mean() != values[len(values)-1] || s.StdDev() != 0 {
+		t.Errorf("single value: n=%d mean=%v stddev=%v, want 1, %v and 0", s.Count(), s.Mean(), s.StdDev(), values[len(values)-1])
+	}
+	s.Remove(values[len(values)-1])
+	if s != (RunningStats{}) {
+		t.Errorf("after removing all values stats = %+v, want zero", s)
+	}
+}
+
+func TestRunningStatsLargeOffset(t *testing.T) {
+	// Сумма квадратов при смещении 1e9 теряет дисперсию целиком
+	const offset = 1e9
+	var s RunningStats
+	for _, v := range []float64{4, 7, 13, 16} {
+		s.Add(offset + v)
+	}
+	if !closeTo(s.Mean(), offset+10, 1e-15) || !closeTo(s.StdDev(), math.Sqrt(30), 1e-9) {
+		t.Errorf("mean=%v stddev=%v, want %v and %v", s.Mean(), s.StdDev(), offset+10, math.Sqrt(30))
+	}
+}
+
+func TestWindowStatsStableOnEvict(t *testing.T) {
+	const size = 50
+	w := newMetricWindow(WindowConfig{Size: size}, DefaultMaxWindowSamples)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 20000; i++ {
+		w.Push(models.Metric{
+			DeviceID:  "device",
+			CPU:       1e9 + rng.NormFloat64(),
+			Memory:    rng.Float64() * 1e-6,
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	metrics := w.Metrics()
+	cpu := make([]float64, len(metrics))
+	memory := make([]float64, len(metrics))
+	for i, m := range metrics {
+		cpu[i], memory[i] = m.CPU, m.Memory
+	}
+	for _, tt := range []struct {
+		field  int
+		values []float64
+	}{{fieldCPU, cpu}, {fieldMemory, memory}} {
+		mean, stdDev := twoPass(tt.values)
+		stats := w.Stats(tt.field)
+		if stats.Count() != size || !closeTo(stats.Mean(), mean, 1e-12) || !closeTo(stats.StdDev(), stdDev, 1e-7) {
+			t.Errorf("field %d: n=%d mean=%v stddev=%v, want n=%d mean=%v stddev=%v",
+				tt.field, stats.Count(), stats.Mean(), stats.StdDev(), size, mean, stdDev)
+		}
+	}
+}
+
+func TestWindowExtraFieldStats(t *testing.T) {
+	w := newMetricWindow(WindowConfig{Size: 3}, DefaultMaxWindowSamples)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	push := func(i int, values map[string]float64) {
+		w.Push(models.Metric{DeviceID: "device", Values: values, Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+
+	push(0, map[string]float64{"temperature": 20, "voltage": 3.3})
+	push(1, map[string]float64{"temperature": 22})
+	push(2, nil)
+
+	// Поле учитывается только по метрикам, в которых оно есть
+	temperature, ok := w.FieldStats("temperature")
+	if !ok || temperature.Count() != 2 || temperature.Mean() != 21 || !closeTo(temperature.StdDev(), math.Sqrt2, 1e-12) {
+		t.Fatalf("temperature stats = %+v, ok %v, want n=2 mean=21", temperature, ok)
+	}
+
+	// Вытеснение единственной метрики с полем убирает его статистику
+	push(3, map[string]float64{"temperature": 30})
+	if _, ok := w.FieldStats("voltage"); ok {
+		t.Error("voltage stats remain after its only metric was evicted")
+	}
+	temperature, _ = w.FieldStats("temperature")
+	if temperature.Count() != 2 || temperature.Mean() != 26 {
+		t.Errorf("temperature after evict: n=%d mean=%v, want 2 and 26", temperature.Count(), temperature.Mean())
+	}
+	if cpu, ok := w.FieldStats("cpu"); !ok || cpu.Count() != 3 {
+		t.Errorf("cpu stats count = %d, want 3 metrics in window", cpu.Count())
+	}
+}
@@ -0,0 +1,126 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go-service/internal/auth"
+	"go-service/internal/tenant"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// Действия журнала
+const (
+	ActionWindowSet   = "window.set"
+	ActionWindowReset = "window.reset"
+	ActionKeyIssue    = "key.issue"
+	ActionKeyRevoke   = "key.revoke"
+	ActionBackfill    = "backfill.import"
+)
+
+// Anonymous действующее лицо без ключа: аутентификация выключена
+const Anonymous = "anonymous"
+
+// Entry запись журнала. Before и After — состояние объекта до и после
+// действия, пустые для созданных и удаленных объектов.
+type Entry struct {
+	ID        string          `json:"id"`
+	Time      time.Time       `json:"time"`
+	Tenant    string          `json:"tenant"`
+	Actor     string          `json:"actor"` // Идентификатор ключа, jwt:<subject> или anonymous
+	ActorName string          `json:"actor_name,omitempty"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+}
+
+// Filter условия выборки записей. Пустые поля не ограничивают выборку.
+type Filter struct {
+	Tenant string
+	Actor  string
+	Action string
+	Target string
+	From   time.Time
+	To     time.Time
+	Limit  int // 0 — без ограничения
+}
+
+// Match проверяет запись по условиям фильтра, кроме Limit
+func (f Filter) Match(e Entry) bool {
+	return (f.Tenant == "" || e.Tenant == f.Tenant) &&
+		(f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || !e.Time.After(f.To))
+}
+
+// Store хранилище журнала только для добавления
+type Store interface {
+	// Append добавляет запись и заполняет ее ID
+	Append(ctx context.Context, entry *Entry) error
+	// Query возвращает записи по фильтру от новых к старым
+	Query(ctx context.Context, filter Filter) ([]Entry, error)
+}
+
+// Log журнал административных действий. Нулевой *Log ничего не пишет.
+type Log struct {
+	store  Store
+	logger *zap.SugaredLogger
+}
+
+// NewLog создает журнал поверх хранилища
+func NewLog(store Store, logger *zap.SugaredLogger) *Log {
+	return &Log{store: store, logger: logger}
+}
+
+// Record записывает действие от имени ключа и арендатора из контекста.
+// Ошибка записи не отменяет действие: она пишется в лог и считается
+// в app_audit_write_errors_total.
+func (l *Log) Record(ctx context.Context, action, target string, before, after interface{}) {
+	if l == nil {
+		return
+	}
+
+	entry := Entry{
+		Time:   time.Now().UTC(),
+		Tenant: tenant.FromContext(ctx),
+		Actor:  Anonymous,
+		Action: action,
+		Target: target,
+		Before: l.marshal(before),
+		After:  l.marshal(after),
+	}
+	if key, ok := auth.FromContext(ctx); ok {
+		entry.Actor = key.ID
+		entry.ActorName = key.Name
+	}
+
+	// Запрос мог быть отменен клиентом, но действие уже выполнено
+	if err := l.store.Append(context.WithoutCancel(ctx), &entry); err != nil {
+		metrics.RecordAuditWriteError()
+		l.logger.Errorf("Failed to write audit entry %s %s by %s: %v", action, target, entry.Actor, err)
+	}
+}
+
+// Query возвращает записи по фильтру от новых к старым
+func (l *Log) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	return l.store.Query(ctx, filter)
+}
+
+// marshal кодирует состояние объекта, nil остается пустым
+func (l *Log) marshal(state interface{}) json.RawMessage {
+	if state == nil {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		l.logger.Warnf("Failed to encode audit state: %v", err)
+		return nil
+	}
+	return data
+}
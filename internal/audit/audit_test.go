@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-service/internal/auth"
+	"go-service/internal/tenant"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+func openTestLog(t *testing.T, path string) (*Log, *File) {
+	t.Helper()
+	metrics.InitMetrics()
+	store, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return NewLog(store, zap.NewNop().Sugar()), store
+}
+
+func TestLogRecord(t *testing.T) {
+	log, _ := openTestLog(t, filepath.Join(t.TempDir(), "audit.log"))
+	key := &auth.Key{ID: "k1", Name: "ops", Tenant: "acme"}
+	ctx := auth.WithKey(tenant.WithTenant(context.Background(), "acme"), key)
+
+	before := map[string]string{"window": "50"}
+	after := map[string]string{"window": "15m"}
+	log.Record(ctx, ActionWindowSet, "d1", before, after)
+	log.Record(ctx, ActionKeyIssue, "k2", nil, map[string]string{"name": "ingest"})
+	log.Record(context.Background(), ActionWindowReset, "d2", after, nil)
+
+	entries, err := log.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+
+	// Записи возвращаются от новых к старым
+	reset, issue, set := entries[0], entries[1], entries[2]
+	if set.ID != "1" || set.Tenant != "acme" || set.Actor != "k1" || set.ActorName != "ops" ||
+		set.Action != ActionWindowSet || set.Target != "d1" || set.Time.IsZero() {
+		t.Errorf("window.set entry = %+v", set)
+	}
+	checkState(t, "before", set.Before, before)
+	checkState(t, "after", set.After, after)
+
+	if issue.Before != nil {
+		t.Errorf("created object has before state %s", issue.Before)
+	}
+	if reset.After != nil {
+		t.Errorf("reset object has after state %s", reset.After)
+	}
+	if reset.Actor != Anonymous || reset.Tenant != tenant.Default {
+		t.Errorf("entry without key: actor %q, tenant %q; want anonymous in default tenant", reset.Actor, reset.Tenant)
+	}
+}
+
+// checkState сравнивает сохраненное состояние объекта с ожидаемым
+func checkState(t *testing.T, name string, got json.RawMessage, want map[string]string) {
+	t.Helper()
+	var state map[string]string
+	if err := json.Unmarshal(got, &state); err != nil {
+		t.Fatalf("%s state %s: %v", name, got, err)
+	}
+	if len(state) != len(want) {
+		t.Fatalf("%s state = %v, want %v", name, state, want)
+	}
+	for k, v := range want {
+		if state[k] != v {
+			t.Errorf("%s state = %v, want %v", name, state, want)
+		}
+	}
+}
+
+func TestFileContinuesAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, store := openTestLog(t, path)
+	log.Record(context.Background(), ActionKeyRevoke, "k1", nil, nil)
+	store.Close()
+
+	log, _ = openTestLog(t, path)
+	log.Record(context.Background(), ActionKeyRevoke, "k2", nil, nil)
+	entries, err := log.Query(context.Background(), Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != "2" || entries[1].ID != "1" {
+		t.Errorf("entries after reopen = %+v, want IDs 2 and 1", entries)
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	_, store := openTestLog(t, filepath.Join(t.TempDir(), "audit.log"))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, e := range []Entry{
+		{Tenant: "acme", Actor: "k1", Action: ActionWindowSet, Target: "d1"},
+		{Tenant: "acme", Actor: "k2", Action: ActionKeyIssue, Target: "k3"},
+		{Tenant: "globex", Actor: "k4", Action: ActionWindowSet, Target: "d1"},
+		{Tenant: "globex", Actor: "k4", Action: ActionBackfill, Target: "metrics.csv"},
+	} {
+		e.Time = base.Add(time.Duration(i) * time.Hour)
+		if err := store.Append(context.Background(), &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "all", filter: Filter{}, want: []string{"4", "3", "2", "1"}},
+		{name: "tenant", filter: Filter{Tenant: "acme"}, want: []string{"2", "1"}},
+		{name: "other tenant", filter: Filter{Tenant: "globex"}, want: []string{"4", "3"}},
+		{name: "unknown tenant", filter: Filter{Tenant: "initech"}, want: nil},
+		{name: "actor", filter: Filter{Actor: "k4"}, want: []string{"4", "3"}},
+		{name: "action and target", filter: Filter{Action: ActionWindowSet, Target: "d1"}, want: []string{"3", "1"}},
+		{name: "tenant and action", filter: Filter{Tenant: "acme", Action: ActionWindowSet}, want: []string{"1"}},
+		{name: "time range", filter: Filter{From: base.Add(time.Hour), To: base.Add(2 * time.Hour)}, want: []string{"3", "2"}},
+		{name: "limit keeps newest", filter: Filter{Limit: 1}, want: []string{"4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := store.Query(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got IDs %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got IDs %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// failingStore хранилище, отклоняющее запись
+type failingStore struct{}
+
+func (failingStore) Append(context.Context, *Entry) error { return errors.New("disk full") }
+
+func (failingStore) Query(context.Context, Filter) ([]Entry, error) { return nil, nil }
+
+func TestRecordIgnoresStoreErrors(t *testing.T) {
+	metrics.InitMetrics()
+	log := NewLog(failingStore{}, zap.NewNop().Sugar())
+	// Ошибка записи не должна прерывать административное действие
+	log.Record(context.Background(), ActionKeyRevoke, "k1", nil, nil)
+
+	var nilLog *Log
+	nilLog.Record(context.Background(), ActionKeyRevoke, "k1", nil, nil)
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// File журнал в файле NDJSON на диске узла. Файл открыт только на
+// добавление, каждая запись сбрасывается на диск до возврата из Append.
+type File struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	seq  int // Номер последней записи
+}
+
+// OpenFile открывает или создает файл журнала
+func OpenFile(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Недописанная при сбое строка завершается, чтобы не склеиться
+	// со следующей записью
+	if err := terminate(path, file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &File{path: path, file: file}
+	err = f.scan(func(Entry) bool {
+		f.seq++
+		return true
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Append дописывает запись в конец файла. ID записи — ее номер в файле.
+func (f *File) Append(ctx context.Context, entry *Entry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry.ID = strconv.Itoa(f.seq + 1)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	f.seq++
+	return nil
+}
+
+// Query читает файл целиком и возвращает записи от новых к старым
+func (f *File) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var entries []Entry
+	err := f.scan(func(entry Entry) bool {
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// Close закрывает файл журнала
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// scan читает записи файла по порядку, пока fn возвращает true.
+// Недописанная при сбое последняя строка пропускается.
+func (f *File) scan(fn func(Entry) bool) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !fn(entry) {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read audit log %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// terminate дописывает перевод строки, если файл не заканчивается им
+func terminate(path string, file *os.File) error {
+	r, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	info, err := r.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := r.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = file.Write([]byte{'\n'})
+	return err
+}
@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"go-service/internal/cache"
+)
+
+// stream поток Redis с записями журнала
+const stream = "audit"
+
+// scanBatch сколько записей потока читать за раз при выборке
+const scanBatch = 500
+
+// Redis журнал в потоке Redis, общий для всех узлов сервиса. Записи
+// потока не обрезаются, их срок хранения задается политикой Redis.
+type Redis struct {
+	client *cache.RedisClient
+}
+
+// NewRedis создает журнал в Redis
+func NewRedis(client *cache.RedisClient) *Redis {
+	return &Redis{client: client}
+}
+
+// Append добавляет запись в поток. ID записи — идентификатор в потоке.
+func (r *Redis) Append(ctx context.Context, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	id, err := r.client.StreamAdd(ctx, stream, map[string]interface{}{"entry": data})
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+	return nil
+}
+
+// Query читает поток от новых записей к старым. Время записи совпадает
+// с миллисекундами ее идентификатора, поэтому From и To ограничивают
+// диапазон чтения.
+func (r *Redis) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	start, end := "-", "+"
+	if !filter.From.IsZero() {
+		start = strconv.FormatInt(filter.From.UnixMilli(), 10)
+	}
+	if !filter.To.IsZero() {
+		end = strconv.FormatInt(filter.To.UnixMilli(), 10)
+	}
+
+	var entries []Entry
+	for {
+		messages, err := r.client.StreamRevRange(ctx, stream, end, start, scanBatch)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			raw, _ := msg.Values["entry"].(string)
+			var entry Entry
+			if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+				continue
+			}
+			entry.ID = msg.ID
+			if !filter.Match(entry) {
+				continue
+			}
+			entries = append(entries, entry)
+			if filter.Limit > 0 && len(entries) >= filter.Limit {
+				return entries, nil
+			}
+		}
+		if len(messages) < scanBatch {
+			return entries, nil
+		}
+		end = "(" + messages[len(messages)-1].ID
+	}
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-service/internal/tenant"
+)
+
+// tokenPrefix префикс выданных ключей, упрощает поиск утекших ключей
+const tokenPrefix = "gsk_"
+
+// ErrNotFound возвращается для неизвестного или отозванного ключа
+var ErrNotFound = errors.New("api key not found")
+
+// ErrInvalidToken возвращается для токена JWT, не прошедшего проверку
+var ErrInvalidToken = errors.New("invalid token")
+
+// bootstrapKeyID идентификатор начального ключа администратора
+const bootstrapKeyID = "admin"
+
+// Key ключ API. Сам ключ не хранится, только его SHA-256.
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Tenant    string     `json:"tenant,omitempty"` // Пустой — арендатор по умолчанию
+	Devices   []string   `json:"devices"`          // Точное имя устройства, префикс с * на конце или * для всех
+	Roles     []Role     `json:"roles"`            // viewer, ingest, operator, admin
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// UnmarshalJSON читает и ключи, выданные до появления ролей, с правами
+// read, write или admin в поле permissions
+func (k *Key) UnmarshalJSON(data []byte) error {
+	type plain Key
+	var v struct {
+		plain
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*k = Key(v.plain)
+	if len(k.Roles) == 0 {
+		for _, perm := range v.Permissions {
+			if role, err := ParseRole(perm); err == nil {
+				k.Roles = append(k.Roles, role)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate проверяет арендатора, области и роли ключа
+func (k *Key) Validate() error {
+	if k.Tenant != "" {
+		if err := tenant.Validate(k.Tenant); err != nil {
+			return err
+		}
+	}
+	if len(k.Devices) == 0 {
+		return errors.New("at least one device pattern is required")
+	}
+	for _, pattern := range k.Devices {
+		if pattern == "" || strings.Contains(strings.TrimSuffix(pattern, "*"), "*") {
+			return fmt.Errorf("invalid device pattern %q", pattern)
+		}
+	}
+	if len(k.Roles) == 0 {
+		return errors.New("at least one role is required")
+	}
+	for _, role := range k.Roles {
+		if _, ok := rolePermissions[role]; !ok {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+// TenantID возвращает арендатора ключа
+func (k *Key) TenantID() string {
+	if k.Tenant == "" {
+		return tenant.Default
+	}
+	return k.Tenant
+}
+
+// ManagesTenants проверяет, что ключ может выдавать и отзывать ключи
+// любых арендаторов. Это право есть только у начального ключа администратора.
+func (k *Key) ManagesTenants() bool {
+	return k.ID == bootstrapKeyID
+}
+
+// Expired проверяет срок действия ключа
+func (k *Key) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Can проверяет, что одна из ролей ключа дает право, без привязки к устройству
+func (k *Key) Can(perm Permission) bool {
+	for _, role := range k.Roles {
+		if role.Grants(perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows проверяет право на устройство
+func (k *Key) Allows(deviceID string, perm Permission) bool {
+	return k.Can(perm) && k.Covers(deviceID)
+}
+
+// Covers проверяет, что устройство входит в область ключа
+func (k *Key) Covers(deviceID string) bool {
+	for _, pattern := range k.Devices {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(deviceID, prefix) {
+				return true
+			}
+		} else if pattern == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// Delegates проверяет, что ключ other не шире ключа k: каждая роль other
+// дает только права, которые есть у k, и каждый шаблон устройств other
+// входит в область k. Иначе возвращает ошибку с первым лишним элементом.
+func (k *Key) Delegates(other *Key) error {
+	for _, role := range other.Roles {
+		for _, perm := range rolePermissions[role] {
+			if !k.Can(perm) {
+				return fmt.Errorf("role %q grants %s beyond the issuing key", role, perm)
+			}
+		}
+	}
+	for _, pattern := range other.Devices {
+		if !k.coversPattern(pattern) {
+			return fmt.Errorf("device pattern %q is outside the issuing key", pattern)
+		}
+	}
+	return nil
+}
+
+// coversPattern проверяет, что все устройства шаблона входят в область ключа
+func (k *Key) coversPattern(pattern string) bool {
+	prefix, wildcard := strings.CutSuffix(pattern, "*")
+	if !wildcard {
+		return k.Covers(pattern)
+	}
+	for _, own := range k.Devices {
+		if ownPrefix, ok := strings.CutSuffix(own, "*"); ok && strings.HasPrefix(prefix, ownPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewToken создает случайный ключ и его идентификатор
+func NewToken() (token, id string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(secret), hex.EncodeToString(idBytes), nil
+}
+
+// HashToken возвращает хеш ключа для хранения и поиска
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// contextKey ключ контекста для ключа API запроса
+type contextKey struct{}
+
+// WithKey сохраняет ключ запроса и его арендатора в контексте
+func WithKey(ctx context.Context, key *Key) context.Context {
+	ctx = tenant.WithTenant(ctx, key.TenantID())
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext возвращает ключ запроса
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
+
+// Allowed проверяет право ключа из контекста на устройство. Контекст без
+// ключа разрешает все: аутентификация выключена или источник доверенный,
+// например подписка MQTT.
+func Allowed(ctx context.Context, deviceID string, perm Permission) bool {
+	key, ok := FromContext(ctx)
+	return !ok || key.Allows(deviceID, perm)
+}
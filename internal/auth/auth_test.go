@@ -0,0 +1,31 @@
+package auth
+
+import "testing"
+
+func TestKeyDelegates(t *testing.T) {
+	caller := &Key{Devices: []string{"sensor-*", "gateway-1"}, Roles: []Role{RoleOperator}}
+
+	tests := []struct {
+		name    string
+		devices []string
+		roles   []Role
+		ok      bool
+	}{
+		{"same scope", []string{"sensor-*", "gateway-1"}, []Role{RoleOperator}, true},
+		{"narrower prefix", []string{"sensor-eu-*"}, []Role{RoleViewer}, true},
+		{"exact device under prefix", []string{"sensor-7"}, []Role{RoleIngest}, true},
+		{"all devices", []string{"*"}, []Role{RoleViewer}, false},
+		{"wider prefix", []string{"sens*"}, []Role{RoleViewer}, false},
+		{"prefix of exact device", []string{"gateway-*"}, []Role{RoleViewer}, false},
+		{"foreign device", []string{"gateway-2"}, []Role{RoleViewer}, false},
+		{"admin role", []string{"sensor-1"}, []Role{RoleAdmin}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := caller.Delegates(&Key{Devices: tt.devices, Roles: tt.roles})
+			if (err == nil) != tt.ok {
+				t.Errorf("Delegates(%v, %v) error = %v, want ok %v", tt.devices, tt.roles, err, tt.ok)
+			}
+		})
+	}
+}
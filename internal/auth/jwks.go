@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxJWKSSize предел размера набора ключей
+const maxJWKSSize = 1 << 20
+
+// jwksMinRefresh минимальный интервал между попытками загрузки набора по
+// неизвестному kid, удачными или нет, чтобы токены с выдуманным kid не
+// превращались в запросы к провайдеру
+const jwksMinRefresh = 30 * time.Second
+
+// jwk открытый ключ в формате RFC 7517
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS набор открытых ключей провайдера из URL или локального файла.
+// Набор по URL обновляется периодически и при встрече неизвестного kid.
+type JWKS struct {
+	url    string
+	file   string
+	client *http.Client
+	logger *zap.SugaredLogger
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	attemptedAt time.Time     // Начало последней загрузки, в том числе неудачной
+	reloading   chan struct{} // Закрывается по окончании загрузки по неизвестному kid
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewJWKS загружает набор ключей из url или file. Для url при refresh > 0
+// набор обновляется в фоне до Close.
+func NewJWKS(url, file string, refresh time.Duration, logger *zap.SugaredLogger) (*JWKS, error) {
+	if (url == "") == (file == "") {
+		return nil, errors.New("exactly one of JWKS URL and file is required")
+	}
+	j := &JWKS{url: url, file: file, client: &http.Client{Timeout: 10 * time.Second}, logger: logger}
+	if err := j.load(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if url != "" && refresh > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		j.cancel = cancel
+		j.wg.Add(1)
+		go func() {
+			defer j.wg.Done()
+			j.refreshLoop(ctx, refresh)
+		}()
+	}
+	return j, nil
+}
+
+// Close останавливает фоновое обновление
+func (j *JWKS) Close() {
+	if j.cancel == nil {
+		return
+	}
+	j.cancel()
+	j.wg.Wait()
+}
+
+// Key возвращает ключ по kid. Пустой kid допустим, если в наборе один ключ.
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+
+	// Провайдер мог сменить ключи: перечитываем набор
+	if err := j.reload(ctx); err != nil {
+		j.logger.Warnf("Failed to reload JWKS: %v", err)
+	} else if key, ok := j.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// reload перечитывает набор не чаще jwksMinRefresh. Одновременные вызовы
+// ждут одну загрузку, а не запускают свои.
+func (j *JWKS) reload(ctx context.Context) error {
+	j.mu.Lock()
+	if done := j.reloading; done != nil {
+		j.mu.Unlock()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if time.Since(j.attemptedAt) < jwksMinRefresh {
+		j.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	j.reloading = done
+	j.mu.Unlock()
+
+	// Загрузку ждут и другие запросы, поэтому отмена этого ее не прерывает
+	err := j.load(context.WithoutCancel(ctx))
+
+	j.mu.Lock()
+	j.reloading = nil
+	j.mu.Unlock()
+	close(done)
+	return err
+}
+
+func (j *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+func (j *JWKS) refreshLoop(ctx context.Context, refresh time.Duration) {
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.load(ctx); err != nil && ctx.Err() == nil {
+				j.logger.Warnf("Failed to refresh JWKS from %s: %v", j.url, err)
+			}
+		}
+	}
+}
+
+// load читает и разбирает набор. При ошибке остается прежний набор.
+func (j *JWKS) load(ctx context.Context) error {
+	j.mu.Lock()
+	j.attemptedAt = time.Now()
+	j.mu.Unlock()
+
+	data, err := j.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data, j.logger)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	return nil
+}
+
+func (j *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	if j.file != "" {
+		return os.ReadFile(j.file)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// errUnsupportedKey тип ключа или кривая, которые не поддерживаются
+var errUnsupportedKey = errors.New("unsupported key type")
+
+// parseJWKS разбирает ключи подписи RSA, EC и Ed25519. Ключи шифрования
+// пропускаются, ключи неподдерживаемых типов и кривых или с ошибкой
+// пропускаются с предупреждением, чтобы один такой ключ провайдера не
+// отключал вход по остальным.
+func parseJWKS(data []byte, logger *zap.SugaredLogger) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			logger.Warnf("Skipping JWKS key %q (kty %q, crv %q): %v", k.Kid, k.Kty, k.Crv, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey возвращает открытый ключ или errUnsupportedKey для
+// неподдерживаемого типа и кривой
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %q", errUnsupportedKey, k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w: curve %q", errUnsupportedKey, k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("%w %q", errUnsupportedKey, k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestParseJWKSSkipsUnsupportedKeys(t *testing.T) {
+	keys := newTestKeys(t)
+	data := keys.jwksJSON(t,
+		map[string]string{"kid": "ec-k1", "kty": "EC", "crv": "secp256k1", "x": "AQ", "y": "AQ"},
+		map[string]string{"kid": "okp-x", "kty": "OKP", "crv": "X25519", "x": "AQ"},
+		map[string]string{"kid": "oct", "kty": "oct"},
+		map[string]string{"kid": "broken", "kty": "RSA", "n": "!", "e": "AQAB"},
+		map[string]string{"kid": "enc", "kty": "RSA", "use": "enc", "n": "AQ", "e": "AQAB"},
+	)
+
+	parsed, err := parseJWKS(data, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("parseJWKS: %v", err)
+	}
+	if len(parsed) != 2 || parsed["rsa-1"] == nil || parsed["ec-1"] == nil {
+		t.Errorf("parsed keys %v, want rsa-1 and ec-1", parsed)
+	}
+
+	if _, err := parseJWKS([]byte(`{"keys":[{"kid":"oct","kty":"oct"}]}`), zap.NewNop().Sugar()); err == nil {
+		t.Error("set without supported keys accepted")
+	}
+}
+
+func TestJWKSFromFile(t *testing.T) {
+	keys := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, keys.jwksJSON(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := NewJWKS("", path, 0, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("NewJWKS: %v", err)
+	}
+	if _, err := jwks.Key(context.Background(), "ec-1"); err != nil {
+		t.Errorf("Key(ec-1): %v", err)
+	}
+}
+
+func TestJWKSReloadIsSingleFlight(t *testing.T) {
+	keys := newTestKeys(t)
+	server, requests := serveJWKS(t, keys.jwksJSON(t))
+	jwks, err := NewJWKS(server.URL, "", 0, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Первая загрузка была давно: неизвестный kid вызывает одну перезагрузку
+	jwks.mu.Lock()
+	jwks.attemptedAt = time.Now().Add(-time.Hour)
+	jwks.mu.Unlock()
+	requests.Store(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jwks.Key(context.Background(), "rotated")
+		}()
+	}
+	wg.Wait()
+	if n := requests.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+
+	// Повтор в пределах jwksMinRefresh не обращается к провайдеру
+	jwks.Key(context.Background(), "rotated")
+	if n := requests.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times after retry, want 1", n)
+	}
+}
+
+func TestJWKSFailedReloadIsThrottled(t *testing.T) {
+	keys := newTestKeys(t)
+	server, _ := serveJWKS(t, keys.jwksJSON(t))
+	jwks, err := NewJWKS(server.URL, "", 0, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Провайдер недоступен: неудачная попытка тоже откладывает следующую
+	server.Close()
+	jwks.mu.Lock()
+	jwks.attemptedAt = time.Now().Add(-time.Hour)
+	jwks.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		if _, err := jwks.Key(context.Background(), "rotated"); err == nil {
+			t.Fatal("unknown kid accepted")
+		}
+	}
+	jwks.mu.RLock()
+	attempted := jwks.attemptedAt
+	jwks.mu.RUnlock()
+	if time.Since(attempted) > time.Minute {
+		t.Error("failed reload did not record the attempt")
+	}
+	if _, err := jwks.Key(context.Background(), "rsa-1"); err != nil {
+		t.Errorf("previous set lost after failed reload: %v", err)
+	}
+}
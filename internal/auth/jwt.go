@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-service/internal/tenant"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtMethods допустимые алгоритмы подписи: только асимметричные, чтобы
+// открытый ключ из JWKS нельзя было использовать как секрет HMAC
+var jwtMethods = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// JWTConfig настройки проверки токенов провайдера удостоверений
+type JWTConfig struct {
+	Issuer    string        // Ожидаемый iss, пустой не проверяется
+	Audience  string        // Ожидаемое значение aud, пустое не проверяется
+	ClockSkew time.Duration // Допуск расхождения часов для exp, nbf и iat
+	// Claim с ролями пользователя: строка или массив строк. Путь через точку
+	// (realm_access.roles) ищет вложенный claim, если нет claim с таким именем.
+	RoleClaim string
+	// Роль из claim -> роль сервиса. Роли без сопоставления не дают прав.
+	Roles map[string]Role
+	// Claim с арендатором пользователя
+	TenantClaim string
+	// Арендатор токенов без claim арендатора. Пустой: такие токены
+	// отклоняются, чтобы пользователь не попал в чужого арендатора.
+	DefaultTenant string
+}
+
+// Validate проверяет сопоставление ролей
+func (c JWTConfig) Validate() error {
+	if c.RoleClaim == "" {
+		return errors.New("role claim is required")
+	}
+	if c.ClockSkew < 0 {
+		return errors.New("clock skew must not be negative")
+	}
+	if c.TenantClaim == "" {
+		return errors.New("tenant claim is required")
+	}
+	if c.DefaultTenant != "" {
+		if err := tenant.Validate(c.DefaultTenant); err != nil {
+			return fmt.Errorf("default tenant: %w", err)
+		}
+	}
+	for claimRole, role := range c.Roles {
+		if _, ok := rolePermissions[role]; !ok {
+			return fmt.Errorf("role %s mapped to unknown role %q", claimRole, role)
+		}
+	}
+	return nil
+}
+
+// JWTVerifier проверяет токены JWT пользователей и сопоставляет их роли
+// ролям сервиса. Пользователь получает доступ ко всем устройствам своего
+// арендатора в пределах прав.
+type JWTVerifier struct {
+	cfg    JWTConfig
+	jwks   *JWKS
+	parser *jwt.Parser
+}
+
+// NewJWTVerifier создает проверку токенов с ключами из jwks
+func NewJWTVerifier(cfg JWTConfig, jwks *JWKS) (*JWTVerifier, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(jwtMethods),
+		jwt.WithLeeway(cfg.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	return &JWTVerifier{cfg: cfg, jwks: jwks, parser: jwt.NewParser(opts...)}, nil
+}
+
+// Verify проверяет подпись и claims токена и возвращает ключ пользователя
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Key, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.jwks.Key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	tenantID, _ := claims[v.cfg.TenantClaim].(string)
+	if tenantID == "" {
+		tenantID = v.cfg.DefaultTenant
+	}
+	if tenantID == "" {
+		return nil, fmt.Errorf("token has no %s claim", v.cfg.TenantClaim)
+	}
+	if err := tenant.Validate(tenantID); err != nil {
+		return nil, err
+	}
+	key := &Key{
+		ID:      "jwt:" + subject,
+		Name:    userName(claims, subject),
+		Tenant:  tenantID,
+		Devices: []string{"*"},
+		Roles:   v.roles(claims),
+	}
+	if exp, _ := claims.GetExpirationTime(); exp != nil {
+		expiresAt := exp.Time
+		key.ExpiresAt = &expiresAt
+	}
+	return key, nil
+}
+
+// roles сопоставляет роли из claim ролям сервиса
+func (v *JWTVerifier) roles(claims jwt.MapClaims) []Role {
+	var roles []Role
+	seen := make(map[Role]bool)
+	for _, claimRole := range roleValues(claims, v.cfg.RoleClaim) {
+		if role, ok := v.cfg.Roles[claimRole]; ok && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// roleValues возвращает роли из claim name. Поддерживается строка,
+// массив строк и строка ролей через пробел, как в scope.
+func roleValues(claims jwt.MapClaims, name string) []string {
+	value, ok := claims[name]
+	if !ok {
+		var current interface{} = map[string]interface{}(claims)
+		for _, part := range strings.Split(name, ".") {
+			obj, isObj := current.(map[string]interface{})
+			if !isObj {
+				return nil
+			}
+			current = obj[part]
+		}
+		value = current
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		roles := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+		return roles
+	default:
+		return nil
+	}
+}
+
+// userName имя пользователя для журналов
+func userName(claims jwt.MapClaims, subject string) string {
+	for _, claim := range []string{"preferred_username", "email", "name"} {
+		if s, ok := claims[claim].(string); ok && s != "" {
+			return s
+		}
+	}
+	return subject
+}
+
+// looksLikeJWT отличает JWT (три части через точку) от ключа API
+func looksLikeJWT(token string) bool {
+	return !strings.HasPrefix(token, tokenPrefix) && strings.Count(token, ".") == 2
+}
@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-service/internal/tenant"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "go-service"
+)
+
+// testKeys ключи подписи провайдера для тестов
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// jwksJSON набор открытых ключей rsa-1 и ec-1 и дополнительные ключи extra
+func (k testKeys) jwksJSON(t *testing.T, extra ...map[string]string) []byte {
+	t.Helper()
+	keys := []map[string]string{
+		{
+			"kid": "rsa-1", "kty": "RSA", "use": "sig", "alg": "RS256",
+			"n": b64(k.rsa.N.Bytes()),
+			"e": b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		},
+		{
+			"kid": "ec-1", "kty": "EC", "use": "sig", "alg": "ES256", "crv": "P-256",
+			"x": b64(k.ec.X.FillBytes(make([]byte, 32))),
+			"y": b64(k.ec.Y.FillBytes(make([]byte, 32))),
+		},
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": append(keys, extra...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// serveJWKS отдает набор ключей и считает запросы к нему
+func serveJWKS(t *testing.T, body []byte) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func newTestVerifier(t *testing.T, keys testKeys, cfg JWTConfig) *JWTVerifier {
+	t.Helper()
+	server, _ := serveJWKS(t, keys.jwksJSON(t))
+	jwks, err := NewJWKS(server.URL, "", 0, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "roles"
+	}
+	if cfg.TenantClaim == "" {
+		cfg.TenantClaim = "tenant"
+	}
+	if cfg.Roles == nil {
+		cfg.Roles = map[string]Role{"dashboards": RoleViewer, "platform-admins": RoleAdmin}
+	}
+	verifier, err := NewJWTVerifier(cfg, jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return verifier
+}
+
+// validClaims claims, которые проходят проверку с testIssuer и testAudience
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":    testIssuer,
+		"aud":    testAudience,
+		"sub":    "user-1",
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+		"roles":  []string{"dashboards"},
+		"tenant": "acme",
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestJWTVerifySignatures(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(t, keys, JWTConfig{Issuer: testIssuer, Audience: testAudience})
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"RS256", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, validClaims())},
+		{"ES256", sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, validClaims())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := verifier.Verify(context.Background(), tt.token)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if key.ID != "jwt:user-1" || !key.Can(PermissionRead) || key.Can(PermissionWrite) {
+				t.Errorf("key = %+v, want viewer jwt:user-1", key)
+			}
+			if key.ExpiresAt == nil {
+				t.Error("key has no expiry")
+			}
+		})
+	}
+}
+
+func TestJWTVerifyRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(t, keys, JWTConfig{Issuer: testIssuer, Audience: testAudience, ClockSkew: time.Minute})
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	with := func(mutate func(jwt.MapClaims)) jwt.MapClaims {
+		claims := validClaims()
+		mutate(claims)
+		return claims
+	}
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HS256 с открытым ключом как секретом: классическая подмена алгоритма
+	hmacSecret := keys.jwksJSON(t)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"alg none", none},
+		{"HS256", sign(t, jwt.SigningMethodHS256, "rsa-1", hmacSecret, validClaims())},
+		{"wrong signature", sign(t, jwt.SigningMethodRS256, "rsa-1", otherKey, validClaims())},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, "rsa-2", otherKey, validClaims())},
+		{"wrong issuer", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }))},
+		{"wrong audience", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { c["aud"] = "other-service" }))},
+		{"no exp", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { delete(c, "exp") }))},
+		{"expired beyond skew", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }))},
+		{"issued in the future", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { c["iat"] = time.Now().Add(5 * time.Minute).Unix() }))},
+		{"no subject", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { delete(c, "sub") }))},
+		{"invalid tenant", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { c["tenant"] = "bad tenant!" }))},
+		{"no tenant", sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, with(func(c jwt.MapClaims) { delete(c, "tenant") }))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key, err := verifier.Verify(context.Background(), tt.token); err == nil {
+				t.Errorf("Verify accepted token, key %+v", key)
+			}
+		})
+	}
+}
+
+func TestJWTVerifyClockSkew(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(t, keys, JWTConfig{ClockSkew: time.Minute})
+
+	claims := validClaims()
+	claims["exp"] = time.Now().Add(-30 * time.Second).Unix()
+	if _, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, claims)); err != nil {
+		t.Errorf("token expired within skew rejected: %v", err)
+	}
+}
+
+func TestJWTVerifyClaims(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(t, keys, JWTConfig{
+		RoleClaim:   "realm_access.roles",
+		TenantClaim: "org",
+		Roles:       map[string]Role{"dashboards": RoleViewer, "platform-admins": RoleAdmin},
+	})
+
+	claims := validClaims()
+	delete(claims, "roles")
+	claims["realm_access"] = map[string]interface{}{"roles": []string{"platform-admins", "unmapped"}}
+	claims["org"] = "acme"
+	claims["preferred_username"] = "alice"
+
+	key, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, claims))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(key.Roles) != 1 || key.Roles[0] != RoleAdmin {
+		t.Errorf("roles = %v, want [admin]", key.Roles)
+	}
+	if key.TenantID() != "acme" || key.Name != "alice" {
+		t.Errorf("tenant %q name %q, want acme and alice", key.TenantID(), key.Name)
+	}
+
+	// Без claim арендатора токен отклоняется
+	delete(claims, "org")
+	if key, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodES256, "ec-1", keys.ec, claims)); err == nil {
+		t.Errorf("Verify accepted token without tenant, key %+v", key)
+	}
+}
+
+func TestJWTDefaultTenant(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(t, keys, JWTConfig{DefaultTenant: tenant.Default})
+
+	claims := validClaims()
+	delete(claims, "tenant")
+	key, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, claims))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if key.Tenant != tenant.Default {
+		t.Errorf("tenant = %q, want %s", key.Tenant, tenant.Default)
+	}
+
+	// Claim арендатора важнее арендатора по умолчанию
+	claims["tenant"] = "acme"
+	key, err = verifier.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa-1", keys.rsa, claims))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if key.Tenant != "acme" {
+		t.Errorf("tenant = %q, want acme", key.Tenant)
+	}
+
+	cfg := JWTConfig{RoleClaim: "roles", TenantClaim: "tenant", DefaultTenant: "bad tenant!"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted invalid default tenant")
+	}
+}
+
+func TestRoleValues(t *testing.T) {
+	claims := jwt.MapClaims{
+		"scope":        "read write",
+		"groups":       []interface{}{"a", 1, "b"},
+		"realm_access": map[string]interface{}{"roles": []interface{}{"admin"}},
+		"a.b":          "flat",
+	}
+	tests := []struct {
+		claim string
+		want  []string
+	}{
+		{"scope", []string{"read", "write"}},
+		{"groups", []string{"a", "b"}},
+		{"realm_access.roles", []string{"admin"}},
+		{"a.b", []string{"flat"}},
+		{"realm_access.missing", nil},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		got := roleValues(claims, tt.claim)
+		if len(got) != len(tt.want) {
+			t.Errorf("roleValues(%q) = %v, want %v", tt.claim, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("roleValues(%q) = %v, want %v", tt.claim, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// lookupCacheTTL время жизни проверенного ключа в кэше узла. Отзыв на
+// других узлах вступает в силу не позже чем через этот срок.
+const lookupCacheTTL = 10 * time.Second
+
+// cachedKey результат проверки ключа
+type cachedKey struct {
+	key     *Key
+	expires time.Time
+}
+
+// Authenticator проверяет ключи API и выдает новые
+type Authenticator struct {
+	store     Store
+	routes    *Routes
+	adminHash string // Хеш начального ключа администратора, пустой отключает
+	jwt       *JWTVerifier
+	logger    *zap.SugaredLogger
+
+	mu    sync.Mutex
+	cache map[string]cachedKey
+}
+
+// NewAuthenticator создает проверку ключей с правами маршрутов routes.
+// adminToken задает начальный ключ администратора для выдачи остальных ключей.
+func NewAuthenticator(store Store, routes *Routes, adminToken string, logger *zap.SugaredLogger) *Authenticator {
+	a := &Authenticator{store: store, routes: routes, logger: logger, cache: make(map[string]cachedKey)}
+	if adminToken != "" {
+		a.adminHash = HashToken(adminToken)
+	}
+	return a
+}
+
+// SetJWT включает вход пользователей по токенам JWT провайдера удостоверений.
+// Токен вида header.payload.signature проверяется им, а не хранилищем ключей.
+func (a *Authenticator) SetJWT(v *JWTVerifier) {
+	a.jwt = v
+}
+
+// Middleware пропускает запрос с действующим ключом или токеном, роли
+// которого дают право, объявленное для маршрута в Routes. Маршрут без
+// объявленного права закрыт для всех. Если маршрут содержит deviceID, ключ
+// должен покрывать это устройство. Устройства из тела запроса проверяют
+// обработчики через Allowed.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perm, declared := a.routes.Lookup(r)
+		if !declared {
+			a.logger.Errorf("Route %s %s has no declared permission", r.Method, r.URL.Path)
+			metrics.RecordAuthFailure("forbidden")
+			http.Error(w, "Route has no declared permission", http.StatusForbidden)
+			return
+		}
+		if perm == PermissionPublic {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := tokenFromRequest(r)
+		if token == "" {
+			metrics.RecordAuthFailure("missing")
+			writeUnauthorized(w, "Missing API key")
+			return
+		}
+		key, err := a.Identify(r.Context(), token)
+		if errors.Is(err, ErrInvalidToken) {
+			writeUnauthorized(w, "Invalid token")
+			return
+		}
+		if errors.Is(err, ErrNotFound) {
+			writeUnauthorized(w, "Invalid API key")
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		a.authorize(w, r, next, key, perm)
+	})
+}
+
+// Identify находит ключ API или проверяет токен JWT. Для неизвестного
+// ключа возвращает ErrNotFound, для отклоненного токена — ErrInvalidToken.
+// Отказы учитываются в метриках.
+func (a *Authenticator) Identify(ctx context.Context, token string) (*Key, error) {
+	if a.jwt != nil && looksLikeJWT(token) {
+		key, err := a.jwt.Verify(ctx, token)
+		if err != nil {
+			metrics.RecordAuthFailure("invalid")
+			a.logger.Debugf("Rejected JWT: %v", err)
+			return nil, ErrInvalidToken
+		}
+		return key, nil
+	}
+
+	key, err := a.authenticate(ctx, token)
+	if errors.Is(err, ErrNotFound) {
+		metrics.RecordAuthFailure("invalid")
+		return nil, err
+	}
+	if err != nil {
+		a.logger.Errorf("Failed to look up api key: %v", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+// authorize проверяет право ключа на маршрут и передает запрос дальше
+func (a *Authenticator) authorize(w http.ResponseWriter, r *http.Request, next http.Handler, key *Key, perm Permission) {
+	if !key.Can(perm) {
+		metrics.RecordAuthFailure("forbidden")
+		WriteForbidden(w, perm, "")
+		return
+	}
+	if deviceID, scoped := mux.Vars(r)["deviceID"]; scoped && !key.Covers(deviceID) {
+		metrics.RecordAuthFailure("forbidden")
+		WriteForbidden(w, perm, deviceID)
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(WithKey(r.Context(), key)))
+}
+
+// authenticate находит ключ по значению
+func (a *Authenticator) authenticate(ctx context.Context, token string) (*Key, error) {
+	hash := HashToken(token)
+	if a.adminHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(a.adminHash)) == 1 {
+		return &Key{ID: bootstrapKeyID, Name: "bootstrap admin", Devices: []string{"*"}, Roles: []Role{RoleAdmin}}, nil
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.cache[hash]
+	a.mu.Unlock()
+	if ok && now.Before(cached.expires) && !cached.key.Expired(now) {
+		return cached.key, nil
+	}
+
+	key, err := a.store.Lookup(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if key.Expired(now) {
+		return nil, ErrNotFound
+	}
+
+	a.mu.Lock()
+	if len(a.cache) > 10000 {
+		// Кэш только ускоряет проверку, при переполнении проще сбросить его
+		a.cache = make(map[string]cachedKey)
+	}
+	a.cache[hash] = cachedKey{key: key, expires: now.Add(lookupCacheTTL)}
+	a.mu.Unlock()
+	return key, nil
+}
+
+// Issue выдает новый ключ. Значение ключа возвращается только здесь.
+func (a *Authenticator) Issue(ctx context.Context, key Key) (string, *Key, error) {
+	if err := key.Validate(); err != nil {
+		return "", nil, err
+	}
+	token, id, err := NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+	key.ID = id
+	key.CreatedAt = time.Now().UTC()
+	if err := a.store.Create(ctx, HashToken(token), key); err != nil {
+		return "", nil, err
+	}
+	return token, &key, nil
+}
+
+// Revoke отзывает ключ. На этом узле отзыв действует сразу.
+func (a *Authenticator) Revoke(ctx context.Context, id string) error {
+	if err := a.store.Revoke(ctx, id); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	for hash, cached := range a.cache {
+		if cached.key.ID == id {
+			delete(a.cache, hash)
+		}
+	}
+	a.mu.Unlock()
+	return nil
+}
+
+// List возвращает действующие ключи
+func (a *Authenticator) List(ctx context.Context) ([]Key, error) {
+	return a.store.List(ctx)
+}
+
+// tokenFromRequest извлекает ключ из Authorization (Bearer или Token,
+// как у клиентов InfluxDB) или X-API-Key
+func tokenFromRequest(r *http.Request) string {
+	return TokenFromHeaders(r.Header.Get("Authorization"), r.Header.Get("X-API-Key"))
+}
+
+// TokenFromHeaders выбирает ключ из значения Authorization со схемой
+// Bearer или Token, иначе из X-API-Key. Используется и для метаданных gRPC.
+func TokenFromHeaders(authorization, apiKey string) string {
+	if authorization != "" {
+		scheme, token, ok := strings.Cut(authorization, " ")
+		if ok && (strings.EqualFold(scheme, "Bearer") || strings.EqualFold(scheme, "Token")) {
+			return strings.TrimSpace(token)
+		}
+	}
+	return apiKey
+}
+
+// writeUnauthorized отвечает 401 с указанием схемы
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="go-service"`)
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// ForbiddenMessage описывает недостающее право, а для устройства вне
+// области ключа — и само устройство
+func ForbiddenMessage(perm Permission, deviceID string) string {
+	if deviceID != "" {
+		return fmt.Sprintf("Missing permission %s for device %s", perm, deviceID)
+	}
+	return fmt.Sprintf("Missing permission %s", perm)
+}
+
+// WriteForbidden отвечает 403 с недостающим правом
+func WriteForbidden(w http.ResponseWriter, perm Permission, deviceID string) {
+	http.Error(w, ForbiddenMessage(perm, deviceID), http.StatusForbidden)
+}
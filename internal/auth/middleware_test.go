@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-service/internal/concurrency"
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func TestMiddleware(t *testing.T) {
+	metrics.InitMetrics()
+	store := NewMemory()
+	ctx := context.Background()
+	for token, key := range map[string]Key{
+		"viewer-token":  {ID: "viewer", Devices: []string{"sensor-*"}, Roles: []Role{RoleViewer}},
+		"ingest-token":  {ID: "ingest", Devices: []string{"*"}, Roles: []Role{RoleIngest}},
+		"manager-token": {ID: "manager", Devices: []string{"*"}, Roles: []Role{RoleAdmin}},
+	} {
+		if err := store.Create(ctx, HashToken(token), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := mux.NewRouter()
+	routes := NewRoutes()
+	authenticator := NewAuthenticator(store, routes, "bootstrap-token", zap.NewNop().Sugar())
+	r.Use(authenticator.Middleware)
+
+	// Обработчик возвращает идентификатор ключа из контекста
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := FromContext(r.Context()); ok {
+			w.Write([]byte(key.ID))
+		}
+	}
+	routes.HandleFunc(r, "/health", PermissionPublic, concurrency.PriorityCritical, echo).Methods("GET")
+	routes.HandleFunc(r, "/metric", PermissionWrite, concurrency.PriorityIngest, echo).Methods("POST")
+	routes.HandleFunc(r, "/devices/{deviceID}/analytics", PermissionRead, concurrency.PriorityNormal, echo).Methods("GET")
+	routes.HandleFunc(r, "/admin/keys", PermissionManageKeys, concurrency.PriorityNormal, echo).Methods("GET")
+	r.HandleFunc("/undeclared", echo).Methods("GET")
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "public route without key", method: "GET", path: "/health", wantStatus: http.StatusOK},
+		{name: "missing key", method: "POST", path: "/metric", wantStatus: http.StatusUnauthorized, wantBody: "Missing API key"},
+		{name: "unknown key", method: "POST", path: "/metric", token: "wrong-token", wantStatus: http.StatusUnauthorized, wantBody: "Invalid API key"},
+		{name: "allowed", method: "POST", path: "/metric", token: "ingest-token", wantStatus: http.StatusOK, wantBody: "ingest"},
+		{name: "missing permission", method: "POST", path: "/metric", token: "viewer-token", wantStatus: http.StatusForbidden, wantBody: "Missing permission metrics:write"},
+		{name: "missing role permission", method: "GET", path: "/admin/keys", token: "ingest-token", wantStatus: http.StatusForbidden, wantBody: "Missing permission keys:manage"},
+		{name: "device in scope", method: "GET", path: "/devices/sensor-1/analytics", token: "viewer-token", wantStatus: http.StatusOK, wantBody: "viewer"},
+		{name: "device out of scope", method: "GET", path: "/devices/pump-1/analytics", token: "viewer-token", wantStatus: http.StatusForbidden, wantBody: "Missing permission analytics:read for device pump-1"},
+		{name: "bootstrap admin", method: "GET", path: "/admin/keys", token: "bootstrap-token", wantStatus: http.StatusOK, wantBody: bootstrapKeyID},
+		{name: "undeclared route", method: "GET", path: "/undeclared", token: "manager-token", wantStatus: http.StatusForbidden, wantBody: "Route has no declared permission"},
+		{name: "undeclared route without key", method: "GET", path: "/undeclared", wantStatus: http.StatusForbidden, wantBody: "Route has no declared permission"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
+
+func TestTokenFromHeaders(t *testing.T) {
+	tests := []struct {
+		authorization, apiKey, want string
+	}{
+		{"Bearer abc", "", "abc"},
+		{"token abc", "", "abc"},
+		{"Basic dXNlcjpwYXNz", "xyz", "xyz"},
+		{"", "xyz", "xyz"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		if got := TokenFromHeaders(tt.authorization, tt.apiKey); got != tt.want {
+			t.Errorf("TokenFromHeaders(%q, %q) = %q, want %q", tt.authorization, tt.apiKey, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go-service/internal/concurrency"
+
+	"github.com/gorilla/mux"
+)
+
+// Permission право на группу маршрутов
+type Permission string
+
+const (
+	// PermissionPublic маршрут доступен без ключа
+	PermissionPublic Permission = "public"
+	// PermissionRead чтение аналитики, аномалий и истории устройств
+	PermissionRead Permission = "analytics:read"
+	// PermissionWrite отправка метрик
+	PermissionWrite Permission = "metrics:write"
+	// PermissionConfigure изменение окон устройств
+	PermissionConfigure Permission = "devices:configure"
+	// PermissionBackfill импорт исторических метрик
+	PermissionBackfill Permission = "metrics:backfill"
+	// PermissionManageKeys выдача, просмотр и отзыв ключей API
+	PermissionManageKeys Permission = "keys:manage"
+	// PermissionAudit чтение и выгрузка журнала административных действий
+	PermissionAudit Permission = "audit:read"
+)
+
+// Role роль ключа или пользователя, набор прав
+type Role string
+
+const (
+	// RoleViewer просмотр аналитики: дашборды и отчеты
+	RoleViewer Role = "viewer"
+	// RoleIngest отправка метрик: устройства и агенты сбора
+	RoleIngest Role = "ingest"
+	// RoleOperator просмотр, отправка метрик, настройка устройств и импорт
+	RoleOperator Role = "operator"
+	// RoleAdmin все права, включая управление ключами и журнал действий
+	RoleAdmin Role = "admin"
+)
+
+// rolePermissions права ролей. Новое право нужно добавить админу и,
+// при необходимости, другим ролям.
+var rolePermissions = map[Role][]Permission{
+	RoleViewer:   {PermissionRead},
+	RoleIngest:   {PermissionWrite},
+	RoleOperator: {PermissionRead, PermissionWrite, PermissionConfigure, PermissionBackfill},
+	RoleAdmin:    {PermissionRead, PermissionWrite, PermissionConfigure, PermissionBackfill, PermissionManageKeys, PermissionAudit},
+}
+
+// legacyRoles роли для прав ключей, выданных до появления ролей
+var legacyRoles = map[string]Role{
+	"read":  RoleViewer,
+	"write": RoleIngest,
+}
+
+// ParseRole разбирает роль. Прежние права read и write принимаются как
+// viewer и ingest.
+func ParseRole(value string) (Role, error) {
+	if role, ok := legacyRoles[value]; ok {
+		return role, nil
+	}
+	if _, ok := rolePermissions[Role(value)]; !ok {
+		return "", fmt.Errorf("unknown role %q", value)
+	}
+	return Role(value), nil
+}
+
+// Grants проверяет, что роль дает право
+func (r Role) Grants(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes права и приоритеты маршрутов. Право и приоритет при перегрузке
+// объявляются при регистрации маршрута, Middleware отклоняет запросы к
+// маршрутам без объявленного права.
+type Routes struct {
+	mu    sync.RWMutex
+	specs map[*mux.Route]routeSpec
+}
+
+// routeSpec объявленные при регистрации право и приоритет маршрута
+type routeSpec struct {
+	perm     Permission
+	priority concurrency.Priority
+}
+
+// NewRoutes создает пустую таблицу прав маршрутов
+func NewRoutes() *Routes {
+	return &Routes{specs: make(map[*mux.Route]routeSpec)}
+}
+
+// HandleFunc регистрирует обработчик пути с необходимым правом и приоритетом
+func (rt *Routes) HandleFunc(r *mux.Router, path string, perm Permission, priority concurrency.Priority, f http.HandlerFunc) *mux.Route {
+	return rt.Handle(r, path, perm, priority, f)
+}
+
+// Handle регистрирует обработчик пути с необходимым правом и приоритетом
+func (rt *Routes) Handle(r *mux.Router, path string, perm Permission, priority concurrency.Priority, h http.Handler) *mux.Route {
+	route := r.Handle(path, h)
+	rt.mu.Lock()
+	rt.specs[route] = routeSpec{perm: perm, priority: priority}
+	rt.mu.Unlock()
+	return route
+}
+
+// Lookup возвращает право маршрута, с которым сопоставлен запрос
+func (rt *Routes) Lookup(r *http.Request) (Permission, bool) {
+	spec, ok := rt.lookup(r)
+	return spec.perm, ok
+}
+
+// Priority возвращает приоритет маршрута, с которым сопоставлен запрос.
+// Запрос без маршрута получает concurrency.PriorityNormal.
+func (rt *Routes) Priority(r *http.Request) concurrency.Priority {
+	if spec, ok := rt.lookup(r); ok {
+		return spec.priority
+	}
+	return concurrency.PriorityNormal
+}
+
+func (rt *Routes) lookup(r *http.Request) (routeSpec, bool) {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return routeSpec{}, false
+	}
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	spec, ok := rt.specs[route]
+	return spec, ok
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-service/internal/concurrency"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRoutesPriority(t *testing.T) {
+	r := mux.NewRouter()
+	routes := NewRoutes()
+
+	var got concurrency.Priority
+	var perm Permission
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			got = routes.Priority(req)
+			perm, _ = routes.Lookup(req)
+			next.ServeHTTP(w, req)
+		})
+	})
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	routes.HandleFunc(r, "/metric", PermissionWrite, concurrency.PriorityIngest, ok).Methods("POST")
+	routes.HandleFunc(r, "/devices/{deviceID}/series", PermissionRead, concurrency.PriorityLow, ok).Methods("GET")
+	r.HandleFunc("/undeclared", ok)
+
+	tests := []struct {
+		method, path string
+		priority     concurrency.Priority
+		perm         Permission
+	}{
+		{"POST", "/metric", concurrency.PriorityIngest, PermissionWrite},
+		{"GET", "/devices/sensor-1/series", concurrency.PriorityLow, PermissionRead},
+		{"GET", "/undeclared", concurrency.PriorityNormal, ""},
+	}
+	for _, tt := range tests {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+		if got != tt.priority || perm != tt.perm {
+			t.Errorf("%s %s: priority %v, permission %q, want %v and %q", tt.method, tt.path, got, perm, tt.priority, tt.perm)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-service/internal/cache"
+)
+
+// Store хранилище ключей API по хешу
+type Store interface {
+	// Create сохраняет ключ с хешем hash
+	Create(ctx context.Context, hash string, key Key) error
+	// Lookup возвращает ключ по хешу или ErrNotFound
+	Lookup(ctx context.Context, hash string) (*Key, error)
+	// Revoke удаляет ключ по идентификатору или возвращает ErrNotFound
+	Revoke(ctx context.Context, id string) error
+	// List возвращает действующие ключи
+	List(ctx context.Context) ([]Key, error)
+}
+
+// Префиксы ключей Redis: запись ключа по хешу и хеш по идентификатору
+const (
+	keyPrefix   = "apikey:"
+	indexPrefix = "apikey:id:"
+)
+
+// Redis хранилище ключей в Redis, общее для всех узлов сервиса
+type Redis struct {
+	client *cache.RedisClient
+}
+
+// NewRedis создает хранилище ключей в Redis
+func NewRedis(client *cache.RedisClient) *Redis {
+	return &Redis{client: client}
+}
+
+// Create сохраняет ключ. Ключ со сроком действия истекает в Redis сам.
+func (r *Redis) Create(ctx context.Context, hash string, key Key) error {
+	var ttl time.Duration
+	if key.ExpiresAt != nil {
+		ttl = time.Until(*key.ExpiresAt)
+		if ttl <= 0 {
+			return errors.New("api key is already expired")
+		}
+	}
+	if err := r.client.Set(ctx, keyPrefix+hash, key, ttl); err != nil {
+		return err
+	}
+	return r.client.SetRaw(ctx, map[string][]byte{indexPrefix + key.ID: []byte(hash)}, ttl)
+}
+
+// Lookup возвращает ключ по хешу
+func (r *Redis) Lookup(ctx context.Context, hash string) (*Key, error) {
+	var key Key
+	err := r.client.Get(ctx, keyPrefix+hash, &key)
+	if errors.Is(err, cache.ErrNil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Revoke удаляет ключ и его индекс
+func (r *Redis) Revoke(ctx context.Context, id string) error {
+	hash, err := r.client.GetRaw(ctx, indexPrefix+id)
+	if errors.Is(err, cache.ErrNil) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return r.client.Delete(ctx, keyPrefix+string(hash), indexPrefix+id)
+}
+
+// List возвращает ключи по индексу идентификаторов
+func (r *Redis) List(ctx context.Context) ([]Key, error) {
+	ids, err := r.client.ScanKeys(ctx, indexPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(ids))
+	for _, id := range ids {
+		hash, err := r.client.GetRaw(ctx, id)
+		if errors.Is(err, cache.ErrNil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		key, err := r.Lookup(ctx, string(hash))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	sortKeys(keys)
+	return keys, nil
+}
+
+// Memory хранилище ключей в памяти процесса для одиночного узла.
+// Ключи теряются при перезапуске.
+type Memory struct {
+	mu     sync.RWMutex
+	byHash map[string]Key
+	hashes map[string]string // Идентификатор -> хеш
+}
+
+// NewMemory создает пустое хранилище ключей
+func NewMemory() *Memory {
+	return &Memory{byHash: make(map[string]Key), hashes: make(map[string]string)}
+}
+
+// Create сохраняет ключ
+func (m *Memory) Create(ctx context.Context, hash string, key Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.byHash[hash] = key
+	m.hashes[key.ID] = hash
+	return nil
+}
+
+// Lookup возвращает ключ по хешу
+func (m *Memory) Lookup(ctx context.Context, hash string) (*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, ok := m.byHash[hash]
+	if !ok || key.Expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return &key, nil
+}
+
+// Revoke удаляет ключ
+func (m *Memory) Revoke(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash, ok := m.hashes[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.byHash, hash)
+	delete(m.hashes, id)
+	return nil
+}
+
+// List возвращает действующие ключи
+func (m *Memory) List(ctx context.Context) ([]Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	keys := make([]Key, 0, len(m.byHash))
+	for _, key := range m.byHash {
+		if !key.Expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sortKeys(keys)
+	return keys, nil
+}
+
+// sortKeys упорядочивает ключи по времени создания
+func sortKeys(keys []Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return strings.Compare(keys[i].ID, keys[j].ID) < 0
+	})
+}
@@ -0,0 +1,309 @@
+package backfill
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go-service/internal/auth"
+	"go-service/internal/models"
+	"go-service/pkg/metrics"
+)
+
+// Format формат файла с историческими метриками
+type Format string
+
+const (
+	// FormatCSV таблица с заголовком device_id,timestamp,cpu,memory,rps,network
+	// и необязательным message_id. Числовые значения остальных столбцов с
+	// допустимыми именами становятся дополнительными полями, нечисловые
+	// пропускаются.
+	FormatCSV Format = "csv"
+	// FormatNDJSON по одной метрике в JSON на строку
+	FormatNDJSON Format = "ndjson"
+)
+
+// DetectFormat определяет формат по явному значению, имени файла или Content-Type
+func DetectFormat(explicit, fileName, contentType string) (Format, error) {
+	switch {
+	case explicit != "":
+		switch Format(strings.ToLower(explicit)) {
+		case FormatCSV:
+			return FormatCSV, nil
+		case FormatNDJSON, "jsonl":
+			return FormatNDJSON, nil
+		}
+		return "", fmt.Errorf("unknown format %q", explicit)
+	case strings.EqualFold(filepath.Ext(fileName), ".csv"), strings.HasPrefix(contentType, "text/csv"):
+		return FormatCSV, nil
+	case strings.EqualFold(filepath.Ext(fileName), ".ndjson"), strings.EqualFold(filepath.Ext(fileName), ".jsonl"),
+		strings.HasPrefix(contentType, "application/x-ndjson"):
+		return FormatNDJSON, nil
+	}
+	return "", errors.New("cannot detect format, specify csv or ndjson")
+}
+
+// Processor загружает историческую метрику без уведомлений об аномалиях
+type Processor interface {
+	Backfill(ctx context.Context, metric models.Metric) (*models.AnalyticsResult, error)
+}
+
+// Progress ход импорта
+type Progress struct {
+	Processed int64  `json:"processed"`
+	Failed    int64  `json:"failed"`
+	Anomalies int64  `json:"anomalies"`
+	BytesRead int64  `json:"bytes_read"`
+	Done      bool   `json:"done"`
+	LastError string `json:"last_error,omitempty"` // Последняя ошибка строки
+	Error     string `json:"error,omitempty"`      // Ошибка, прервавшая импорт
+}
+
+// DefaultReportEvery как часто сообщать о ходе импорта, в метриках
+const DefaultReportEvery = 1000
+
+// Importer читает файл метрик и загружает их через Processor
+type Importer struct {
+	processor   Processor
+	reportEvery int
+}
+
+// NewImporter создает импортер
+func NewImporter(processor Processor, reportEvery int) *Importer {
+	if reportEvery <= 0 {
+		reportEvery = DefaultReportEvery
+	}
+	return &Importer{processor: processor, reportEvery: reportEvery}
+}
+
+// Import загружает все метрики из r. Ошибки отдельных строк и метрики
+// устройств вне области ключа из ctx считаются в Failed и не прерывают
+// импорт. report вызывается каждые reportEvery строк, удачных или нет, и в
+// конце с Done.
+func (i *Importer) Import(ctx context.Context, r io.Reader, format Format, report func(Progress)) (Progress, error) {
+	counter := &countingReader{r: r}
+	var progress Progress
+
+	next, err := newDecoder(counter, format)
+	if err == nil {
+		for {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+
+			var metric models.Metric
+			metric, err = next()
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			var rowErr *rowError
+			if errors.As(err, &rowErr) {
+				err = nil
+				progress.Failed++
+				progress.LastError = rowErr.Error()
+			} else if err != nil {
+				break
+			} else {
+				i.load(ctx, metric, &progress)
+			}
+
+			if total := progress.Processed + progress.Failed; total%int64(i.reportEvery) == 0 && report != nil {
+				progress.BytesRead = counter.n.Load()
+				report(progress)
+			}
+		}
+	}
+
+	progress.BytesRead = counter.n.Load()
+	progress.Done = true
+	if err != nil {
+		progress.Error = err.Error()
+	}
+	if report != nil {
+		report(progress)
+	}
+	return progress, err
+}
+
+// load проверяет и загружает одну метрику, учитывая итог в progress
+func (i *Importer) load(ctx context.Context, metric models.Metric, progress *Progress) {
+	if err := validate(metric); err != nil {
+		progress.Failed++
+		progress.LastError = err.Error()
+		return
+	}
+	// Ключ с правом импорта может быть ограничен частью устройств
+	if !auth.Allowed(ctx, metric.DeviceID, auth.PermissionWrite) {
+		metrics.RecordAuthFailure("forbidden")
+		progress.Failed++
+		progress.LastError = auth.ForbiddenMessage(auth.PermissionWrite, metric.DeviceID)
+		return
+	}
+
+	result, err := i.processor.Backfill(ctx, metric)
+	if err != nil {
+		progress.Failed++
+		progress.LastError = err.Error()
+		return
+	}
+	progress.Processed++
+	if result.IsAnomaly {
+		progress.Anomalies++
+	}
+}
+
+// rowError ошибка разбора отдельной строки, не прерывающая импорт
+type rowError struct {
+	line int
+	err  error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+// validate повторяет проверки POST /metric
+func validate(metric models.Metric) error {
+	if metric.DeviceID == "" {
+		return errors.New("device_id is required")
+	}
+	if metric.RPS < 0 {
+		return errors.New("rps must not be negative")
+	}
+	return nil
+}
+
+// newDecoder возвращает функцию чтения следующей метрики
+func newDecoder(r io.Reader, format Format) (func() (models.Metric, error), error) {
+	switch format {
+	case FormatNDJSON:
+		return ndjsonDecoder(r), nil
+	case FormatCSV:
+		return csvDecoder(r)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// ndjsonDecoder читает метрики построчно в JSON
+func ndjsonDecoder(r io.Reader) func() (models.Metric, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+
+	return func() (models.Metric, error) {
+		for scanner.Scan() {
+			line++
+			data := strings.TrimSpace(scanner.Text())
+			if data == "" {
+				continue
+			}
+			var metric models.Metric
+			if err := json.Unmarshal([]byte(data), &metric); err != nil {
+				return models.Metric{}, &rowError{line: line, err: err}
+			}
+			return metric, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return models.Metric{}, err
+		}
+		return models.Metric{}, io.EOF
+	}
+}
+
+// csvDecoder читает метрики из CSV с заголовком
+func csvDecoder(r io.Reader) (func() (models.Metric, error), error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"device_id", "timestamp"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv header must contain %q", required)
+		}
+	}
+
+	line := 1
+	return func() (models.Metric, error) {
+		record, err := reader.Read()
+		line++
+		if err == io.EOF {
+			return models.Metric{}, io.EOF
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return models.Metric{}, &rowError{line: line, err: err}
+		}
+		if err != nil {
+			return models.Metric{}, err
+		}
+
+		column := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		metric := models.Metric{DeviceID: column("device_id"), MessageID: column("message_id")}
+		if metric.Timestamp, err = parseTimestamp(column("timestamp")); err != nil {
+			return models.Metric{}, &rowError{line: line, err: err}
+		}
+		for name := range columns {
+			if name == "device_id" || name == "timestamp" || name == "message_id" || !models.ValidFieldName(name) {
+				continue
+			}
+			value := column(name)
+			if value == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			switch {
+			case err == nil:
+				metric.SetField(name, v)
+			case models.IsLegacyField(name):
+				return models.Metric{}, &rowError{line: line, err: fmt.Errorf("invalid %s: %w", name, err)}
+			}
+			// Нечисловое значение дополнительного столбца — не поле метрики
+		}
+		return metric, nil
+	}, nil
+}
+
+// parseTimestamp разбирает время в формате RFC3339 или Unix-секундах
+func parseTimestamp(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		sec := int64(seconds)
+		return time.Unix(sec, int64((seconds-float64(sec))*1e9)).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// countingReader считает прочитанные байты для отчета о ходе импорта
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
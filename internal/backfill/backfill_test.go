@@ -0,0 +1,84 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go-service/internal/models"
+)
+
+// recordingProcessor запоминает загруженные метрики и отклоняет устройство failDevice
+type recordingProcessor struct {
+	failDevice string
+	metrics    []models.Metric
+}
+
+func (p *recordingProcessor) Backfill(_ context.Context, metric models.Metric) (*models.AnalyticsResult, error) {
+	if metric.DeviceID == p.failDevice {
+		return nil, errors.New("rejected")
+	}
+	p.metrics = append(p.metrics, metric)
+	return &models.AnalyticsResult{DeviceID: metric.DeviceID}, nil
+}
+
+func TestImportReportsOnFailedRows(t *testing.T) {
+	var input strings.Builder
+	for _, device := range []string{"ok", "bad", "bad", "bad", "ok", "bad"} {
+		input.WriteString(`{"device_id":"` + device + `","timestamp":"2024-01-01T00:00:00Z"}` + "\n")
+	}
+
+	var reports []Progress
+	importer := NewImporter(&recordingProcessor{failDevice: "bad"}, 2)
+	progress, err := importer.Import(context.Background(), strings.NewReader(input.String()), FormatNDJSON, func(p Progress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if progress.Processed != 2 || progress.Failed != 4 {
+		t.Errorf("processed %d, failed %d, want 2 and 4", progress.Processed, progress.Failed)
+	}
+	// Отчеты после 2, 4 и 6 строк, в том числе на неудачных, и итоговый
+	if len(reports) != 4 {
+		t.Fatalf("got %d reports, want 4: %+v", len(reports), reports)
+	}
+	for n, p := range reports[:3] {
+		if total := p.Processed + p.Failed; total != int64(2*(n+1)) {
+			t.Errorf("report %d after %d rows, want %d", n, total, 2*(n+1))
+		}
+	}
+	if !reports[3].Done {
+		t.Error("last report is not done")
+	}
+}
+
+func TestCSVColumns(t *testing.T) {
+	input := "device_id,timestamp,message_id,cpu,temperature,location,Note\n" +
+		"sensor-1,2024-01-01T00:00:00Z,msg-1,10,21.5,room-3,checked\n" +
+		"sensor-1,2024-01-01T00:01:00Z,msg-2,high,22,room-3,\n"
+
+	processor := &recordingProcessor{}
+	progress, err := NewImporter(processor, 0).Import(context.Background(), strings.NewReader(input), FormatCSV, nil)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if progress.Processed != 1 || progress.Failed != 1 {
+		t.Fatalf("processed %d, failed %d, want 1 and 1 (%s)", progress.Processed, progress.Failed, progress.LastError)
+	}
+	if !strings.Contains(progress.LastError, "invalid cpu") {
+		t.Errorf("last error = %q, want invalid cpu", progress.LastError)
+	}
+
+	metric := processor.metrics[0]
+	if metric.MessageID != "msg-1" {
+		t.Errorf("message id = %q, want msg-1", metric.MessageID)
+	}
+	if metric.CPU != 10 || metric.Values["temperature"] != 21.5 {
+		t.Errorf("cpu %v, temperature %v, want 10 and 21.5", metric.CPU, metric.Values["temperature"])
+	}
+	if _, ok := metric.Values["location"]; ok || len(metric.Values) != 1 {
+		t.Errorf("values = %v, want only temperature", metric.Values)
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrNil возвращается, когда ключ отсутствует в Redis
+var ErrNil = redis.Nil
+
 // RedisClient обертка для клиента Redis
 type RedisClient struct {
 	client *redis.Client
@@ -46,6 +50,42 @@ func (r *RedisClient) Set(ctx context.Context, key string, value interface{}, ex
 	return r.client.Set(ctx, key, data, expiration).Err()
 }
 
+// SetRaw сохраняет в Redis готовые сериализованные значения одним конвейером
+func (r *RedisClient) SetRaw(ctx context.Context, items map[string][]byte, expiration time.Duration) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, data := range items {
+			pipe.Set(ctx, key, data, expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+// SetNX сохраняет готовое значение, только если ключа еще нет
+func (r *RedisClient) SetNX(ctx context.Context, key string, value []byte, expiration time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, key, value, expiration).Result()
+}
+
+// GetRaw получает значение из Redis без десериализации
+func (r *RedisClient) GetRaw(ctx context.Context, key string) ([]byte, error) {
+	return r.client.Get(ctx, key).Bytes()
+}
+
+// Delete удаляет ключи
+func (r *RedisClient) Delete(ctx context.Context, keys ...string) error {
+	return r.client.Del(ctx, keys...).Err()
+}
+
+// RenameNX переименовывает ключ, если ключа newKey еще нет. Отсутствие
+// исходного ключа не считается ошибкой: его мог переименовать другой узел.
+func (r *RedisClient) RenameNX(ctx context.Context, key, newKey string) (bool, error) {
+	renamed, err := r.client.RenameNX(ctx, key, newKey).Result()
+	if err != nil && strings.Contains(err.Error(), "no such key") {
+		return false, nil
+	}
+	return renamed, err
+}
+
 // Get получает значение из Redis
 func (r *RedisClient) Get(ctx context.Context, key string, dest interface{}) error {
 	data, err := r.client.Get(ctx, key).Result()
@@ -55,6 +95,35 @@ func (r *RedisClient) Get(ctx context.Context, key string, dest interface{}) err
 	return json.Unmarshal([]byte(data), dest)
 }
 
+// ScanKeys возвращает все ключи, подходящие под шаблон
+func (r *RedisClient) ScanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+// PushCapped добавляет значение в начало списка и обрезает его до maxLen элементов
+func (r *RedisClient) PushCapped(ctx context.Context, key string, value interface{}, maxLen int64) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, data)
+		pipe.LTrim(ctx, key, 0, maxLen-1)
+		return nil
+	})
+	return err
+}
+
+// ListRange возвращает элементы списка в диапазоне [start, stop]
+func (r *RedisClient) ListRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return r.client.LRange(ctx, key, start, stop).Result()
+}
+
 // Close закрывает соединение с Redis
 func (r *RedisClient) Close() error {
 	return r.client.Close()
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StreamMessage запись потока Redis
+type StreamMessage = redis.XMessage
+
+// PendingEntry доставленная, но не подтвержденная запись группы
+type PendingEntry = redis.XPendingExt
+
+// StreamGroupInfo состояние группы потребителей
+type StreamGroupInfo = redis.XInfoGroup
+
+// CreateGroup создает группу потребителей и сам поток, если их еще нет.
+// Новая группа читает поток с начала.
+func (r *RedisClient) CreateGroup(ctx context.Context, stream, group string) error {
+	err := r.client.XGroupCreateMkStream(ctx, stream, group, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// ReadGroup читает записи потока для потребителя группы. id ">" возвращает
+// новые записи, "0" — уже доставленные этому потребителю и не подтвержденные.
+// По истечении block без записей возвращает nil без ошибки.
+func (r *RedisClient) ReadGroup(ctx context.Context, stream, group, consumer, id string, count int64, block time.Duration) ([]StreamMessage, error) {
+	streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, id},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	return streams[0].Messages, nil
+}
+
+// Ack подтверждает обработку записей
+func (r *RedisClient) Ack(ctx context.Context, stream, group string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.client.XAck(ctx, stream, group, ids...).Err()
+}
+
+// ClaimIdle передает потребителю записи, не подтвержденные дольше minIdle,
+// начиная с start. Возвращает записи и start для следующего вызова, "0-0"
+// после прохода по всем записям.
+func (r *RedisClient) ClaimIdle(ctx context.Context, stream, group, consumer string, minIdle time.Duration, start string, count int64) ([]StreamMessage, string, error) {
+	return r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  minIdle,
+		Start:    start,
+		Count:    count,
+	}).Result()
+}
+
+// PendingIdle возвращает не подтвержденные дольше minIdle записи группы
+// с количеством доставок
+func (r *RedisClient) PendingIdle(ctx context.Context, stream, group string, minIdle time.Duration, count int64) ([]PendingEntry, error) {
+	return r.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Idle:   minIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+}
+
+// StreamRange возвращает записи потока в диапазоне идентификаторов
+func (r *RedisClient) StreamRange(ctx context.Context, stream, start, end string) ([]StreamMessage, error) {
+	return r.client.XRange(ctx, stream, start, end).Result()
+}
+
+// GroupInfo возвращает состояние группы потребителей потока
+func (r *RedisClient) GroupInfo(ctx context.Context, stream, group string) (StreamGroupInfo, error) {
+	groups, err := r.client.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		return StreamGroupInfo{}, err
+	}
+	for _, info := range groups {
+		if info.Name == group {
+			return info, nil
+		}
+	}
+	return StreamGroupInfo{}, ErrNil
+}
+
+// StreamAdd добавляет запись в поток и возвращает ее идентификатор
+func (r *RedisClient) StreamAdd(ctx context.Context, stream string, values map[string]interface{}) (string, error) {
+	return r.client.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: values}).Result()
+}
+
+// StreamRevRange возвращает до count записей потока в диапазоне
+// идентификаторов от новых к старым
+func (r *RedisClient) StreamRevRange(ctx context.Context, stream, end, start string, count int64) ([]StreamMessage, error) {
+	return r.client.XRevRangeN(ctx, stream, end, start, count).Result()
+}
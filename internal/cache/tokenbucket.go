@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript атомарно пополняет корзину по времени сервера Redis
+// и списывает n жетонов. Запрос больше корзины пропускается при полной
+// корзине и уводит ее в минус, поэтому средняя скорость не превышается.
+// Возвращает {1, 0} или {0, секунд до разрешения}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local need = math.min(n, burst)
+if tokens < need then
+	return {0, tostring((need - tokens) / rate)}
+end
+tokens = tokens - n
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {1, '0'}
+`)
+
+// TakeTokens списывает n жетонов из корзины key с пополнением rate в
+// секунду и емкостью burst. Если жетонов не хватает, возвращает false
+// и время до их накопления.
+func (r *RedisClient) TakeTokens(ctx context.Context, key string, rate float64, burst, n int) (bool, time.Duration, error) {
+	values, err := tokenBucketScript.Run(ctx, r.client, []string{key}, rate, burst, n).Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	allowed, _ := values[0].(int64)
+	wait, _ := values[1].(string)
+	seconds, err := strconv.ParseFloat(wait, 64)
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed == 1, time.Duration(math.Ceil(seconds * float64(time.Second))), nil
+}
@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// WriteBehind накапливает значения по ключам и периодически сбрасывает
+// их в Redis конвейером. Повторная запись по ключу заменяет ожидающее
+// значение, поэтому в очереди хранится не больше одного значения на ключ.
+type WriteBehind struct {
+	redis      *RedisClient
+	expiration time.Duration
+	interval   time.Duration
+	batchSize  int
+	maxPending int
+	logger     *zap.SugaredLogger
+
+	mu      sync.Mutex
+	pending map[string][]byte
+
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+	once    sync.Once
+}
+
+// NewWriteBehind создает очередь и запускает фоновый сброс
+func NewWriteBehind(redis *RedisClient, expiration, interval time.Duration, batchSize, maxPending int, logger *zap.SugaredLogger) *WriteBehind {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if maxPending < batchSize {
+		maxPending = batchSize
+	}
+
+	w := &WriteBehind{
+		redis:      redis,
+		expiration: expiration,
+		interval:   interval,
+		batchSize:  batchSize,
+		maxPending: maxPending,
+		logger:     logger,
+		pending:    make(map[string][]byte),
+		flushCh:    make(chan struct{}, 1),
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Enqueue ставит значение в очередь на запись. Возвращает false, если
+// очередь переполнена и значение отброшено.
+func (w *WriteBehind) Enqueue(key string, value interface{}) bool {
+	data, err := json.Marshal(value)
+	if err != nil {
+		w.logger.Errorf("Failed to marshal write-behind value for %s: %v", key, err)
+		return false
+	}
+
+	w.mu.Lock()
+	if _, exists := w.pending[key]; exists {
+		w.pending[key] = data
+		w.mu.Unlock()
+		metrics.RecordWriteBehindCoalesced()
+		return true
+	}
+	if len(w.pending) >= w.maxPending {
+		w.mu.Unlock()
+		metrics.RecordWriteBehindDropped()
+		w.signal()
+		return false
+	}
+	w.pending[key] = data
+	size := len(w.pending)
+	w.mu.Unlock()
+
+	metrics.SetWriteBehindPending(size)
+	if size >= w.batchSize {
+		w.signal()
+	}
+	return true
+}
+
+// Get читает еще не сброшенное значение по ключу
+func (w *WriteBehind) Get(key string, dest interface{}) bool {
+	w.mu.Lock()
+	data, exists := w.pending[key]
+	w.mu.Unlock()
+	if !exists {
+		return false
+	}
+	return json.Unmarshal(data, dest) == nil
+}
+
+// Flush синхронно сбрасывает все ожидающие значения
+func (w *WriteBehind) Flush(ctx context.Context) error {
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = make(map[string][]byte)
+	w.mu.Unlock()
+	metrics.SetWriteBehindPending(0)
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	defer func() { metrics.RecordWriteBehindFlushDuration(time.Since(start)) }()
+
+	chunk := make(map[string][]byte, w.batchSize)
+	var firstErr error
+	for key, data := range batch {
+		chunk[key] = data
+		if len(chunk) < w.batchSize {
+			continue
+		}
+		if err := w.write(ctx, chunk); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		chunk = make(map[string][]byte, w.batchSize)
+	}
+	if len(chunk) > 0 {
+		if err := w.write(ctx, chunk); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// write отправляет пачку значений, при ошибке возвращая их в очередь
+func (w *WriteBehind) write(ctx context.Context, chunk map[string][]byte) error {
+	err := w.redis.SetRaw(ctx, chunk, w.expiration)
+	if err == nil {
+		metrics.RecordWriteBehindFlushed(len(chunk))
+		metrics.RecordRedisOperation("pipeline_set")
+		return nil
+	}
+
+	metrics.RecordWriteBehindFlushError()
+
+	// Возвращаем неотправленные значения, не затирая более свежие
+	w.mu.Lock()
+	requeued := 0
+	for key, data := range chunk {
+		if _, exists := w.pending[key]; exists {
+			continue
+		}
+		if len(w.pending) >= w.maxPending {
+			metrics.RecordWriteBehindDropped()
+			continue
+		}
+		w.pending[key] = data
+		requeued++
+	}
+	size := len(w.pending)
+	w.mu.Unlock()
+	metrics.SetWriteBehindPending(size)
+
+	w.logger.Errorf("Write-behind flush failed, requeued %d of %d values: %v", requeued, len(chunk), err)
+	return err
+}
+
+// Close останавливает фоновый сброс и записывает оставшиеся значения
+func (w *WriteBehind) Close(ctx context.Context) error {
+	w.once.Do(func() { close(w.stopCh) })
+	<-w.doneCh
+	return w.Flush(ctx)
+}
+
+// signal будит фоновый цикл для внеочередного сброса
+func (w *WriteBehind) signal() {
+	select {
+	case w.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+// run периодически сбрасывает очередь
+func (w *WriteBehind) run() {
+	defer close(w.doneCh)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+		case <-w.flushCh:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), w.interval+5*time.Second)
+		w.Flush(ctx)
+		cancel()
+	}
+}
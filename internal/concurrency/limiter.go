@@ -0,0 +1,228 @@
+package concurrency
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// Priority приоритет запроса при перегрузке
+type Priority int
+
+const (
+	// PriorityCritical проверки здоровья и готовности, сбор метрик: не
+	// отклоняются и не занимают лимит
+	PriorityCritical Priority = iota
+	// PriorityIngest прием метрик, может занять весь лимит
+	PriorityIngest
+	// PriorityNormal чтение отдельных устройств и администрирование
+	PriorityNormal
+	// PriorityLow дорогие запросы: сводки, ряды истории, выгрузки
+	PriorityLow
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityCritical:
+		return "critical"
+	case PriorityIngest:
+		return "ingest"
+	case PriorityNormal:
+		return "normal"
+	default:
+		return "low"
+	}
+}
+
+// shares доля лимита, до которой принимаются запросы приоритета.
+// Дорогие запросы отклоняются первыми и оставляют место приему метрик.
+var shares = map[Priority]float64{
+	PriorityIngest: 1.0,
+	PriorityNormal: 0.75,
+	PriorityLow:    0.5,
+}
+
+// Сглаживание задержек приема: короткое отражает текущую нагрузку,
+// длинное — обычную задержку сервиса
+const (
+	shortAlpha = 0.1
+	longAlpha  = 1.0 / 600
+	// limitAlpha доля нового значения при пересчете лимита
+	limitAlpha = 0.05
+	// minGradient во сколько раз лимит может сжаться за один пересчет
+	minGradient = 0.5
+)
+
+// Config настройки ограничения одновременных запросов
+type Config struct {
+	MaxLimit  int     // Верхняя граница лимита, MAX_CONCURRENT_REQUESTS
+	MinLimit  int     // Нижняя граница лимита при росте задержек
+	Tolerance float64 // Во сколько раз задержка приема может превысить обычную
+}
+
+// Validate проверяет границы и допуск
+func (c Config) Validate() error {
+	if c.MaxLimit < 1 {
+		return fmt.Errorf("max limit must be at least 1, got %d", c.MaxLimit)
+	}
+	if c.MinLimit < 1 || c.MinLimit > c.MaxLimit {
+		return fmt.Errorf("min limit must be between 1 and %d, got %d", c.MaxLimit, c.MinLimit)
+	}
+	if c.Tolerance < 1 {
+		return fmt.Errorf("latency tolerance must be at least 1, got %v", c.Tolerance)
+	}
+	return nil
+}
+
+// Limiter адаптивное ограничение одновременных запросов. Лимит начинается
+// с MaxLimit и сжимается, когда задержка приема метрик растет выше
+// обычной больше чем в Tolerance раз, а после спада нагрузки
+// восстанавливается. Запросы сверх доли лимита своего приоритета
+// получают 503.
+type Limiter struct {
+	cfg      Config
+	classify func(*http.Request) Priority
+	logger   *zap.SugaredLogger
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight map[Priority]int
+	active   int     // Занимающих лимит запросов, без критичных
+	short    float64 // Текущая задержка приема, секунды
+	long     float64 // Обычная задержка приема, секунды
+}
+
+// NewLimiter создает ограничение. classify определяет приоритет
+// сопоставленного маршрутом запроса.
+func NewLimiter(cfg Config, classify func(*http.Request) Priority, logger *zap.SugaredLogger) *Limiter {
+	metrics.SetConcurrencyLimit(cfg.MaxLimit)
+	return &Limiter{
+		cfg:      cfg,
+		classify: classify,
+		logger:   logger,
+		limit:    float64(cfg.MaxLimit),
+		inFlight: make(map[Priority]int),
+	}
+}
+
+// Middleware отклоняет запросы сверх лимита с 503 и Retry-After.
+// Соединения WebSocket живут долго и не учитываются. Подключается до
+// проверки ключей API, поэтому учитывает и неаутентифицированные запросы.
+// Место освобождается и при панике или отмене запроса в обработчике.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		priority := l.classify(r)
+		if !l.acquire(priority) {
+			metrics.RecordShedRequest(priority.String())
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Server is overloaded, retry later", http.StatusServiceUnavailable)
+			return
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			// Задержку задает прием метрик: его запросы однородны и
+			// короткие, ошибки не отражают нагрузку
+			sample := priority == PriorityIngest && recorder.status < http.StatusInternalServerError
+			l.release(priority, time.Since(start), sample)
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// Limit возвращает текущий лимит
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// acquire занимает место для запроса или возвращает false
+func (l *Limiter) acquire(priority Priority) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if priority != PriorityCritical {
+		if float64(l.active) >= l.limit*shares[priority] {
+			return false
+		}
+		l.active++
+	}
+	l.inFlight[priority]++
+	metrics.SetInFlightRequests(priority.String(), l.inFlight[priority])
+	return true
+}
+
+// release освобождает место и пересчитывает лимит по задержке запроса
+func (l *Limiter) release(priority Priority, latency time.Duration, sample bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if priority != PriorityCritical {
+		l.active--
+	}
+	l.inFlight[priority]--
+	metrics.SetInFlightRequests(priority.String(), l.inFlight[priority])
+	if sample {
+		l.update(latency.Seconds())
+	}
+}
+
+// update пересчитывает лимит: при задержке выше обычной в Tolerance раз
+// лимит сжимается пропорционально, иначе растет на корень из себя.
+// Вызывается под блокировкой.
+func (l *Limiter) update(rtt float64) {
+	if l.long == 0 {
+		l.short, l.long = rtt, rtt
+	} else {
+		l.short += (rtt - l.short) * shortAlpha
+		l.long += (rtt - l.long) * longAlpha
+	}
+	if l.short <= 0 {
+		return
+	}
+
+	gradient := math.Max(minGradient, math.Min(1, l.cfg.Tolerance*l.long/l.short))
+	target := l.limit*gradient + math.Sqrt(l.limit)
+	limit := l.limit + (target-l.limit)*limitAlpha
+	limit = math.Max(float64(l.cfg.MinLimit), math.Min(float64(l.cfg.MaxLimit), limit))
+
+	if int(limit) != int(l.limit) {
+		metrics.SetConcurrencyLimit(int(limit))
+		if int(limit) == l.cfg.MinLimit && int(l.limit) > l.cfg.MinLimit {
+			l.logger.Warnf("Concurrency limit dropped to its minimum %d, ingestion latency %.1fms vs usual %.1fms",
+				l.cfg.MinLimit, l.short*1000, l.long*1000)
+		}
+	}
+	l.limit = limit
+}
+
+// statusRecorder запоминает код ответа обработчика
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap открывает исходный ResponseWriter для http.ResponseController,
+// например для потокового ответа импорта
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
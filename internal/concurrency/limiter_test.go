@@ -0,0 +1,146 @@
+package concurrency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+func newTestLimiter(cfg Config, priority Priority) *Limiter {
+	metrics.InitMetrics()
+	return NewLimiter(cfg, func(*http.Request) Priority { return priority }, zap.NewNop().Sugar())
+}
+
+// activeRequests количество запросов, занимающих лимит
+func (l *Limiter) activeRequests() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
+
+func TestLowPriorityShedFirst(t *testing.T) {
+	l := newTestLimiter(Config{MaxLimit: 4, MinLimit: 1, Tolerance: 2}, PriorityLow)
+
+	// Дорогие запросы занимают не больше половины лимита
+	for i := 0; i < 2; i++ {
+		if !l.acquire(PriorityLow) {
+			t.Fatalf("low request %d rejected below its share", i)
+		}
+	}
+	if l.acquire(PriorityLow) {
+		t.Fatal("low request accepted over half of the limit")
+	}
+	if !l.acquire(PriorityNormal) {
+		t.Fatal("normal request rejected below its share")
+	}
+	if l.acquire(PriorityNormal) {
+		t.Fatal("normal request accepted over three quarters of the limit")
+	}
+	if !l.acquire(PriorityIngest) {
+		t.Fatal("ingest request rejected below the limit")
+	}
+	if l.acquire(PriorityIngest) {
+		t.Fatal("ingest request accepted over the limit")
+	}
+	for i := 0; i < 10; i++ {
+		if !l.acquire(PriorityCritical) {
+			t.Fatal("critical request rejected")
+		}
+	}
+}
+
+func TestLimitAdaptsToLatency(t *testing.T) {
+	l := newTestLimiter(Config{MaxLimit: 100, MinLimit: 10, Tolerance: 2}, PriorityIngest)
+
+	for i := 0; i < 100; i++ {
+		l.update(0.01)
+	}
+	if got := l.Limit(); got != 100 {
+		t.Fatalf("limit at usual latency = %d, want 100", got)
+	}
+
+	// Задержка выше обычной больше допуска сжимает лимит до нижней границы,
+	// пока обычная задержка медленно не подстроится под новую нагрузку
+	for i := 0; i < 200; i++ {
+		l.update(1)
+	}
+	if got := l.Limit(); got != 10 {
+		t.Fatalf("limit under high latency = %d, want 10", got)
+	}
+
+	// После спада нагрузки лимит восстанавливается
+	for i := 0; i < 2000; i++ {
+		l.update(0.01)
+	}
+	if got := l.Limit(); got != 100 {
+		t.Errorf("limit after recovery = %d, want 100", got)
+	}
+}
+
+func TestMiddlewareReleasesSlot(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		ctx     func() context.Context
+	}{
+		{
+			name:    "panic",
+			handler: func(http.ResponseWriter, *http.Request) { panic("handler failed") },
+			ctx:     context.Background,
+		},
+		{
+			name: "cancellation",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			ctx: func() context.Context {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+				return ctx
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLimiter(Config{MaxLimit: 1, MinLimit: 1, Tolerance: 2}, PriorityIngest)
+			handler := l.Middleware(tt.handler)
+
+			func() {
+				defer func() { recover() }()
+				req := httptest.NewRequest(http.MethodPost, "/metric", nil).WithContext(tt.ctx())
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}()
+			if got := l.activeRequests(); got != 0 {
+				t.Fatalf("%d requests still hold the limit", got)
+			}
+
+			ok := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			rec := httptest.NewRecorder()
+			ok.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metric", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("next request got %d, want 200", rec.Code)
+			}
+		})
+	}
+}
+
+func TestMiddlewareSheds(t *testing.T) {
+	l := newTestLimiter(Config{MaxLimit: 1, MinLimit: 1, Tolerance: 2}, PriorityIngest)
+	if !l.acquire(PriorityIngest) {
+		t.Fatal("first request rejected")
+	}
+
+	rec := httptest.NewRecorder()
+	l.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("handler called over the limit")
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metric", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("request over the limit got %d, Retry-After %q; want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
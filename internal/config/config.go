@@ -0,0 +1,335 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config содержит настройки сервиса из переменных окружения
+type Config struct {
+	ServerPort string
+	GRPCPort   string // Порт gRPC API, пустой отключает (по умолчанию)
+	RedisHost  string // Хост Redis; если задан, Redis используется для общих между узлами ключей
+
+	// Аналитика
+	WindowSize       int
+	WindowDuration   time.Duration
+	WindowMaxSamples int
+	WindowOverrides  map[string]string // Окна устройств арендатора по умолчанию: device=100, device=15m или device=100/15m
+	AllowedLateness  time.Duration
+	LatePolicy       string        // drop или history
+	MaxClockSkew     time.Duration // Насколько метка метрики может опережать время сервера
+	AnomalyThreshold float64
+	FieldSpecs       map[string]string // Поле -> единица и диапазон, например celsius:-40..125
+	ResultTTL        time.Duration
+
+	// Настройки арендаторов
+	TenantWindows    map[string]string // Окна по умолчанию арендаторов в формате WindowOverrides
+	TenantMaxDevices map[string]string // Арендатор -> предел устройств
+
+	// Окно подавления повторных метрик с тем же message_id или Idempotency-Key, 0 отключает
+	IdempotencyWindow time.Duration
+
+	// Хранилище: memory, redis или disk
+	StorageBackend string
+	StoragePath    string
+
+	// История сырых метрик
+	HistoryEnabled   bool
+	HistoryPath      string
+	HistoryRetention time.Duration
+	HistoryPartition time.Duration
+	HistoryFlush     time.Duration
+
+	// Сроки хранения агрегатов истории по разрешениям
+	RollupRetention1m time.Duration
+	RollupRetention1h time.Duration
+	RollupRetention1d time.Duration
+
+	// Прием Prometheus remote_write
+	RemoteWriteEnabled     bool
+	RemoteWriteDeviceLabel string
+	RemoteWriteFields      map[string]string // Имя метрики Prometheus -> поле метрики
+
+	// Прием InfluxDB line protocol
+	InfluxEnabled   bool
+	InfluxDeviceTag string
+	InfluxFields    map[string]string // measurement.field или field -> поле метрики
+	InfluxUDPAddr   string            // Адрес UDP-приема, пустой отключает
+	// UDP-прием без ключей и ограничения скорости в арендатора по умолчанию,
+	// только для доверенной сети
+	InfluxUDPTrusted bool
+
+	// Прием метрик OTLP/HTTP
+	OTLPEnabled          bool
+	OTLPDeviceAttributes []string          // Атрибуты ресурса с устройством по приоритету
+	OTLPFields           map[string]string // Имя метрики OTLP -> поле метрики
+
+	// Подписка на брокер MQTT, пустой MQTTBroker отключает
+	MQTTBroker       string
+	MQTTClientID     string
+	MQTTUsername     string
+	MQTTPassword     string
+	MQTTTopics       []string // Шаблоны топиков с уровнем {device}
+	MQTTMaxReconnect time.Duration
+
+	// Чтение метрик из потока Redis группой потребителей
+	StreamEnabled       bool
+	StreamName          string
+	StreamGroup         string
+	StreamConsumer      string
+	StreamBatchSize     int
+	StreamBlock         time.Duration
+	StreamClaimIdle     time.Duration
+	StreamMaxDeliveries int
+
+	// Канал устройств по WebSocket
+	WSRateLimit    float64 // Метрик в секунду на соединение
+	WSRateBurst    int
+	WSPingInterval time.Duration
+
+	// Аутентификация по ключам API
+	AuthEnabled  bool
+	AuthAdminKey string // Начальный ключ администратора для выдачи ключей
+
+	// Вход пользователей по JWT, пустые JWTJWKSURL и JWTJWKSFile отключают
+	JWTJWKSURL     string
+	JWTJWKSFile    string
+	JWTJWKSRefresh time.Duration
+	JWTIssuer      string
+	JWTAudience    string
+	JWTClockSkew   time.Duration
+	JWTRoleClaim   string
+	JWTRoles       map[string]string // Роль из claim -> роль viewer, ingest, operator или admin
+	JWTTenantClaim string
+	// Арендатор токенов без claim арендатора, пустой отклоняет такие токены
+	JWTDefaultTenant string
+
+	// Ограничение скорости приема метрик по HTTP, WebSocket и gRPC, общее
+	// для узлов через Redis. Метрик в секунду, 0 отключает.
+	RateLimitDevice      float64
+	RateLimitDeviceBurst int
+	RateLimitKey         float64
+	RateLimitKeyBurst    int
+
+	// Ограничение одновременных запросов HTTP, 0 отключает. Лимит
+	// сжимается до ConcurrencyMinLimit, когда задержка приема метрик
+	// превышает обычную в ConcurrencyTolerance раз.
+	MaxConcurrentRequests int
+	ConcurrencyMinLimit   int
+	ConcurrencyTolerance  float64
+
+	// Журнал административных действий на диске, если Redis не используется
+	AuditPath string
+
+	// Отложенная запись результатов в Redis
+	WriteBehindInterval   time.Duration
+	WriteBehindBatchSize  int
+	WriteBehindMaxPending int
+}
+
+// Load читает конфигурацию из окружения с дефолтными значениями и
+// проверяет ее
+func Load() (*Config, error) {
+	cfg := &Config{
+		ServerPort: getEnv("SERVER_PORT", "8080"),
+		GRPCPort:   getEnv("GRPC_PORT", ""),
+		RedisHost:  getEnv("REDIS_HOST", ""),
+
+		WindowSize:       getEnvInt("WINDOW_SIZE", 50),
+		WindowDuration:   getEnvDuration("WINDOW_DURATION", 0),
+		WindowMaxSamples: getEnvInt("WINDOW_MAX_SAMPLES", 10000),
+		WindowOverrides:  getEnvMap("WINDOW_OVERRIDES"),
+		AllowedLateness:  getEnvDuration("ALLOWED_LATENESS", time.Minute),
+		LatePolicy:       getEnv("LATE_METRIC_POLICY", "drop"),
+		MaxClockSkew:     getEnvDuration("MAX_CLOCK_SKEW", 5*time.Minute),
+		AnomalyThreshold: getEnvFloat("ANOMALY_THRESHOLD", 2.0),
+		FieldSpecs:       getEnvMap("FIELD_SPECS"),
+		ResultTTL:        getEnvDuration("RESULT_TTL", 5*time.Minute),
+
+		TenantWindows:    getEnvMap("TENANT_WINDOWS"),
+		TenantMaxDevices: getEnvMap("TENANT_MAX_DEVICES"),
+
+		IdempotencyWindow: getEnvDuration("IDEMPOTENCY_WINDOW", 10*time.Minute),
+
+		StorageBackend: getEnv("STORAGE_BACKEND", "redis"),
+		StoragePath:    getEnv("STORAGE_PATH", "data/analytics.db"),
+
+		HistoryEnabled:   getEnvBool("HISTORY_ENABLED", true),
+		HistoryPath:      getEnv("HISTORY_PATH", "data/history"),
+		HistoryRetention: getEnvDuration("HISTORY_RETENTION", 7*24*time.Hour),
+		HistoryPartition: getEnvDuration("HISTORY_PARTITION", 2*time.Hour),
+		HistoryFlush:     getEnvDuration("HISTORY_FLUSH_INTERVAL", time.Minute),
+
+		RollupRetention1m: getEnvDuration("ROLLUP_RETENTION_1M", 30*24*time.Hour),
+		RollupRetention1h: getEnvDuration("ROLLUP_RETENTION_1H", 365*24*time.Hour),
+		RollupRetention1d: getEnvDuration("ROLLUP_RETENTION_1D", 5*365*24*time.Hour),
+
+		RemoteWriteEnabled:     getEnvBool("REMOTE_WRITE_ENABLED", false),
+		RemoteWriteDeviceLabel: getEnv("REMOTE_WRITE_DEVICE_LABEL", "device_id"),
+		RemoteWriteFields:      getEnvMap("REMOTE_WRITE_FIELDS"),
+
+		InfluxEnabled:    getEnvBool("INFLUX_ENABLED", false),
+		InfluxDeviceTag:  getEnv("INFLUX_DEVICE_TAG", "host"),
+		InfluxFields:     getEnvMap("INFLUX_FIELDS"),
+		InfluxUDPAddr:    getEnv("INFLUX_UDP_ADDR", ""),
+		InfluxUDPTrusted: getEnvBool("INFLUX_UDP_TRUSTED", false),
+
+		OTLPEnabled:          getEnvBool("OTLP_ENABLED", false),
+		OTLPDeviceAttributes: getEnvList("OTLP_DEVICE_ATTRIBUTES", []string{"device.id", "host.name", "service.instance.id"}),
+		OTLPFields:           getEnvMap("OTLP_FIELDS"),
+
+		MQTTBroker:       getEnv("MQTT_BROKER", ""),
+		MQTTClientID:     getEnv("MQTT_CLIENT_ID", defaultClientID()),
+		MQTTUsername:     getEnv("MQTT_USERNAME", ""),
+		MQTTPassword:     getEnv("MQTT_PASSWORD", ""),
+		MQTTTopics:       getEnvList("MQTT_TOPICS", []string{"devices/{device}/metrics"}),
+		MQTTMaxReconnect: getEnvDuration("MQTT_MAX_RECONNECT_INTERVAL", 30*time.Second),
+
+		StreamEnabled:       getEnvBool("STREAM_ENABLED", false),
+		StreamName:          getEnv("STREAM_NAME", "metrics"),
+		StreamGroup:         getEnv("STREAM_GROUP", "go-service"),
+		StreamConsumer:      getEnv("STREAM_CONSUMER", defaultClientID()),
+		StreamBatchSize:     getEnvInt("STREAM_BATCH_SIZE", 100),
+		StreamBlock:         getEnvDuration("STREAM_BLOCK", 5*time.Second),
+		StreamClaimIdle:     getEnvDuration("STREAM_CLAIM_IDLE", time.Minute),
+		StreamMaxDeliveries: getEnvInt("STREAM_MAX_DELIVERIES", 5),
+
+		WSRateLimit:    getEnvFloat("WS_RATE_LIMIT", 50),
+		WSRateBurst:    getEnvInt("WS_RATE_BURST", 100),
+		WSPingInterval: getEnvDuration("WS_PING_INTERVAL", 30*time.Second),
+
+		AuthEnabled:  getEnvBool("AUTH_ENABLED", false),
+		AuthAdminKey: getEnv("AUTH_ADMIN_KEY", ""),
+
+		JWTJWKSURL:       getEnv("JWT_JWKS_URL", ""),
+		JWTJWKSFile:      getEnv("JWT_JWKS_FILE", ""),
+		JWTJWKSRefresh:   getEnvDuration("JWT_JWKS_REFRESH", 10*time.Minute),
+		JWTIssuer:        getEnv("JWT_ISSUER", ""),
+		JWTAudience:      getEnv("JWT_AUDIENCE", ""),
+		JWTClockSkew:     getEnvDuration("JWT_CLOCK_SKEW", time.Minute),
+		JWTRoleClaim:     getEnv("JWT_ROLE_CLAIM", "roles"),
+		JWTRoles:         getEnvMap("JWT_ROLES"),
+		JWTTenantClaim:   getEnv("JWT_TENANT_CLAIM", "tenant"),
+		JWTDefaultTenant: getEnv("JWT_DEFAULT_TENANT", ""),
+
+		RateLimitDevice:      getEnvFloat("RATE_LIMIT_DEVICE", 0),
+		RateLimitDeviceBurst: getEnvInt("RATE_LIMIT_DEVICE_BURST", 100),
+		RateLimitKey:         getEnvFloat("RATE_LIMIT_KEY", 0),
+		RateLimitKeyBurst:    getEnvInt("RATE_LIMIT_KEY_BURST", 1000),
+
+		MaxConcurrentRequests: getEnvInt("MAX_CONCURRENT_REQUESTS", 1000),
+		ConcurrencyMinLimit:   getEnvInt("CONCURRENCY_MIN_LIMIT", 20),
+		ConcurrencyTolerance:  getEnvFloat("CONCURRENCY_LATENCY_TOLERANCE", 2.0),
+
+		AuditPath: getEnv("AUDIT_PATH", "data/audit.log"),
+
+		WriteBehindInterval:   getEnvDuration("WRITE_BEHIND_INTERVAL", time.Second),
+		WriteBehindBatchSize:  getEnvInt("WRITE_BEHIND_BATCH_SIZE", 500),
+		WriteBehindMaxPending: getEnvInt("WRITE_BEHIND_MAX_PENDING", 100000),
+	}
+	return cfg, cfg.validate()
+}
+
+// validate проверяет периоды фоновых задач: time.NewTicker паникует на
+// неположительном периоде. UDP-прием требует явного согласия, потому что
+// не проверяет ключи.
+func (c *Config) validate() error {
+	if c.InfluxUDPAddr != "" && !c.InfluxUDPTrusted {
+		return fmt.Errorf("INFLUX_UDP_ADDR accepts unauthenticated writes into the default tenant, set INFLUX_UDP_TRUSTED=true to enable it on a trusted network")
+	}
+
+	intervals := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"WRITE_BEHIND_INTERVAL", c.WriteBehindInterval},
+		{"WS_PING_INTERVAL", c.WSPingInterval},
+		{"STREAM_CLAIM_IDLE", c.StreamClaimIdle},
+		{"HISTORY_FLUSH_INTERVAL", c.HistoryFlush},
+	}
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", interval.name, interval.value)
+		}
+	}
+	return nil
+}
+
+// getEnv получает переменную окружения с дефолтным значением
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvMap разбирает переменную вида "key=value,key2=value2"
+func getEnvMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && k != "" {
+			result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return result
+}
+
+// getEnvList разбирает переменную вида "a,b,c"
+func getEnvList(key string, defaultValue []string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	if len(result) == 0 {
+		return defaultValue
+	}
+	return result
+}
+
+// defaultClientID постоянный идентификатор узла для сессии MQTT и группы потока
+func defaultClientID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "go-service"
+	}
+	return "go-service-" + hostname
+}
+
+// getEnvInt получает целочисленную переменную окружения
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvBool получает логическую переменную окружения
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvFloat получает вещественную переменную окружения
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvDuration получает длительность в формате time.ParseDuration
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
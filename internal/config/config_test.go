@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestLoadRejectsNonPositiveIntervals(t *testing.T) {
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load with defaults: %v", err)
+	}
+
+	for _, name := range []string{"WRITE_BEHIND_INTERVAL", "WS_PING_INTERVAL", "STREAM_CLAIM_IDLE", "HISTORY_FLUSH_INTERVAL"} {
+		for _, value := range []string{"0s", "-1s"} {
+			t.Run(name+"="+value, func(t *testing.T) {
+				t.Setenv(name, value)
+				if _, err := Load(); err == nil {
+					t.Errorf("Load accepted %s=%s", name, value)
+				}
+			})
+		}
+	}
+}
+
+func TestLoadRequiresTrustedUDP(t *testing.T) {
+	t.Setenv("INFLUX_UDP_ADDR", ":8089")
+	if _, err := Load(); err == nil {
+		t.Fatal("Load accepted INFLUX_UDP_ADDR without INFLUX_UDP_TRUSTED")
+	}
+
+	t.Setenv("INFLUX_UDP_TRUSTED", "true")
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load with INFLUX_UDP_TRUSTED: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+
+	analyticsv1 "go-service/api/analytics/v1"
+	"go-service/internal/auth"
+	"go-service/pkg/metrics"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// endpoint имя вызова для метрик запросов: grpc_<метод>
+func endpoint(fullMethod string) string {
+	return "grpc_" + path.Base(fullMethod)
+}
+
+// unaryMetrics учитывает унарные вызовы в метриках запросов
+func unaryMetrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	name := endpoint(info.FullMethod)
+	metrics.RecordRequest(name)
+	start := time.Now()
+	defer func() { metrics.RecordRequestDuration(name, time.Since(start)) }()
+
+	return handler(ctx, req)
+}
+
+// streamMetrics учитывает потоковые вызовы, длительность считается до закрытия потока
+func streamMetrics(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	name := endpoint(info.FullMethod)
+	metrics.RecordRequest(name)
+	start := time.Now()
+	defer func() { metrics.RecordRequestDuration(name, time.Since(start)) }()
+
+	return handler(srv, ss)
+}
+
+// methodPermissions права вызовов gRPC. Вызов без объявленного права
+// отклоняется, как маршрут HTTP без права.
+var methodPermissions = map[string]auth.Permission{
+	analyticsv1.AnalyticsService_IngestMetrics_FullMethodName:  auth.PermissionWrite,
+	analyticsv1.AnalyticsService_GetAnalytics_FullMethodName:   auth.PermissionRead,
+	analyticsv1.AnalyticsService_WatchAnomalies_FullMethodName: auth.PermissionRead,
+}
+
+// authenticate проверяет ключ API или токен JWT из метаданных authorization
+// или x-api-key и право на вызов. Возвращает контекст с ключом и его
+// арендатором.
+func authenticate(ctx context.Context, authenticator *auth.Authenticator, fullMethod string) (context.Context, error) {
+	perm, declared := methodPermissions[fullMethod]
+	if !declared {
+		metrics.RecordAuthFailure("forbidden")
+		return nil, status.Error(codes.PermissionDenied, "method has no declared permission")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	token := auth.TokenFromHeaders(firstValue(md, "authorization"), firstValue(md, "x-api-key"))
+	if token == "" {
+		metrics.RecordAuthFailure("missing")
+		return nil, status.Error(codes.Unauthenticated, "missing API key")
+	}
+	key, err := authenticator.Identify(ctx, token)
+	if errors.Is(err, auth.ErrNotFound) || errors.Is(err, auth.ErrInvalidToken) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to authenticate")
+	}
+	if !key.Can(perm) {
+		metrics.RecordAuthFailure("forbidden")
+		return nil, status.Error(codes.PermissionDenied, auth.ForbiddenMessage(perm, ""))
+	}
+	return auth.WithKey(ctx, key), nil
+}
+
+// firstValue первое значение ключа метаданных
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// unaryAuth проверяет ключ унарного вызова
+func unaryAuth(authenticator *auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, authenticator, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// streamAuth проверяет ключ потокового вызова
+func streamAuth(authenticator *auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), authenticator, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authStream поток с контекстом, содержащим ключ вызова
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
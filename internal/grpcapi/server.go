@@ -0,0 +1,264 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	analyticsv1 "go-service/api/analytics/v1"
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/idempotency"
+	"go-service/internal/models"
+	"go-service/internal/ratelimit"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// watchBuffer буфер аномалий одного WatchAnomalies
+const watchBuffer = 100
+
+// Server gRPC API поверх AnalyticsService
+type Server struct {
+	analyticsv1.UnimplementedAnalyticsServiceServer
+
+	service *analytics.AnalyticsService
+	limiter *ratelimit.Limiter
+	logger  *zap.SugaredLogger
+	grpc    *grpc.Server
+	done    chan struct{} // Закрывается при остановке, завершая WatchAnomalies
+}
+
+// NewServer создает gRPC сервер с учетом запросов в метриках Prometheus.
+// С authenticator вызовы требуют ключ API или токен JWT в метаданных
+// authorization или x-api-key, как запросы HTTP; nil отключает проверку.
+// Прием метрик расходует скорость ключа API и устройств из limiter.
+func NewServer(service *analytics.AnalyticsService, authenticator *auth.Authenticator, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) *Server {
+	s := &Server{
+		service: service,
+		limiter: limiter,
+		logger:  logger,
+		done:    make(chan struct{}),
+	}
+	unary := []grpc.UnaryServerInterceptor{unaryMetrics}
+	stream := []grpc.StreamServerInterceptor{streamMetrics}
+	if authenticator != nil {
+		unary = append(unary, unaryAuth(authenticator))
+		stream = append(stream, streamAuth(authenticator))
+	}
+	s.grpc = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	)
+	analyticsv1.RegisterAnalyticsServiceServer(s.grpc, s)
+	return s
+}
+
+// Serve принимает соединения до остановки сервера
+func (s *Server) Serve(lis net.Listener) error {
+	err := s.grpc.Serve(lis)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown завершает подписки на аномалии и ждет окончания остальных
+// вызовов до отмены ctx, после чего закрывает соединения принудительно
+func (s *Server) Shutdown(ctx context.Context) {
+	close(s.done)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.grpc.Stop()
+	}
+}
+
+// IngestMetrics обрабатывает поток метрик. Ошибка обработки прерывает
+// поток: клиент может отправить его заново, обработанные метрики с
+// message_id будут подавлены как повторы. Превышение скорости ключа API или
+// устройства прерывает поток с ResourceExhausted.
+func (s *Server) IngestMetrics(stream analyticsv1.AnalyticsService_IngestMetricsServer) error {
+	var summary analyticsv1.IngestSummary
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&summary)
+		}
+		if err != nil {
+			return err
+		}
+
+		metric := metricFromProto(msg)
+		if metric.DeviceID == "" || metric.RPS < 0 {
+			summary.Invalid++
+			metrics.RecordIngestedMetrics("grpc", "invalid", 1)
+			continue
+		}
+		if !auth.Allowed(stream.Context(), metric.DeviceID, auth.PermissionWrite) {
+			metrics.RecordAuthFailure("forbidden")
+			metrics.RecordIngestedMetrics("grpc", "forbidden", 1)
+			return status.Error(codes.PermissionDenied, auth.ForbiddenMessage(auth.PermissionWrite, metric.DeviceID))
+		}
+		err = s.limiter.Key(stream.Context(), "grpc", 1)
+		if err == nil {
+			err = s.limiter.Device(stream.Context(), "grpc", metric.DeviceID, 1)
+		}
+		if err != nil {
+			metrics.RecordIngestedMetrics("grpc", "rate_limited", 1)
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+
+		result, err := s.service.ProcessMetric(stream.Context(), metric)
+		var lateErr *analytics.LateMetricError
+		var invalidErr *analytics.InvalidMetricError
+		var quotaErr *analytics.QuotaExceededError
+		switch {
+		case errors.As(err, &invalidErr):
+			summary.Invalid++
+			metrics.RecordIngestedMetrics("grpc", "invalid", 1)
+		case errors.As(err, &lateErr):
+			summary.Late++
+			metrics.RecordIngestedMetrics("grpc", "late", 1)
+		case errors.As(err, &quotaErr):
+			metrics.RecordIngestedMetrics("grpc", "quota_exceeded", 1)
+			return status.Error(codes.ResourceExhausted, quotaErr.Error())
+		case errors.Is(err, idempotency.ErrInProgress):
+			return status.Error(codes.Aborted, err.Error())
+		case err != nil:
+			s.logger.Errorf("Failed to process gRPC metric for device %s: %v", metric.DeviceID, err)
+			metrics.RecordIngestedMetrics("grpc", "failed", 1)
+			return status.Error(codes.Internal, "failed to process metric")
+		case result.Duplicate:
+			summary.Duplicates++
+			metrics.RecordIngestedMetrics("grpc", "duplicate", 1)
+		default:
+			summary.Accepted++
+			metrics.RecordIngestedMetrics("grpc", "accepted", 1)
+			metrics.RecordMetricProcessed()
+		}
+	}
+}
+
+// GetAnalytics возвращает последний результат аналитики устройства
+func (s *Server) GetAnalytics(ctx context.Context, req *analyticsv1.GetAnalyticsRequest) (*analyticsv1.AnalyticsResult, error) {
+	if req.GetDeviceId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "device_id is required")
+	}
+	if !auth.Allowed(ctx, req.GetDeviceId(), auth.PermissionRead) {
+		metrics.RecordAuthFailure("forbidden")
+		return nil, status.Error(codes.PermissionDenied, auth.ForbiddenMessage(auth.PermissionRead, req.GetDeviceId()))
+	}
+
+	result, err := s.service.GetAnalytics(ctx, req.GetDeviceId())
+	if err != nil {
+		s.logger.Errorf("Failed to get analytics for device %s: %v", req.GetDeviceId(), err)
+		return nil, status.Error(codes.Internal, "failed to get analytics")
+	}
+	return resultToProto(result), nil
+}
+
+// WatchAnomalies передает аномалии выбранных устройств до отмены вызова.
+// Без списка устройств передаются аномалии всех устройств области ключа.
+func (s *Server) WatchAnomalies(req *analyticsv1.WatchAnomaliesRequest, stream analyticsv1.AnalyticsService_WatchAnomaliesServer) error {
+	for _, id := range req.GetDeviceIds() {
+		if !auth.Allowed(stream.Context(), id, auth.PermissionRead) {
+			metrics.RecordAuthFailure("forbidden")
+			return status.Error(codes.PermissionDenied, auth.ForbiddenMessage(auth.PermissionRead, id))
+		}
+	}
+
+	anomalies, unsubscribe := s.service.SubscribeAnomalies(stream.Context(), watchBuffer, req.GetDeviceIds()...)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-s.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case anomaly := <-anomalies:
+			if !auth.Allowed(stream.Context(), anomaly.DeviceID, auth.PermissionRead) {
+				continue
+			}
+			if err := stream.Send(resultToProto(&anomaly)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// metricFromProto переводит метрику из protobuf
+func metricFromProto(m *analyticsv1.Metric) models.Metric {
+	metric := models.Metric{
+		DeviceID:  m.GetDeviceId(),
+		CPU:       m.GetCpu(),
+		Memory:    m.GetMemory(),
+		RPS:       m.GetRps(),
+		Network:   m.GetNetwork(),
+		Values:    m.GetValues(),
+		MessageID: m.GetMessageId(),
+	}
+	if m.GetTimestamp() != nil {
+		metric.Timestamp = m.GetTimestamp().AsTime()
+	}
+	return metric
+}
+
+// resultToProto переводит результат аналитики в protobuf
+func resultToProto(r *models.AnalyticsResult) *analyticsv1.AnalyticsResult {
+	return &analyticsv1.AnalyticsResult{
+		Timestamp:      timestampToProto(r.Timestamp),
+		ProcessedAt:    timestampToProto(r.ProcessedAt),
+		DeviceId:       r.DeviceID,
+		RollingAverage: r.RollingAverage,
+		StdDev:         r.StdDev,
+		ZScore:         r.ZScore,
+		IsAnomaly:      r.IsAnomaly,
+		CurrentValue:   r.CurrentValue,
+		Duplicate:      r.Duplicate,
+		Fields:         fieldsToProto(r.Fields),
+	}
+}
+
+// fieldsToProto переводит статистику полей в protobuf
+func fieldsToProto(fields map[string]models.FieldResult) map[string]*analyticsv1.FieldResult {
+	if len(fields) == 0 {
+		return nil
+	}
+	result := make(map[string]*analyticsv1.FieldResult, len(fields))
+	for name, f := range fields {
+		result[name] = &analyticsv1.FieldResult{
+			Value:          f.Value,
+			Unit:           f.Unit,
+			RollingAverage: f.RollingAverage,
+			StdDev:         f.StdDev,
+			ZScore:         f.ZScore,
+			IsAnomaly:      f.IsAnomaly,
+			Samples:        int64(f.Samples),
+		}
+	}
+	return result
+}
+
+// timestampToProto оставляет нулевое время незаполненным
+func timestampToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
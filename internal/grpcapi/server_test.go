@@ -0,0 +1,289 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	analyticsv1 "go-service/api/analytics/v1"
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/idempotency"
+	"go-service/internal/storage"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Ключи проверочных арендаторов
+const (
+	acmeToken   = "acme-token"
+	globexToken = "globex-token"
+	viewerToken = "viewer-token"
+)
+
+// newTestClient запускает сервер с проверкой ключей поверх bufconn
+func newTestClient(t *testing.T) analyticsv1.AnalyticsServiceClient {
+	t.Helper()
+	metrics.InitMetrics()
+	logger := zap.NewNop().Sugar()
+
+	keys := auth.NewMemory()
+	for token, key := range map[string]auth.Key{
+		acmeToken:   {ID: "acme", Tenant: "acme", Devices: []string{"*"}, Roles: []auth.Role{auth.RoleOperator}},
+		globexToken: {ID: "globex", Tenant: "globex", Devices: []string{"*"}, Roles: []auth.Role{auth.RoleOperator}},
+		viewerToken: {ID: "viewer", Tenant: "acme", Devices: []string{"sensor-1"}, Roles: []auth.Role{auth.RoleViewer}},
+	} {
+		if err := keys.Create(context.Background(), auth.HashToken(token), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	authenticator := auth.NewAuthenticator(keys, auth.NewRoutes(), "", logger)
+	service := analytics.NewAnalyticsService(storage.NewMemory(), 10, 2.0)
+	service.SetIdempotency(idempotency.NewMemory(time.Minute))
+	server := NewServer(service, authenticator, nil, logger)
+
+	lis := bufconn.Listen(1 << 20)
+	go server.Serve(lis)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return analyticsv1.NewAnalyticsServiceClient(conn)
+}
+
+// withToken добавляет ключ API в метаданные вызова
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// ingest отправляет метрики одним потоком и возвращает итог
+func ingest(ctx context.Context, client analyticsv1.AnalyticsServiceClient, batch ...*analyticsv1.Metric) (*analyticsv1.IngestSummary, error) {
+	stream, err := client.IngestMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range batch {
+		if err := stream.Send(m); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// spike метрики устройства: ровный фон и всплеск rps в конце
+func spike(device string) []*analyticsv1.Metric {
+	start := time.Now().Add(-time.Second)
+	var batch []*analyticsv1.Metric
+	for i := 0; i < 10; i++ {
+		rps := 10 + float64(i%2)
+		if i == 9 {
+			rps = 1000
+		}
+		batch = append(batch, &analyticsv1.Metric{
+			DeviceId:  device,
+			Timestamp: timestamppb.New(start.Add(time.Duration(i) * time.Millisecond)),
+			Rps:       rps,
+		})
+	}
+	return batch
+}
+
+func TestAuth(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+	metric := &analyticsv1.Metric{DeviceId: "sensor-1", Rps: 10}
+
+	tests := []struct {
+		name  string
+		call  func() error
+		code  codes.Code
+		match string
+	}{
+		{
+			name: "missing key",
+			call: func() error {
+				_, err := client.GetAnalytics(ctx, &analyticsv1.GetAnalyticsRequest{DeviceId: "sensor-1"})
+				return err
+			},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "unknown key",
+			call: func() error {
+				_, err := client.GetAnalytics(withToken(ctx, "wrong"), &analyticsv1.GetAnalyticsRequest{DeviceId: "sensor-1"})
+				return err
+			},
+			code: codes.Unauthenticated,
+		},
+		{
+			name:  "missing permission",
+			call:  func() error { _, err := ingest(withToken(ctx, viewerToken), client, metric); return err },
+			code:  codes.PermissionDenied,
+			match: string(auth.PermissionWrite),
+		},
+		{
+			name: "device out of scope",
+			call: func() error {
+				_, err := client.GetAnalytics(withToken(ctx, viewerToken), &analyticsv1.GetAnalyticsRequest{DeviceId: "sensor-2"})
+				return err
+			},
+			code:  codes.PermissionDenied,
+			match: "sensor-2",
+		},
+		{
+			name: "watch out of scope",
+			call: func() error {
+				stream, err := client.WatchAnomalies(withToken(ctx, viewerToken), &analyticsv1.WatchAnomaliesRequest{DeviceIds: []string{"sensor-2"}})
+				if err != nil {
+					return err
+				}
+				_, err = stream.Recv()
+				return err
+			},
+			code: codes.PermissionDenied,
+		},
+		{
+			name: "allowed",
+			call: func() error {
+				_, err := client.GetAnalytics(withToken(ctx, viewerToken), &analyticsv1.GetAnalyticsRequest{DeviceId: "sensor-1"})
+				return err
+			},
+			code: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if got := status.Code(err); got != tt.code {
+				t.Fatalf("code = %s, want %s: %v", got, tt.code, err)
+			}
+			if tt.match != "" && !strings.Contains(status.Convert(err).Message(), tt.match) {
+				t.Errorf("message %q does not mention %q", status.Convert(err).Message(), tt.match)
+			}
+		})
+	}
+}
+
+func TestIngestTenantScope(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	summary, err := ingest(withToken(ctx, acmeToken), client,
+		&analyticsv1.Metric{DeviceId: "sensor-1", Rps: 42, MessageId: "m1"},
+		&analyticsv1.Metric{DeviceId: "sensor-1", Rps: 42, MessageId: "m1"},
+		&analyticsv1.Metric{DeviceId: "", Rps: 1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.GetAccepted() != 1 || summary.GetDuplicates() != 1 || summary.GetInvalid() != 1 {
+		t.Errorf("summary = %v, want 1 accepted, 1 duplicate, 1 invalid", summary)
+	}
+
+	result, err := client.GetAnalytics(withToken(ctx, acmeToken), &analyticsv1.GetAnalyticsRequest{DeviceId: "sensor-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.GetCurrentValue() != 42 {
+		t.Errorf("acme sensor-1 current value = %v, want 42", result.GetCurrentValue())
+	}
+
+	// Одноименное устройство другого арендатора не видит метрик acme
+	result, err = client.GetAnalytics(withToken(ctx, globexToken), &analyticsv1.GetAnalyticsRequest{DeviceId: "sensor-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.GetCurrentValue() != 0 || result.GetTimestamp() != nil {
+		t.Errorf("globex sensor-1 = %v, want empty result", result)
+	}
+}
+
+// watch читает аномалии потока в канал до его закрытия
+func watch(t *testing.T, ctx context.Context, client analyticsv1.AnalyticsServiceClient, token string) <-chan *analyticsv1.AnalyticsResult {
+	t.Helper()
+	stream, err := client.WatchAnomalies(withToken(ctx, token), &analyticsv1.WatchAnomaliesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan *analyticsv1.AnalyticsResult, 100)
+	go func() {
+		defer close(received)
+		for {
+			anomaly, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			received <- anomaly
+		}
+	}()
+	return received
+}
+
+// deliver отправляет всплески новых устройств с префиксом prefix, пока
+// подписка не получит аномалию: подписка на сервере оформляется не сразу
+func deliver(t *testing.T, ctx context.Context, client analyticsv1.AnalyticsServiceClient, token, prefix string, received <-chan *analyticsv1.AnalyticsResult) *analyticsv1.AnalyticsResult {
+	t.Helper()
+	for attempt := 0; attempt < 50; attempt++ {
+		if _, err := ingest(withToken(ctx, token), client, spike(prefix+strconv.Itoa(attempt))...); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case anomaly := <-received:
+			return anomaly
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	t.Fatalf("no anomaly delivered for %s devices", prefix)
+	return nil
+}
+
+func TestWatchAnomaliesTenantScope(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	globex := watch(t, ctx, client, globexToken)
+	acme := watch(t, ctx, client, acmeToken)
+
+	if anomaly := deliver(t, ctx, client, globexToken, "pump-", globex); !strings.HasPrefix(anomaly.GetDeviceId(), "pump-") {
+		t.Fatalf("globex received anomaly for %s", anomaly.GetDeviceId())
+	}
+	if anomaly := deliver(t, ctx, client, acmeToken, "sensor-", acme); !strings.HasPrefix(anomaly.GetDeviceId(), "sensor-") {
+		t.Fatalf("acme received anomaly of another tenant for %s", anomaly.GetDeviceId())
+	}
+
+	// Подписка globex уже действует, аномалии acme до нее не доходят
+	for {
+		select {
+		case anomaly := <-globex:
+			if !strings.HasPrefix(anomaly.GetDeviceId(), "pump-") {
+				t.Fatalf("globex received anomaly of another tenant for %s", anomaly.GetDeviceId())
+			}
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-service/internal/audit"
+	"go-service/internal/auth"
+	"go-service/internal/concurrency"
+	"go-service/internal/tenant"
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Пределы выборки журнала в JSON. Выгрузка в CSV и NDJSON без limit
+// возвращает все подходящие записи.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 10000
+)
+
+// RegisterAuditHandlers регистрирует просмотр и выгрузку журнала действий
+func RegisterAuditHandlers(r *mux.Router, routes *auth.Routes, auditLog *audit.Log, logger *zap.SugaredLogger) {
+	routes.HandleFunc(r, "/admin/audit", auth.PermissionAudit, concurrency.PriorityLow, AuditHandler(auditLog, logger)).Methods("GET")
+}
+
+// AuditHandler обработчик журнала действий от новых записей к старым.
+// Фильтры: tenant, actor, action, target, from и to в RFC 3339, limit.
+// format=csv или ndjson выгружает записи файлом. Ключ арендатора видит
+// только записи своего арендатора.
+func AuditHandler(auditLog *audit.Log, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("audit")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("audit", time.Since(start)) }()
+
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "csv" && format != "ndjson" {
+			http.Error(w, "Invalid format, expected json, csv or ndjson", http.StatusBadRequest)
+			return
+		}
+
+		filter := audit.Filter{
+			Tenant: query.Get("tenant"),
+			Actor:  query.Get("actor"),
+			Action: query.Get("action"),
+			Target: query.Get("target"),
+		}
+		if caller, ok := auth.FromContext(r.Context()); ok && !caller.ManagesTenants() {
+			if filter.Tenant != "" && filter.Tenant != caller.TenantID() {
+				http.Error(w, "Cannot read audit log of another tenant", http.StatusForbidden)
+				return
+			}
+			filter.Tenant = caller.TenantID()
+		}
+		if filter.Tenant != "" && !tenant.Valid(filter.Tenant) {
+			http.Error(w, "Invalid tenant", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if filter.From, err = parseAuditTime(query.Get("from")); err != nil {
+			http.Error(w, "Invalid from, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		if filter.To, err = parseAuditTime(query.Get("to")); err != nil {
+			http.Error(w, "Invalid to, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+
+		if format == "json" {
+			filter.Limit = defaultAuditLimit
+		}
+		if value := query.Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 || limit > maxAuditLimit {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			filter.Limit = limit
+		}
+
+		entries, err := auditLog.Query(r.Context(), filter)
+		if err != nil {
+			logger.Errorf("Failed to query audit log: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		switch format {
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+			writeAuditCSV(w, entries)
+		case "ndjson":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+			enc := json.NewEncoder(w)
+			for _, entry := range entries {
+				enc.Encode(entry)
+			}
+		default:
+			if entries == nil {
+				entries = []audit.Entry{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entries)
+		}
+	}
+}
+
+// parseAuditTime разбирает границу выборки, пустая строка не ограничивает
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// writeAuditCSV пишет записи журнала в CSV, состояния — строками JSON
+func writeAuditCSV(w http.ResponseWriter, entries []audit.Entry) {
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "time", "tenant", "actor", "actor_name", "action", "target", "before", "after"})
+	for _, e := range entries {
+		out.Write([]string{
+			e.ID,
+			e.Time.Format(time.RFC3339Nano),
+			e.Tenant,
+			e.Actor,
+			e.ActorName,
+			e.Action,
+			e.Target,
+			string(e.Before),
+			string(e.After),
+		})
+	}
+	out.Flush()
+}
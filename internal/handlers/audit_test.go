@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"go-service/internal/audit"
+	"go-service/internal/auth"
+	"go-service/internal/tenant"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+func TestAuditExportTenantScope(t *testing.T) {
+	metrics.InitMetrics()
+	store, err := audit.OpenFile(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	auditLog := audit.NewLog(store, zap.NewNop().Sugar())
+	for _, tenantID := range []string{"acme", "globex", "acme"} {
+		ctx := tenant.WithTenant(context.Background(), tenantID)
+		auditLog.Record(ctx, audit.ActionWindowSet, "d1", nil, nil)
+	}
+
+	acmeAdmin := &auth.Key{ID: "k1", Tenant: "acme", Devices: []string{"*"}, Roles: []auth.Role{auth.RoleAdmin}}
+	bootstrap := &auth.Key{ID: "admin", Devices: []string{"*"}, Roles: []auth.Role{auth.RoleAdmin}}
+
+	tests := []struct {
+		name        string
+		key         *auth.Key
+		query       string
+		wantStatus  int
+		wantTenants []string
+	}{
+		{name: "own tenant by default", key: acmeAdmin, query: "", wantStatus: http.StatusOK, wantTenants: []string{"acme", "acme"}},
+		{name: "own tenant csv", key: acmeAdmin, query: "format=csv", wantStatus: http.StatusOK, wantTenants: []string{"acme", "acme"}},
+		{name: "own tenant ndjson", key: acmeAdmin, query: "format=ndjson&tenant=acme", wantStatus: http.StatusOK, wantTenants: []string{"acme", "acme"}},
+		{name: "another tenant denied", key: acmeAdmin, query: "tenant=globex", wantStatus: http.StatusForbidden},
+		{name: "another tenant csv denied", key: acmeAdmin, query: "format=csv&tenant=globex", wantStatus: http.StatusForbidden},
+		{name: "bootstrap admin reads all", key: bootstrap, query: "", wantStatus: http.StatusOK, wantTenants: []string{"acme", "globex", "acme"}},
+		{name: "bootstrap admin filters", key: bootstrap, query: "format=csv&tenant=globex", wantStatus: http.StatusOK, wantTenants: []string{"globex"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/audit?"+tt.query, nil)
+			req = req.WithContext(auth.WithKey(req.Context(), tt.key))
+			rec := httptest.NewRecorder()
+			AuditHandler(auditLog, zap.NewNop().Sugar())(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			got := responseTenants(t, rec)
+			if len(got) != len(tt.wantTenants) {
+				t.Fatalf("tenants = %v, want %v", got, tt.wantTenants)
+			}
+			for i := range got {
+				if got[i] != tt.wantTenants[i] {
+					t.Fatalf("tenants = %v, want %v", got, tt.wantTenants)
+				}
+			}
+		})
+	}
+}
+
+// responseTenants арендаторы записей ответа журнала в любом формате
+func responseTenants(t *testing.T, rec *httptest.ResponseRecorder) []string {
+	t.Helper()
+	var tenants []string
+	switch rec.Header().Get("Content-Type") {
+	case "text/csv":
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows[1:] {
+			tenants = append(tenants, row[2])
+		}
+	case "application/x-ndjson":
+		dec := json.NewDecoder(rec.Body)
+		for dec.More() {
+			var entry audit.Entry
+			if err := dec.Decode(&entry); err != nil {
+				t.Fatal(err)
+			}
+			tenants = append(tenants, entry.Tenant)
+		}
+	default:
+		var entries []audit.Entry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			tenants = append(tenants, entry.Tenant)
+		}
+	}
+	return tenants
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go-service/internal/analytics"
+	"go-service/internal/audit"
+	"go-service/internal/backfill"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// BackfillHandler обработчик импорта исторических метрик из CSV или NDJSON.
+// Ход импорта возвращается потоком NDJSON-строк backfill.Progress, последняя
+// строка содержит done=true. Итог импорта записывается в журнал действий.
+func BackfillHandler(analyticsService *analytics.AnalyticsService, auditLog *audit.Log, logger *zap.SugaredLogger) http.HandlerFunc {
+	importer := backfill.NewImporter(analyticsService, backfill.DefaultReportEvery)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("backfill")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("backfill", time.Since(start)) }()
+
+		format, err := backfill.DetectFormat(r.URL.Query().Get("format"), r.URL.Query().Get("filename"), r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Большой файл загружается дольше таймаутов сервера, а прогресс
+		// пишется до окончания чтения тела
+		rc := http.NewResponseController(w)
+		if err := rc.EnableFullDuplex(); err != nil {
+			logger.Warnf("Backfill without full duplex: %v", err)
+		}
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+
+		progress, err := importer.Import(r.Context(), r.Body, format, func(p backfill.Progress) {
+			enc.Encode(p)
+			rc.Flush()
+		})
+		target := "backfill"
+		if fileName := r.URL.Query().Get("filename"); fileName != "" {
+			target = "file:" + fileName
+		}
+		auditLog.Record(r.Context(), audit.ActionBackfill, target, nil, progress)
+		if err != nil {
+			logger.Errorf("Backfill aborted after %d metrics: %v", progress.Processed, err)
+			return
+		}
+		logger.Infof("Backfill imported %d metrics, %d failed, %d anomalies",
+			progress.Processed, progress.Failed, progress.Anomalies)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/idempotency"
+	"go-service/internal/models"
+	"go-service/internal/ratelimit"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// maxBatchSize ограничивает количество метрик в одном пакете
+const maxBatchSize = 1000
+
+// BatchItemResult итог обработки одной метрики пакета
+type BatchItemResult struct {
+	Status string                  `json:"status"` // ok, duplicate, invalid, forbidden, rate_limited, quota_exceeded, dropped, diverted_to_history, in_progress или error
+	Result *models.AnalyticsResult `json:"result,omitempty"`
+	Error  string                  `json:"error,omitempty"`
+}
+
+// BatchResponse ответ на пакет метрик в порядке запроса
+type BatchResponse struct {
+	Results []BatchItemResult `json:"results"`
+}
+
+// BatchMetricHandler обработчик приема пакета метрик. Метрики обрабатываются
+// независимо, ошибка одной не отклоняет пакет. Idempotency-Key пакета
+// превращается в ключи метрик без message_id вида <key>:<номер>, поэтому
+// повтор пакета целиком возвращает исходные результаты.
+//
+// Пакет расходует скорость ключа API на все свои метрики и при превышении
+// отклоняется целиком с 429. Метрики устройства сверх его скорости
+// получают статус rate_limited, а ответ — Retry-After.
+func BatchMetricHandler(analyticsService *analytics.AnalyticsService, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("metric_batch")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("metric_batch", time.Since(start)) }()
+
+		var batch []models.Metric
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			logger.Errorf("Failed to decode metric batch: %v", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if len(batch) > maxBatchSize {
+			http.Error(w, fmt.Sprintf("Batch exceeds %d metrics", maxBatchSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := limiter.Key(r.Context(), "metric_batch", len(batch)); err != nil {
+			writeRateLimited(w, err)
+			return
+		}
+
+		batchKey := r.Header.Get(idempotencyKeyHeader)
+		response := BatchResponse{Results: make([]BatchItemResult, len(batch))}
+
+		// Скорость устройства списывается один раз на все его метрики пакета
+		accepted := make(map[string]int)
+		for i, metric := range batch {
+			if metric.DeviceID == "" || metric.RPS < 0 {
+				response.Results[i] = BatchItemResult{Status: "invalid", Error: "Invalid metric data"}
+				continue
+			}
+			if !auth.Allowed(r.Context(), metric.DeviceID, auth.PermissionWrite) {
+				metrics.RecordAuthFailure("forbidden")
+				response.Results[i] = BatchItemResult{Status: "forbidden", Error: auth.ForbiddenMessage(auth.PermissionWrite, metric.DeviceID)}
+				continue
+			}
+			accepted[metric.DeviceID]++
+		}
+		throttled := limiter.Devices(r.Context(), "metric_batch", accepted)
+		var retryAfter time.Duration
+		for _, exceeded := range throttled {
+			retryAfter = max(retryAfter, exceeded.RetryAfter)
+		}
+
+		for i, metric := range batch {
+			if response.Results[i].Status != "" {
+				continue
+			}
+			if exceeded, ok := throttled[metric.DeviceID]; ok {
+				response.Results[i] = BatchItemResult{Status: "rate_limited", Error: exceeded.Error()}
+				continue
+			}
+			if metric.MessageID == "" && batchKey != "" {
+				metric.MessageID = fmt.Sprintf("%s:%d", batchKey, i)
+			}
+
+			result, err := analyticsService.ProcessMetric(r.Context(), metric)
+			var lateErr *analytics.LateMetricError
+			var invalidErr *analytics.InvalidMetricError
+			var quotaErr *analytics.QuotaExceededError
+			switch {
+			case errors.As(err, &invalidErr):
+				response.Results[i] = BatchItemResult{Status: "invalid", Error: invalidErr.Error()}
+			case errors.As(err, &quotaErr):
+				response.Results[i] = BatchItemResult{Status: "quota_exceeded", Error: quotaErr.Error()}
+			case errors.As(err, &lateErr) && lateErr.Diverted:
+				response.Results[i] = BatchItemResult{Status: "diverted_to_history", Error: lateErr.Error()}
+			case errors.As(err, &lateErr):
+				response.Results[i] = BatchItemResult{Status: "dropped", Error: lateErr.Error()}
+			case errors.Is(err, idempotency.ErrInProgress):
+				response.Results[i] = BatchItemResult{Status: "in_progress", Error: err.Error()}
+			case err != nil:
+				logger.Errorf("Failed to process metric: %v", err)
+				response.Results[i] = BatchItemResult{Status: "error", Error: "Internal server error"}
+			case result.Duplicate:
+				response.Results[i] = BatchItemResult{Status: "duplicate", Result: result}
+			default:
+				metrics.RecordMetricProcessed()
+				response.Results[i] = BatchItemResult{Status: "ok", Result: result}
+			}
+		}
+
+		if len(throttled) > 0 {
+			w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -1,35 +1,57 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"go-service/internal/analytics"
+	"go-service/internal/audit"
+	"go-service/internal/auth"
 	"go-service/internal/cache"
+	"go-service/internal/concurrency"
+	"go-service/internal/idempotency"
 	"go-service/internal/models"
+	"go-service/internal/ratelimit"
 	"go-service/pkg/metrics"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
 
-// RegisterHandlers регистрирует все обработчики
-func RegisterHandlers(r *mux.Router, analyticsService *analytics.AnalyticsService, redisClient *cache.RedisClient, logger *zap.SugaredLogger) {
-	r.HandleFunc("/health", HealthHandler(logger)).Methods("GET")
-	r.HandleFunc("/metrics", MetricsHandler(analyticsService, logger)).Methods("GET")
-	r.HandleFunc("/analyze/{deviceID}", AnalyzeHandler(analyticsService, logger)).Methods("GET")
-	r.HandleFunc("/metric", MetricHandler(analyticsService, logger)).Methods("POST")
+// idempotencyKeyHeader заголовок с ключом для метрик без message_id
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// RegisterHandlers регистрирует все обработчики с правами доступа в routes
+func RegisterHandlers(r *mux.Router, routes *auth.Routes, analyticsService *analytics.AnalyticsService, redisClient *cache.RedisClient, auditLog *audit.Log, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) {
+	routes.HandleFunc(r, "/health", auth.PermissionPublic, concurrency.PriorityCritical, HealthHandler(logger)).Methods("GET")
+	routes.HandleFunc(r, "/live", auth.PermissionPublic, concurrency.PriorityCritical, HealthHandler(logger)).Methods("GET")
+	routes.HandleFunc(r, "/ready", auth.PermissionPublic, concurrency.PriorityCritical, ReadyHandler(redisClient, logger)).Methods("GET")
+	routes.HandleFunc(r, "/metrics", auth.PermissionRead, concurrency.PriorityLow, MetricsHandler(analyticsService, logger)).Methods("GET")
+	routes.HandleFunc(r, "/analyze/{deviceID}", auth.PermissionRead, concurrency.PriorityNormal, AnalyzeHandler(analyticsService, logger)).Methods("GET")
+	routes.HandleFunc(r, "/metric", auth.PermissionWrite, concurrency.PriorityIngest, MetricHandler(analyticsService, limiter, logger)).Methods("POST")
+	routes.HandleFunc(r, "/metric/batch", auth.PermissionWrite, concurrency.PriorityIngest, BatchMetricHandler(analyticsService, limiter, logger)).Methods("POST")
+	routes.HandleFunc(r, "/anomalies/{deviceID}", auth.PermissionRead, concurrency.PriorityNormal, AnomaliesHandler(analyticsService, logger)).Methods("GET")
+	routes.HandleFunc(r, "/devices/{deviceID}/window", auth.PermissionRead, concurrency.PriorityNormal, WindowHandler(analyticsService, auditLog, logger)).Methods("GET")
+	routes.HandleFunc(r, "/devices/{deviceID}/window", auth.PermissionConfigure, concurrency.PriorityNormal, WindowHandler(analyticsService, auditLog, logger)).Methods("PUT", "DELETE")
+	routes.HandleFunc(r, "/fields", auth.PermissionRead, concurrency.PriorityNormal, FieldsHandler(analyticsService, logger)).Methods("GET")
+	routes.HandleFunc(r, "/tenant", auth.PermissionRead, concurrency.PriorityNormal, TenantHandler(analyticsService, logger)).Methods("GET")
+	routes.HandleFunc(r, "/admin/backfill", auth.PermissionBackfill, concurrency.PriorityLow, BackfillHandler(analyticsService, auditLog, logger)).Methods("POST")
 
 	// Prometheus metrics
-	r.Handle("/prometheus", metrics.GetHTTPHandler()).Methods("GET")
+	routes.Handle(r, "/prometheus", auth.PermissionPublic, concurrency.PriorityCritical, metrics.GetHTTPHandler()).Methods("GET")
 }
 
 // HealthHandler обработчик для проверки здоровья
 func HealthHandler(logger *zap.SugaredLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		metrics.RecordRequest("health")
-		defer metrics.RecordRequestDuration("health", time.Since(time.Now()))
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("health", time.Since(start)) }()
 
 		response := map[string]string{"status": "ok"}
 		w.Header().Set("Content-Type", "application/json")
@@ -37,14 +59,39 @@ func HealthHandler(logger *zap.SugaredLogger) http.HandlerFunc {
 	}
 }
 
+// ReadyHandler обработчик проверки готовности: 503, пока недоступен Redis.
+// redisClient равен nil, если Redis не нужен ни хранилищу, ни чтению потока,
+// и тогда готовность от Redis не зависит.
+func ReadyHandler(redisClient *cache.RedisClient, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("ready")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("ready", time.Since(start)) }()
+
+		if redisClient != nil {
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			defer cancel()
+			if err := redisClient.Ping(ctx); err != nil {
+				logger.Warnf("Not ready, Redis is unavailable: %v", err)
+				http.Error(w, "Redis is unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		response := map[string]string{"status": "ready"}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
 // MetricsHandler обработчик для получения метрик аналитики
 func MetricsHandler(analyticsService *analytics.AnalyticsService, logger *zap.SugaredLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		metrics.RecordRequest("metrics")
 		start := time.Now()
-		defer metrics.RecordRequestDuration("metrics", time.Since(start))
+		defer func() { metrics.RecordRequestDuration("metrics", time.Since(start)) }()
 
-		summary := analyticsService.GetSummary()
+		summary := analyticsService.GetSummary(r.Context())
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(summary)
@@ -56,7 +103,7 @@ func AnalyzeHandler(analyticsService *analytics.AnalyticsService, logger *zap.Su
 	return func(w http.ResponseWriter, r *http.Request) {
 		metrics.RecordRequest("analyze")
 		start := time.Now()
-		defer metrics.RecordRequestDuration("analyze", time.Since(start))
+		defer func() { metrics.RecordRequestDuration("analyze", time.Since(start)) }()
 
 		vars := mux.Vars(r)
 		deviceID := vars["deviceID"]
@@ -73,12 +120,156 @@ func AnalyzeHandler(analyticsService *analytics.AnalyticsService, logger *zap.Su
 	}
 }
 
-// MetricHandler обработчик для приема метрик
-func MetricHandler(analyticsService *analytics.AnalyticsService, logger *zap.SugaredLogger) http.HandlerFunc {
+// AnomaliesHandler обработчик для получения журнала аномалий устройства
+func AnomaliesHandler(analyticsService *analytics.AnalyticsService, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("anomalies")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("anomalies", time.Since(start)) }()
+
+		vars := mux.Vars(r)
+		deviceID := vars["deviceID"]
+
+		limit := 100
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		anomalies, err := analyticsService.GetAnomalies(r.Context(), deviceID, limit)
+		if err != nil {
+			logger.Errorf("Failed to get anomalies for device %s: %v", deviceID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if anomalies == nil {
+			anomalies = []models.AnalyticsResult{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(anomalies)
+	}
+}
+
+// WindowResponse действующее окно устройства
+type WindowResponse struct {
+	DeviceID string `json:"device_id"`
+	Size     int    `json:"size"`
+	Duration string `json:"duration"`
+	Custom   bool   `json:"custom"`
+}
+
+// WindowRequest собственное окно устройства
+type WindowRequest struct {
+	Size     int    `json:"size"`
+	Duration string `json:"duration"`
+}
+
+// WindowHandler обработчик чтения и изменения окна устройства. Изменения
+// записываются в журнал действий.
+func WindowHandler(analyticsService *analytics.AnalyticsService, auditLog *audit.Log, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("window")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("window", time.Since(start)) }()
+
+		deviceID := mux.Vars(r)["deviceID"]
+		before := deviceWindow(r.Context(), analyticsService, deviceID)
+
+		switch r.Method {
+		case http.MethodPut:
+			var req WindowRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+			cfg := analytics.WindowConfig{Size: req.Size}
+			if req.Duration != "" {
+				duration, err := time.ParseDuration(req.Duration)
+				if err != nil {
+					http.Error(w, "Invalid duration", http.StatusBadRequest)
+					return
+				}
+				cfg.Duration = duration
+			}
+			if err := cfg.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			analyticsService.SetDeviceWindow(r.Context(), deviceID, cfg)
+			logger.Infof("Window for device %s set to %s", deviceID, cfg)
+		case http.MethodDelete:
+			analyticsService.ResetDeviceWindow(r.Context(), deviceID)
+			logger.Infof("Window for device %s reset to default", deviceID)
+		}
+
+		response := deviceWindow(r.Context(), analyticsService, deviceID)
+		switch r.Method {
+		case http.MethodPut:
+			auditLog.Record(r.Context(), audit.ActionWindowSet, "device:"+deviceID, before, response)
+		case http.MethodDelete:
+			auditLog.Record(r.Context(), audit.ActionWindowReset, "device:"+deviceID, before, response)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// deviceWindow возвращает действующее окно устройства
+func deviceWindow(ctx context.Context, analyticsService *analytics.AnalyticsService, deviceID string) WindowResponse {
+	cfg, custom := analyticsService.DeviceWindow(ctx, deviceID)
+	return WindowResponse{
+		DeviceID: deviceID,
+		Size:     cfg.Size,
+		Duration: cfg.Duration.String(),
+		Custom:   custom,
+	}
+}
+
+// FieldsHandler обработчик для получения единиц и диапазонов полей
+func FieldsHandler(analyticsService *analytics.AnalyticsService, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("fields")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("fields", time.Since(start)) }()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(analyticsService.FieldSpecs())
+	}
+}
+
+// TenantHandler обработчик для получения настроек и предела устройств
+// арендатора запроса
+func TenantHandler(analyticsService *analytics.AnalyticsService, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("tenant")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("tenant", time.Since(start)) }()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(analyticsService.Tenant(r.Context()))
+	}
+}
+
+// MetricHandler обработчик для приема метрик. Ключ API и устройство сверх
+// своей скорости получают 429 с Retry-After.
+func MetricHandler(analyticsService *analytics.AnalyticsService, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		metrics.RecordRequest("metric")
 		start := time.Now()
-		defer metrics.RecordRequestDuration("metric", time.Since(start))
+		defer func() { metrics.RecordRequestDuration("metric", time.Since(start)) }()
+
+		// Ключ проверяется до разбора тела, чтобы поток запросов не
+		// нагружал сервис
+		if err := limiter.Key(r.Context(), "metric", 1); err != nil {
+			writeRateLimited(w, err)
+			return
+		}
 
 		var metric models.Metric
 		if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
@@ -92,17 +283,79 @@ func MetricHandler(analyticsService *analytics.AnalyticsService, logger *zap.Sug
 			http.Error(w, "Invalid metric data", http.StatusBadRequest)
 			return
 		}
+		if !auth.Allowed(r.Context(), metric.DeviceID, auth.PermissionWrite) {
+			metrics.RecordAuthFailure("forbidden")
+			auth.WriteForbidden(w, auth.PermissionWrite, metric.DeviceID)
+			return
+		}
+		if err := limiter.Device(r.Context(), "metric", metric.DeviceID, 1); err != nil {
+			writeRateLimited(w, err)
+			return
+		}
+		if metric.MessageID == "" {
+			metric.MessageID = r.Header.Get(idempotencyKeyHeader)
+		}
 
 		result, err := analyticsService.ProcessMetric(r.Context(), metric)
+		var lateErr *analytics.LateMetricError
+		if errors.As(err, &lateErr) {
+			writeLateMetric(w, lateErr)
+			return
+		}
+		var invalidErr *analytics.InvalidMetricError
+		if errors.As(err, &invalidErr) {
+			http.Error(w, invalidErr.Error(), http.StatusBadRequest)
+			return
+		}
+		var quotaErr *analytics.QuotaExceededError
+		if errors.As(err, &quotaErr) {
+			http.Error(w, quotaErr.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, idempotency.ErrInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			logger.Errorf("Failed to process metric: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
 
-		metrics.RecordMetricProcessed()
+		if !result.Duplicate {
+			metrics.RecordMetricProcessed()
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(result)
 	}
 }
+
+// writeRateLimited отвечает 429 с Retry-After в целых секундах
+func writeRateLimited(w http.ResponseWriter, err error) {
+	var exceeded *ratelimit.ExceededError
+	if errors.As(err, &exceeded) {
+		w.Header().Set("Retry-After", retryAfterSeconds(exceeded.RetryAfter))
+	}
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
+
+// retryAfterSeconds значение Retry-After, не меньше секунды
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.FormatInt(max(1, int64(math.Ceil(d.Seconds()))), 10)
+}
+
+// writeLateMetric отвечает на метрику старше допустимого опоздания:
+// 202, если она сохранена в историю, иначе 422
+func writeLateMetric(w http.ResponseWriter, lateErr *analytics.LateMetricError) {
+	status := http.StatusUnprocessableEntity
+	response := map[string]string{"status": "dropped", "error": lateErr.Error()}
+	if lateErr.Diverted {
+		status = http.StatusAccepted
+		response["status"] = "diverted_to_history"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
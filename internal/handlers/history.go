@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-service/internal/auth"
+	"go-service/internal/concurrency"
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+	"go-service/internal/tsdb"
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// maxSeriesPoints ограничивает размер ответа
+const maxSeriesPoints = 10000
+
+// SeriesResponse ответ запроса истории устройства
+type SeriesResponse struct {
+	DeviceID   string       `json:"device_id"`
+	Field      string       `json:"field"`
+	From       time.Time    `json:"from"`
+	To         time.Time    `json:"to"`
+	Step       string       `json:"step,omitempty"`
+	Resolution string       `json:"resolution"`
+	Points     []tsdb.Point `json:"points"`
+}
+
+// RegisterHistoryHandlers регистрирует обработчики истории метрик
+func RegisterHistoryHandlers(r *mux.Router, routes *auth.Routes, history *tsdb.DB, logger *zap.SugaredLogger) {
+	routes.HandleFunc(r, "/devices/{deviceID}/series", auth.PermissionRead, concurrency.PriorityLow, SeriesHandler(history, logger)).Methods("GET")
+}
+
+// SeriesHandler обработчик запроса временного ряда поля устройства
+func SeriesHandler(history *tsdb.DB, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("series")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("series", time.Since(start)) }()
+
+		deviceID := mux.Vars(r)["deviceID"]
+		query := r.URL.Query()
+
+		to, err := parseTime(query.Get("to"), time.Now())
+		if err != nil {
+			http.Error(w, "Invalid 'to': "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, err := parseTime(query.Get("from"), to.Add(-time.Hour))
+		if err != nil {
+			http.Error(w, "Invalid 'from': "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !from.Before(to) {
+			http.Error(w, "'from' must be before 'to'", http.StatusBadRequest)
+			return
+		}
+
+		field := query.Get("field")
+		if field == "" {
+			field = "rps"
+		}
+		if !models.ValidFieldName(field) {
+			http.Error(w, fmt.Sprintf("Invalid field %q", field), http.StatusBadRequest)
+			return
+		}
+
+		var step time.Duration
+		if value := query.Get("step"); value != "" {
+			step, err = time.ParseDuration(value)
+			if err != nil || step <= 0 {
+				http.Error(w, "Invalid 'step'", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// История хранит ряды устройств под ключами tenant.Scope
+		series := tenant.Scope(tenant.FromContext(r.Context()), deviceID)
+		points, resolution, err := history.QuerySeries(r.Context(), series, field, from, to, step, maxSeriesPoints)
+		switch {
+		case errors.Is(err, tsdb.ErrInvalidRange):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, tsdb.ErrTooManyPoints):
+			http.Error(w, "Too many points, increase 'step' or narrow the range", http.StatusBadRequest)
+			return
+		case err != nil:
+			logger.Errorf("Failed to query history for device %s: %v", deviceID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		response := SeriesResponse{
+			DeviceID:   deviceID,
+			Field:      field,
+			From:       from,
+			To:         to,
+			Resolution: resolution,
+			Points:     points,
+		}
+		if step > 0 {
+			response.Step = step.String()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// parseTime разбирает время в формате RFC3339 или Unix-секундах
+func parseTime(value string, defaultValue time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
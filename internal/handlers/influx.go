@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/concurrency"
+	"go-service/internal/ingest"
+	"go-service/internal/ratelimit"
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// maxInfluxBodySize предел размера тела запроса /write после распаковки
+const maxInfluxBodySize = 32 << 20
+
+// RegisterInfluxHandlers регистрирует прием InfluxDB line protocol
+func RegisterInfluxHandlers(r *mux.Router, routes *auth.Routes, analyticsService *analytics.AnalyticsService, mapping ingest.InfluxMapping, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) {
+	routes.HandleFunc(r, "/write", auth.PermissionWrite, concurrency.PriorityIngest, InfluxWriteHandler(analyticsService, mapping, limiter, logger)).Methods("POST")
+}
+
+// InfluxWriteHandler обработчик записи в формате InfluxDB /write. Отвечает
+// 204 при успехе, 400 с описанием первой ошибки, если часть строк не
+// разобрана (остальные при этом записываются), 429 с Retry-After, если ключ
+// API или устройства превысили скорость, и 500, если запрос нужно повторить.
+func InfluxWriteHandler(analyticsService *analytics.AnalyticsService, mapping ingest.InfluxMapping, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("influx_write")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("influx_write", time.Since(start)) }()
+
+		precision, err := ingest.ParsePrecision(r.URL.Query().Get("precision"))
+		if err != nil {
+			writeInfluxError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeInfluxError(w, http.StatusBadRequest, "invalid gzip body")
+				return
+			}
+			defer zr.Close()
+			body = zr
+		}
+		data, err := io.ReadAll(io.LimitReader(body, maxInfluxBodySize+1))
+		if err != nil {
+			logger.Errorf("Failed to read line protocol request: %v", err)
+			writeInfluxError(w, http.StatusBadRequest, "failed to read body")
+			return
+		}
+		if len(data) > maxInfluxBodySize {
+			writeInfluxError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+
+		points, parseErrs := ingest.ParseLines(string(data), precision, time.Now())
+		metrics.RecordIngestedMetrics("influx", "invalid", len(parseErrs))
+
+		batch, stats := mapping.Metrics(points)
+		metrics.RecordIngestedMetrics("influx", "unmapped", stats.Unmapped)
+		metrics.RecordIngestedMetrics("influx", "incomplete", stats.Incomplete)
+		batch, limitErr := ingest.Admit(r.Context(), limiter, "influx", batch)
+
+		var failed int
+		for _, metric := range batch {
+			if err := ingest.Process(r.Context(), analyticsService, "influx", metric); err != nil {
+				logger.Errorf("Failed to process line protocol metric for device %s: %v", metric.DeviceID, err)
+				failed++
+			}
+		}
+
+		switch {
+		case failed > 0:
+			writeInfluxError(w, http.StatusInternalServerError, "failed to process some metrics")
+		case limitErr != nil:
+			var exceeded *ratelimit.ExceededError
+			if errors.As(limitErr, &exceeded) {
+				w.Header().Set("Retry-After", retryAfterSeconds(exceeded.RetryAfter))
+			}
+			writeInfluxError(w, http.StatusTooManyRequests, limitErr.Error())
+		case len(parseErrs) > 0:
+			writeInfluxError(w, http.StatusBadRequest, "partial write: "+parseErrs[0].Error())
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// writeInfluxError отвечает ошибкой в формате InfluxDB
+func writeInfluxError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go-service/internal/audit"
+	"go-service/internal/auth"
+	"go-service/internal/concurrency"
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// KeyRequest запрос выдачи ключа API
+type KeyRequest struct {
+	Name      string      `json:"name"`
+	Tenant    string      `json:"tenant,omitempty"` // Пусто — арендатор выдающего ключа
+	Devices   []string    `json:"devices"`
+	Roles     []auth.Role `json:"roles"`
+	ExpiresIn string      `json:"expires_in,omitempty"` // Длительность, например 720h; пусто — бессрочный
+}
+
+// KeyResponse выданный ключ. Token возвращается один раз и не хранится.
+type KeyResponse struct {
+	auth.Key
+	Token string `json:"token"`
+}
+
+// RegisterKeyHandlers регистрирует административные обработчики ключей API.
+// Выдача и отзыв ключей записываются в журнал действий.
+func RegisterKeyHandlers(r *mux.Router, routes *auth.Routes, authenticator *auth.Authenticator, auditLog *audit.Log, logger *zap.SugaredLogger) {
+	routes.HandleFunc(r, "/admin/keys", auth.PermissionManageKeys, concurrency.PriorityNormal, IssueKeyHandler(authenticator, auditLog, logger)).Methods("POST")
+	routes.HandleFunc(r, "/admin/keys", auth.PermissionManageKeys, concurrency.PriorityNormal, ListKeysHandler(authenticator, logger)).Methods("GET")
+	routes.HandleFunc(r, "/admin/keys/{id}", auth.PermissionManageKeys, concurrency.PriorityNormal, RevokeKeyHandler(authenticator, auditLog, logger)).Methods("DELETE")
+}
+
+// IssueKeyHandler выдает ключ с областью устройств и ролями, не шире
+// ключа, которым выдается
+func IssueKeyHandler(authenticator *auth.Authenticator, auditLog *audit.Log, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("issue_key")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("issue_key", time.Since(start)) }()
+
+		var req KeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		key := auth.Key{Name: req.Name, Tenant: req.Tenant, Devices: req.Devices, Roles: req.Roles}
+		caller, _ := auth.FromContext(r.Context())
+		if key.Tenant == "" && caller != nil {
+			key.Tenant = caller.Tenant
+		}
+		if !managesTenant(caller, key.TenantID()) {
+			http.Error(w, "Cannot issue keys for another tenant", http.StatusForbidden)
+			return
+		}
+		if req.ExpiresIn != "" {
+			ttl, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil || ttl <= 0 {
+				http.Error(w, "Invalid expires_in", http.StatusBadRequest)
+				return
+			}
+			expiresAt := time.Now().Add(ttl).UTC()
+			key.ExpiresAt = &expiresAt
+		}
+		if err := key.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Ключ не может выдать ключ шире себя: иначе администратор с
+		// частью устройств выдал бы себе доступ ко всем
+		if caller != nil {
+			if err := caller.Delegates(&key); err != nil {
+				metrics.RecordAuthFailure("forbidden")
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+
+		token, issued, err := authenticator.Issue(r.Context(), key)
+		if err != nil {
+			logger.Errorf("Failed to issue api key: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Infof("Issued api key %s (%s) for tenant %s devices %v", issued.ID, issued.Name, issued.TenantID(), issued.Devices)
+		auditLog.Record(r.Context(), audit.ActionKeyIssue, "key:"+issued.ID, nil, issued)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(KeyResponse{Key: *issued, Token: token})
+	}
+}
+
+// ListKeysHandler возвращает действующие ключи арендатора без их значений
+func ListKeysHandler(authenticator *auth.Authenticator, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("list_keys")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("list_keys", time.Since(start)) }()
+
+		keys, err := authenticator.List(r.Context())
+		if err != nil {
+			logger.Errorf("Failed to list api keys: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		caller, _ := auth.FromContext(r.Context())
+		visible := keys[:0]
+		for _, key := range keys {
+			if managesTenant(caller, key.TenantID()) {
+				visible = append(visible, key)
+			}
+		}
+		keys = visible
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	}
+}
+
+// RevokeKeyHandler отзывает ключ по идентификатору. Ключ другого
+// арендатора считается несуществующим.
+func RevokeKeyHandler(authenticator *auth.Authenticator, auditLog *audit.Log, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("revoke_key")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("revoke_key", time.Since(start)) }()
+
+		id := mux.Vars(r)["id"]
+		keys, err := authenticator.List(r.Context())
+		if err != nil {
+			logger.Errorf("Failed to list api keys: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		// Отзываемый ключ нужен и для проверки арендатора, и для журнала
+		var revoked *auth.Key
+		for i := range keys {
+			if keys[i].ID == id {
+				revoked = &keys[i]
+			}
+		}
+		caller, _ := auth.FromContext(r.Context())
+		if revoked == nil || !managesTenant(caller, revoked.TenantID()) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+
+		err = authenticator.Revoke(r.Context(), id)
+		if errors.Is(err, auth.ErrNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Errorf("Failed to revoke api key %s: %v", id, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		logger.Infof("Revoked api key %s", id)
+		auditLog.Record(r.Context(), audit.ActionKeyRevoke, "key:"+id, revoked, nil)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// managesTenant проверяет, что ключ caller может управлять ключами
+// арендатора tenantID: своего или любого для начального ключа
+func managesTenant(caller *auth.Key, tenantID string) bool {
+	return caller != nil && (caller.ManagesTenants() || caller.TenantID() == tenantID)
+}
@@ -13,7 +13,7 @@ import (
 // AnalyticsHandler обработчик для аналитики
 func AnalyticsHandler(analyticsService *analytics.AnalyticsService, redisClient *cache.RedisClient, logger *zap.SugaredLogger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		summary := analyticsService.GetSummary()
+		summary := analyticsService.GetSummary(r.Context())
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(summary)
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/concurrency"
+	"go-service/internal/ingest"
+	"go-service/internal/ratelimit"
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// RegisterOTLPHandlers регистрирует прием метрик OTLP/HTTP
+func RegisterOTLPHandlers(r *mux.Router, routes *auth.Routes, analyticsService *analytics.AnalyticsService, mapping ingest.OTLPMapping, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) {
+	routes.HandleFunc(r, "/v1/metrics", auth.PermissionWrite, concurrency.PriorityIngest, OTLPMetricsHandler(analyticsService, mapping, limiter, logger)).Methods("POST")
+}
+
+// OTLPMetricsHandler обработчик экспорта метрик OTLP/HTTP в protobuf или
+// JSON. Отвечает 200 с partial_success, если часть точек не сопоставлена,
+// 400 на неразбираемый запрос, который экспортер не повторяет, 429 с
+// Retry-After, если ключ API или устройства превысили скорость, и 503,
+// если часть метрик не обработана и запрос нужно повторить.
+func OTLPMetricsHandler(analyticsService *analytics.AnalyticsService, mapping ingest.OTLPMapping, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("otlp_metrics")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("otlp_metrics", time.Since(start)) }()
+
+		encoding, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (encoding != ingest.OTLPProtobuf && encoding != ingest.OTLPJSON) {
+			http.Error(w, ingest.ErrUnsupportedEncoding.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			body = zr
+		}
+		data, err := io.ReadAll(io.LimitReader(body, ingest.MaxOTLPSize+1))
+		if err != nil {
+			logger.Errorf("Failed to read OTLP request: %v", err)
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if len(data) > ingest.MaxOTLPSize {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		req, err := ingest.DecodeOTLP(data, encoding)
+		if err != nil {
+			logger.Errorf("Failed to decode OTLP request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		batch, stats := mapping.Metrics(req, time.Now())
+		metrics.RecordIngestedMetrics("otlp", "unmapped", stats.Unmapped)
+		metrics.RecordIngestedMetrics("otlp", "incomplete", stats.Incomplete)
+		batch, limitErr := ingest.Admit(r.Context(), limiter, "otlp", batch)
+
+		var failed int
+		for _, metric := range batch {
+			if err := ingest.Process(r.Context(), analyticsService, "otlp", metric); err != nil {
+				logger.Errorf("Failed to process OTLP metric for device %s: %v", metric.DeviceID, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			// Обработанные метрики при повторе подавит идентификатор сообщения
+			http.Error(w, "failed to process some metrics", http.StatusServiceUnavailable)
+			return
+		}
+		if limitErr != nil {
+			writeRateLimited(w, limitErr)
+			return
+		}
+
+		var message string
+		if stats.Unmapped > 0 || stats.Incomplete > 0 {
+			message = fmt.Sprintf("%d data points without device or known field, %d values of metrics without rps", stats.Unmapped, stats.Incomplete)
+		}
+		resp, err := ingest.EncodeOTLPResponse(encoding, int64(stats.Unmapped), message)
+		if err != nil {
+			logger.Errorf("Failed to encode OTLP response: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", encoding)
+		w.Write(resp)
+	}
+}
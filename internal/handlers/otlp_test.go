@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/ingest"
+	"go-service/internal/storage"
+	"go-service/pkg/metrics"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPMetricsTenant(t *testing.T) {
+	metrics.InitMetrics()
+	store := storage.NewMemory()
+	service := analytics.NewAnalyticsService(store, 10, 2.0)
+	mapping := ingest.OTLPMapping{DeviceAttributes: []string{"host.name"}}
+	handler := OTLPMetricsHandler(service, mapping, nil, zap.NewNop().Sugar())
+
+	ts := uint64(time.Now().UnixNano())
+	req := &ingest.OTLPRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+			Key:   "host.name",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "h1"}},
+		}}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+			{Name: "rps", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+				{TimeUnixNano: ts, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 12.5}},
+			}}}},
+			{Name: "http.server.duration", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				DataPoints: []*metricspb.HistogramDataPoint{{TimeUnixNano: ts, Count: 3}},
+			}}},
+		}}},
+	}}}
+	body, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := &auth.Key{ID: "k1", Tenant: "acme", Devices: []string{"*"}, Roles: []auth.Role{auth.RoleIngest}}
+	httpReq := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", ingest.OTLPProtobuf)
+	httpReq = httpReq.WithContext(auth.WithKey(httpReq.Context(), key))
+	rec := httptest.NewRecorder()
+	handler(rec, httpReq)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp colmetricspb.ExportMetricsServiceResponse
+	if err := proto.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetPartialSuccess().GetRejectedDataPoints(); got != 1 {
+		t.Errorf("rejected data points = %d, want 1 for the histogram", got)
+	}
+
+	// Устройство принадлежит арендатору ключа, а не арендатору по умолчанию
+	result, err := store.GetResult(context.Background(), "acme", "h1")
+	if err != nil || result.CurrentValue != 12.5 {
+		t.Errorf("acme result = %+v, err %v; want rps 12.5", result, err)
+	}
+	if _, err := store.GetResult(context.Background(), "default", "h1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("default tenant result err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestOTLPMetricsUnsupportedEncoding(t *testing.T) {
+	metrics.InitMetrics()
+	handler := OTLPMetricsHandler(nil, ingest.OTLPMapping{DeviceAttributes: []string{"host.name"}}, nil, zap.NewNop().Sugar())
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader([]byte("rps 1")))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want 415", rec.Code)
+	}
+}
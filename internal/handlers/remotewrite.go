@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/concurrency"
+	"go-service/internal/ingest"
+	"go-service/internal/ratelimit"
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// RegisterRemoteWriteHandlers регистрирует прием Prometheus remote_write
+func RegisterRemoteWriteHandlers(r *mux.Router, routes *auth.Routes, analyticsService *analytics.AnalyticsService, mapping ingest.RemoteWriteMapping, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) {
+	routes.HandleFunc(r, "/api/v1/write", auth.PermissionWrite, concurrency.PriorityIngest, RemoteWriteHandler(analyticsService, mapping, limiter, logger)).Methods("POST")
+}
+
+// RemoteWriteHandler обработчик Prometheus remote_write. Отвечает 204 при
+// успехе, 400 на неразбираемый запрос, который Prometheus не повторяет,
+// 429 с Retry-After, если ключ API или устройства превысили скорость, и 500,
+// если часть метрик не обработана и запрос нужно повторить.
+func RemoteWriteHandler(analyticsService *analytics.AnalyticsService, mapping ingest.RemoteWriteMapping, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("remote_write")
+		start := time.Now()
+		defer func() { metrics.RecordRequestDuration("remote_write", time.Since(start)) }()
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, ingest.MaxRemoteWriteSize))
+		if err != nil {
+			logger.Errorf("Failed to read remote write request: %v", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+
+		series, err := ingest.DecodeRemoteWrite(body)
+		if err != nil {
+			logger.Errorf("Failed to decode remote write request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		batch, stats := mapping.Metrics(series)
+		metrics.RecordIngestedMetrics("remote_write", "unmapped", stats.Unmapped)
+		metrics.RecordIngestedMetrics("remote_write", "incomplete", stats.Incomplete)
+		batch, limitErr := ingest.Admit(r.Context(), limiter, "remote_write", batch)
+
+		var failed int
+		for _, metric := range batch {
+			if err := ingest.Process(r.Context(), analyticsService, "remote_write", metric); err != nil {
+				logger.Errorf("Failed to process remote write metric for device %s: %v", metric.DeviceID, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			// Обработанные метрики при повторе подавит идентификатор сообщения
+			http.Error(w, "Failed to process some metrics", http.StatusInternalServerError)
+			return
+		}
+		if limitErr != nil {
+			writeRateLimited(w, limitErr)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/concurrency"
+	"go-service/internal/idempotency"
+	"go-service/internal/models"
+	"go-service/internal/ratelimit"
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// maxSocketMessageSize предел размера входящего сообщения
+	maxSocketMessageSize = 64 * 1024
+	// socketWriteWait время на отправку одного сообщения
+	socketWriteWait = 10 * time.Second
+	// socketSendBuffer очередь исходящих сообщений соединения. Клиент,
+	// который не успевает читать, отключается.
+	socketSendBuffer = 256
+)
+
+// WebSocketConfig настройки канала устройств
+type WebSocketConfig struct {
+	RateLimit    float64       // Метрик в секунду на соединение
+	RateBurst    int           // Допустимый всплеск сверх RateLimit
+	PingInterval time.Duration // Соединение закрывается без pong за два интервала
+}
+
+// SocketMessage сообщение сервера устройству
+type SocketMessage struct {
+	Type      string                  `json:"type"` // result, anomaly или error
+	MessageID string                  `json:"message_id,omitempty"`
+	Result    *models.AnalyticsResult `json:"result,omitempty"`
+	Status    string                  `json:"status,omitempty"` // Для error: invalid, rate_limited, dropped, diverted_to_history, quota_exceeded, in_progress, error
+	Error     string                  `json:"error,omitempty"`
+}
+
+// DeviceSockets принимает метрики устройств по WebSocket и отвечает на
+// каждую результатом аналитики. Дополнительно устройство получает все свои
+// аномалии, включая обнаруженные по метрикам из других каналов.
+type DeviceSockets struct {
+	service  *analytics.AnalyticsService
+	cfg      WebSocketConfig
+	limiter  *ratelimit.Limiter // Скорость ключа API и устройства, общая с HTTP
+	logger   *zap.SugaredLogger
+	upgrader websocket.Upgrader
+
+	mu     sync.Mutex
+	conns  map[*websocket.Conn]struct{}
+	closed bool
+}
+
+// NewDeviceSockets создает канал устройств. Помимо скорости соединения
+// из cfg метрики расходуют скорость ключа API и устройства из limiter.
+func NewDeviceSockets(service *analytics.AnalyticsService, cfg WebSocketConfig, limiter *ratelimit.Limiter, logger *zap.SugaredLogger) *DeviceSockets {
+	return &DeviceSockets{
+		service: service,
+		cfg:     cfg,
+		limiter: limiter,
+		logger:  logger,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+		},
+		conns: make(map[*websocket.Conn]struct{}),
+	}
+}
+
+// RegisterWebSocketHandlers регистрирует канал устройств. Подключение
+// открывается через GET, но устройство по нему пишет метрики.
+func RegisterWebSocketHandlers(r *mux.Router, routes *auth.Routes, sockets *DeviceSockets) {
+	routes.HandleFunc(r, "/ws/devices/{deviceID}", auth.PermissionWrite, concurrency.PriorityNormal, sockets.Handler()).Methods("GET")
+}
+
+// Handler обработчик подключения устройства
+func (d *DeviceSockets) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.RecordRequest("websocket")
+
+		deviceID := mux.Vars(r)["deviceID"]
+		conn, err := d.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrader уже ответил клиенту ошибкой
+			d.logger.Warnf("WebSocket upgrade failed for device %s: %v", deviceID, err)
+			return
+		}
+		if !d.track(conn) {
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"),
+				time.Now().Add(time.Second))
+			conn.Close()
+			return
+		}
+
+		metrics.ActiveConnections.Inc()
+		start := time.Now()
+		defer func() {
+			metrics.ActiveConnections.Dec()
+			metrics.RecordRequestDuration("websocket", time.Since(start))
+			d.untrack(conn)
+			conn.Close()
+		}()
+
+		d.serve(r.Context(), conn, deviceID)
+	}
+}
+
+// Close отключает все устройства. Вызывается при остановке сервера, так как
+// http.Server.Shutdown не ждет перехваченные соединения.
+func (d *DeviceSockets) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	for conn := range d.conns {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down"),
+			time.Now().Add(time.Second))
+		conn.Close()
+	}
+}
+
+func (d *DeviceSockets) track(conn *websocket.Conn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false
+	}
+	d.conns[conn] = struct{}{}
+	return true
+}
+
+func (d *DeviceSockets) untrack(conn *websocket.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.conns, conn)
+}
+
+// serve читает метрики устройства, пока соединение открыто. Запись ведет
+// отдельная горутина, так как websocket.Conn не допускает параллельной записи.
+func (d *DeviceSockets) serve(ctx context.Context, conn *websocket.Conn, deviceID string) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	send := make(chan SocketMessage, socketSendBuffer)
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		d.writeLoop(ctx, conn, deviceID, send)
+		// Ошибка записи должна прервать и чтение
+		conn.Close()
+	}()
+	defer func() {
+		cancel()
+		<-writerDone
+	}()
+
+	pongWait := 2 * d.cfg.PingInterval
+	conn.SetReadLimit(maxSocketMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	connLimiter := rate.NewLimiter(rate.Limit(d.cfg.RateLimit), d.cfg.RateBurst)
+	reply := func(msg SocketMessage) bool {
+		select {
+		case send <- msg:
+			return true
+		default:
+			d.logger.Warnf("WebSocket client for device %s is too slow, disconnecting", deviceID)
+			return false
+		}
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				d.logger.Infof("WebSocket for device %s closed: %v", deviceID, err)
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		if !reply(d.handleMessage(ctx, connLimiter, deviceID, data)) {
+			return
+		}
+	}
+}
+
+// handleMessage обрабатывает одну метрику и формирует ответ
+func (d *DeviceSockets) handleMessage(ctx context.Context, connLimiter *rate.Limiter, deviceID string, data []byte) SocketMessage {
+	var metric models.Metric
+	if err := json.Unmarshal(data, &metric); err != nil {
+		return SocketMessage{Type: "error", Status: "invalid", Error: "invalid metric: " + err.Error()}
+	}
+	metric.DeviceID = deviceID
+
+	if !connLimiter.Allow() {
+		metrics.RecordIngestedMetrics("websocket", "rate_limited", 1)
+		return SocketMessage{Type: "error", MessageID: metric.MessageID, Status: "rate_limited", Error: "rate limit exceeded"}
+	}
+	err := d.limiter.Key(ctx, "websocket", 1)
+	if err == nil {
+		err = d.limiter.Device(ctx, "websocket", deviceID, 1)
+	}
+	if err != nil {
+		metrics.RecordIngestedMetrics("websocket", "rate_limited", 1)
+		return SocketMessage{Type: "error", MessageID: metric.MessageID, Status: "rate_limited", Error: err.Error()}
+	}
+	if metric.RPS < 0 {
+		metrics.RecordIngestedMetrics("websocket", "invalid", 1)
+		return SocketMessage{Type: "error", MessageID: metric.MessageID, Status: "invalid", Error: "Invalid metric data"}
+	}
+
+	result, err := d.service.ProcessMetric(ctx, metric)
+	var lateErr *analytics.LateMetricError
+	var invalidErr *analytics.InvalidMetricError
+	var quotaErr *analytics.QuotaExceededError
+	switch {
+	case errors.As(err, &invalidErr):
+		metrics.RecordIngestedMetrics("websocket", "invalid", 1)
+		return SocketMessage{Type: "error", MessageID: metric.MessageID, Status: "invalid", Error: invalidErr.Error()}
+	case errors.As(err, &lateErr):
+		metrics.RecordIngestedMetrics("websocket", "late", 1)
+		status := "dropped"
+		if lateErr.Diverted {
+			status = "diverted_to_history"
+		}
+		return SocketMessage{Type: "error", MessageID: metric.MessageID, Status: status, Error: lateErr.Error()}
+	case errors.As(err, &quotaErr):
+		metrics.RecordIngestedMetrics("websocket", "quota_exceeded", 1)
+		return SocketMessage{Type: "error", MessageID: metric.MessageID, Status: "quota_exceeded", Error: quotaErr.Error()}
+	case errors.Is(err, idempotency.ErrInProgress):
+		return SocketMessage{Type: "error", MessageID: metric.MessageID, Status: "in_progress", Error: err.Error()}
+	case err != nil:
+		d.logger.Errorf("Failed to process WebSocket metric for device %s: %v", deviceID, err)
+		metrics.RecordIngestedMetrics("websocket", "failed", 1)
+		return SocketMessage{Type: "error", MessageID: metric.MessageID, Status: "error", Error: "Internal server error"}
+	case result.Duplicate:
+		metrics.RecordIngestedMetrics("websocket", "duplicate", 1)
+	default:
+		metrics.RecordIngestedMetrics("websocket", "accepted", 1)
+		metrics.RecordMetricProcessed()
+	}
+	return SocketMessage{Type: "result", MessageID: metric.MessageID, Result: result}
+}
+
+// writeLoop отправляет ответы, аномалии устройства и ping
+func (d *DeviceSockets) writeLoop(ctx context.Context, conn *websocket.Conn, deviceID string, send <-chan SocketMessage) {
+	anomalies, unsubscribe := d.service.SubscribeAnomalies(ctx, socketSendBuffer, deviceID)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(d.cfg.PingInterval)
+	defer ticker.Stop()
+
+	write := func(msg SocketMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+		return conn.WriteJSON(msg)
+	}
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(time.Second))
+			return
+		case msg := <-send:
+			err = write(msg)
+		case anomaly := <-anomalies:
+			err = write(SocketMessage{Type: "anomaly", Result: &anomaly})
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait))
+		}
+		if err != nil {
+			d.logger.Infof("WebSocket write to device %s failed: %v", deviceID, err)
+			return
+		}
+	}
+}
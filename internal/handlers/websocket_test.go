@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/models"
+	"go-service/internal/storage"
+	"go-service/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// newSocketServer запускает канал устройств за проверкой ключей API
+func newSocketServer(t *testing.T, keys map[string]auth.Key) (*httptest.Server, *analytics.AnalyticsService) {
+	t.Helper()
+	metrics.InitMetrics()
+	logger := zap.NewNop().Sugar()
+
+	store := auth.NewMemory()
+	for token, key := range keys {
+		if err := store.Create(context.Background(), auth.HashToken(token), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service := analytics.NewAnalyticsService(storage.NewMemory(), 10, 2.0)
+	sockets := NewDeviceSockets(service, WebSocketConfig{RateLimit: 1000, RateBurst: 1000, PingInterval: time.Minute}, nil, logger)
+
+	r := mux.NewRouter()
+	routes := auth.NewRoutes()
+	r.Use(auth.NewAuthenticator(store, routes, "", logger).Middleware)
+	RegisterWebSocketHandlers(r, routes, sockets)
+
+	server := httptest.NewServer(r)
+	t.Cleanup(func() {
+		sockets.Close()
+		server.Close()
+	})
+	return server, service
+}
+
+// dialDevice подключает устройство с ключом token
+func dialDevice(server *httptest.Server, device, token string) (*websocket.Conn, *http.Response, error) {
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/devices/" + device
+	return websocket.DefaultDialer.Dial(url, header)
+}
+
+// exchange отправляет метрику и читает ответ на нее. После ответа подписка
+// соединения на аномалии уже действует.
+func exchange(t *testing.T, conn *websocket.Conn, metric models.Metric) SocketMessage {
+	t.Helper()
+	if err := conn.WriteJSON(metric); err != nil {
+		t.Fatal(err)
+	}
+	var msg SocketMessage
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+var socketKeys = map[string]auth.Key{
+	"acme-token":   {ID: "acme", Tenant: "acme", Devices: []string{"sensor-*"}, Roles: []auth.Role{auth.RoleIngest}},
+	"globex-token": {ID: "globex", Tenant: "globex", Devices: []string{"*"}, Roles: []auth.Role{auth.RoleIngest}},
+	"viewer-token": {ID: "viewer", Tenant: "acme", Devices: []string{"*"}, Roles: []auth.Role{auth.RoleViewer}},
+}
+
+func TestWebSocketAuth(t *testing.T) {
+	server, _ := newSocketServer(t, socketKeys)
+
+	tests := []struct {
+		name       string
+		device     string
+		token      string
+		wantStatus int
+	}{
+		{name: "missing key", device: "sensor-1", wantStatus: http.StatusUnauthorized},
+		{name: "unknown key", device: "sensor-1", token: "wrong-token", wantStatus: http.StatusUnauthorized},
+		{name: "missing permission", device: "sensor-1", token: "viewer-token", wantStatus: http.StatusForbidden},
+		{name: "device out of scope", device: "pump-1", token: "acme-token", wantStatus: http.StatusForbidden},
+		{name: "allowed", device: "sensor-1", token: "acme-token", wantStatus: http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := dialDevice(server, tt.device, tt.token)
+			if conn != nil {
+				defer conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("dial error = %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d (err %v)", resp.StatusCode, tt.wantStatus, err)
+			}
+		})
+	}
+}
+
+func TestWebSocketDelivery(t *testing.T) {
+	server, service := newSocketServer(t, socketKeys)
+
+	acme, _, err := dialDevice(server, "sensor-1", "acme-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acme.Close()
+	globex, _, err := dialDevice(server, "sensor-1", "globex-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer globex.Close()
+
+	// Ответ на метрику относится к устройству арендатора ключа
+	msg := exchange(t, acme, models.Metric{RPS: 10, DeviceID: "pump-1", MessageID: "m1"})
+	if msg.Type != "result" || msg.MessageID != "m1" || msg.Result == nil {
+		t.Fatalf("reply = %+v, want result for m1", msg)
+	}
+	if msg.Result.DeviceID != "sensor-1" || msg.Result.Tenant != "acme" || msg.Result.CurrentValue != 10 {
+		t.Errorf("result = %+v, want acme sensor-1 with rps 10", msg.Result)
+	}
+	if msg := exchange(t, globex, models.Metric{RPS: -1}); msg.Type != "error" || msg.Status != "invalid" {
+		t.Errorf("reply to negative rps = %+v, want invalid error", msg)
+	}
+
+	// Аномалия из другого канала доходит до устройства acme, но не до
+	// одноименного устройства globex
+	ctx := auth.WithKey(context.Background(), &auth.Key{ID: "acme", Tenant: "acme", Devices: []string{"*"}})
+	start := time.Now().Add(-time.Second)
+	for i := 0; i < 10; i++ {
+		rps := 10 + float64(i%2)
+		if i == 9 {
+			rps = 1000
+		}
+		if _, err := service.ProcessMetric(ctx, models.Metric{DeviceID: "sensor-1", Timestamp: start.Add(time.Duration(i) * time.Millisecond), RPS: rps}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	acme.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := acme.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "anomaly" || msg.Result == nil || !msg.Result.IsAnomaly || msg.Result.CurrentValue != 1000 {
+		t.Errorf("acme message = %+v, want anomaly with rps 1000", msg)
+	}
+
+	globex.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if err := globex.ReadJSON(&msg); err == nil {
+		t.Errorf("globex received %+v for another tenant's device", msg)
+	}
+}
@@ -0,0 +1,29 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go-service/internal/models"
+)
+
+// ErrInProgress возвращается, пока сообщение с тем же ключом еще обрабатывается
+var ErrInProgress = errors.New("message with this idempotency key is being processed")
+
+// ReservationTTL сколько держится ключ необработанного сообщения. Если узел
+// упадет во время обработки, повтор будет принят после этого срока, а не
+// через все окно дедупликации.
+const ReservationTTL = 30 * time.Second
+
+// Store запоминает результаты обработанных сообщений на время окна дедупликации
+type Store interface {
+	// Reserve занимает ключ для обработки. Для уже обработанного сообщения
+	// возвращает его результат, для обрабатываемого — ErrInProgress,
+	// для нового — nil, nil.
+	Reserve(ctx context.Context, key string) (*models.AnalyticsResult, error)
+	// Complete сохраняет результат занятого ключа на все окно
+	Complete(ctx context.Context, key string, result *models.AnalyticsResult) error
+	// Release освобождает ключ сообщения, которое не удалось обработать
+	Release(ctx context.Context, key string) error
+}
@@ -0,0 +1,81 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-service/internal/models"
+)
+
+// testStore проверяет общий для хранилищ порядок Reserve, Complete и
+// Release. Ключи получают префикс prefix, чтобы не пересекаться с
+// ключами прошлых запусков в общем Redis.
+func testStore(t *testing.T, store Store, prefix string) {
+	ctx := context.Background()
+
+	t.Run("Replay", func(t *testing.T) {
+		key := prefix + "replay"
+		if result, err := store.Reserve(ctx, key); result != nil || err != nil {
+			t.Fatalf("Reserve new key = %v, %v; want nil, nil", result, err)
+		}
+		want := &models.AnalyticsResult{DeviceID: "d1", Tenant: "acme", RollingAverage: 42}
+		if err := store.Complete(ctx, key, want); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			result, err := store.Reserve(ctx, key)
+			if err != nil {
+				t.Fatalf("Reserve completed key: %v", err)
+			}
+			if result == nil || result.DeviceID != want.DeviceID || result.Tenant != want.Tenant || result.RollingAverage != want.RollingAverage {
+				t.Fatalf("Reserve completed key = %+v, want %+v", result, want)
+			}
+		}
+	})
+
+	t.Run("InProgress", func(t *testing.T) {
+		key := prefix + "in-progress"
+		if _, err := store.Reserve(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if result, err := store.Reserve(ctx, key); result != nil || !errors.Is(err, ErrInProgress) {
+			t.Fatalf("Reserve reserved key = %v, %v; want ErrInProgress", result, err)
+		}
+	})
+
+	t.Run("ReleaseAfterFailure", func(t *testing.T) {
+		key := prefix + "release"
+		if _, err := store.Reserve(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Release(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if result, err := store.Reserve(ctx, key); result != nil || err != nil {
+			t.Fatalf("Reserve released key = %v, %v; want nil, nil", result, err)
+		}
+	})
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory(time.Minute), "")
+}
+
+func TestMemoryExpires(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemory(10 * time.Millisecond)
+
+	if _, err := store.Reserve(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Complete(ctx, "k", &models.AnalyticsResult{DeviceID: "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if result, err := store.Reserve(ctx, "k"); result != nil || err != nil {
+		t.Fatalf("Reserve after window = %v, %v; want nil, nil", result, err)
+	}
+}
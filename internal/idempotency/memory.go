@@ -0,0 +1,87 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go-service/internal/models"
+)
+
+// sweepInterval как часто удалять истекшие ключи
+const sweepInterval = time.Minute
+
+// memoryEntry результат сообщения или резерв, если result == nil
+type memoryEntry struct {
+	result  *models.AnalyticsResult
+	expires time.Time
+}
+
+// Memory хранилище ключей в памяти процесса для одиночного узла
+type Memory struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemory создает хранилище с окном дедупликации window
+func NewMemory(window time.Duration) *Memory {
+	return &Memory{
+		window:    window,
+		entries:   make(map[string]memoryEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve занимает ключ для обработки
+func (m *Memory) Reserve(ctx context.Context, key string) (*models.AnalyticsResult, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	if entry, exists := m.entries[key]; exists && now.Before(entry.expires) {
+		if entry.result == nil {
+			return nil, ErrInProgress
+		}
+		result := *entry.result
+		return &result, nil
+	}
+
+	m.entries[key] = memoryEntry{expires: now.Add(min(ReservationTTL, m.window))}
+	return nil, nil
+}
+
+// Complete сохраняет результат занятого ключа
+func (m *Memory) Complete(ctx context.Context, key string, result *models.AnalyticsResult) error {
+	stored := *result
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = memoryEntry{result: &stored, expires: time.Now().Add(m.window)}
+	return nil
+}
+
+// Release освобождает ключ
+func (m *Memory) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// sweep удаляет истекшие ключи не чаще раза в sweepInterval
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, entry := range m.entries {
+		if !now.Before(entry.expires) {
+			delete(m.entries, key)
+		}
+	}
+}
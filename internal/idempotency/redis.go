@@ -0,0 +1,67 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go-service/internal/cache"
+	"go-service/internal/models"
+)
+
+// keyPrefix префикс ключей дедупликации в Redis
+const keyPrefix = "idempotency:"
+
+// Redis хранилище ключей в Redis, общее для всех узлов сервиса
+type Redis struct {
+	client *cache.RedisClient
+	window time.Duration
+}
+
+// NewRedis создает хранилище с окном дедупликации window
+func NewRedis(client *cache.RedisClient, window time.Duration) *Redis {
+	return &Redis{client: client, window: window}
+}
+
+// Reserve занимает ключ для обработки. Резерв хранится пустым значением.
+func (r *Redis) Reserve(ctx context.Context, key string) (*models.AnalyticsResult, error) {
+	// Ключ мог истечь между SETNX и GET, тогда пробуем занять его еще раз
+	for attempt := 0; attempt < 2; attempt++ {
+		reserved, err := r.client.SetNX(ctx, keyPrefix+key, []byte{}, min(ReservationTTL, r.window))
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			return nil, nil
+		}
+
+		data, err := r.client.GetRaw(ctx, keyPrefix+key)
+		if errors.Is(err, cache.ErrNil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			return nil, ErrInProgress
+		}
+
+		var result models.AnalyticsResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+		return &result, nil
+	}
+	return nil, ErrInProgress
+}
+
+// Complete сохраняет результат занятого ключа
+func (r *Redis) Complete(ctx context.Context, key string, result *models.AnalyticsResult) error {
+	return r.client.Set(ctx, keyPrefix+key, result, r.window)
+}
+
+// Release освобождает ключ
+func (r *Redis) Release(ctx context.Context, key string) error {
+	return r.client.Delete(ctx, keyPrefix+key)
+}
@@ -0,0 +1,64 @@
+//go:build integration
+
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-service/internal/cache"
+)
+
+// Тесты с Redis:
+//
+//	docker run -d -p 6379:6379 redis:7
+//	REDIS_TEST_ADDR=localhost:6379 go test -tags integration ./internal/idempotency
+
+// testRedisClient подключается к Redis из REDIS_TEST_ADDR
+func testRedisClient(t *testing.T) *cache.RedisClient {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("invalid REDIS_TEST_ADDR %q: %v", addr, err)
+	}
+	t.Setenv("REDIS_HOST", host)
+	t.Setenv("REDIS_PORT", port)
+
+	client := cache.NewRedisClient()
+	t.Cleanup(func() { client.Close() })
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Redis at %s is unavailable: %v", addr, err)
+	}
+	return client
+}
+
+func TestRedis(t *testing.T) {
+	client := testRedisClient(t)
+	// Ключи одного запуска не пересекаются с ключами прошлых
+	prefix := "test:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":"
+	testStore(t, NewRedis(client, time.Minute), prefix)
+}
+
+func TestRedisSharedBetweenNodes(t *testing.T) {
+	client := testRedisClient(t)
+	key := "test:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":shared"
+	ctx := context.Background()
+
+	first := NewRedis(client, time.Minute)
+	second := NewRedis(client, time.Minute)
+	if _, err := first.Reserve(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Reserve(ctx, key); !errors.Is(err, ErrInProgress) {
+		t.Fatalf("Reserve on another node = %v, want ErrInProgress", err)
+	}
+}
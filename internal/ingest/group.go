@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	"go-service/internal/models"
+)
+
+// Stats итог сопоставления принятых данных полям метрик
+type Stats struct {
+	Unmapped   int // Значения без устройства или с неизвестным именем поля
+	Incomplete int // Отброшенные значения метрик только с основными полями и без rps
+}
+
+// groupKey метрика устройства в момент времени
+type groupKey struct {
+	device string
+	ts     int64
+}
+
+// group собираемая метрика
+type group struct {
+	metric models.Metric
+	hasRPS bool
+	values int // Принятые значения полей
+}
+
+// grouper собирает значения отдельных полей в метрики устройств.
+// Значения одного устройства с одинаковым временем образуют одну метрику.
+type grouper struct {
+	source string
+	groups map[groupKey]*group
+	stats  Stats
+}
+
+func newGrouper(source string) *grouper {
+	return &grouper{source: source, groups: make(map[groupKey]*group)}
+}
+
+// add учитывает значение поля устройства
+func (g *grouper) add(device string, ts time.Time, field string, value float64) {
+	k := groupKey{device: device, ts: ts.UnixNano()}
+	item, exists := g.groups[k]
+	if !exists {
+		item = &group{metric: models.Metric{DeviceID: device, Timestamp: ts}}
+		g.groups[k] = item
+	}
+	item.metric.SetField(field, value)
+	item.hasRPS = item.hasRPS || field == "rps"
+	item.values++
+}
+
+// metrics возвращает собранные метрики по устройству и времени. Метрики
+// только с основными полями и без rps пропускаются и учитываются в
+// Stats.Incomplete: z-score считается по rps, и нулевое значение исказило
+// бы окно.
+func (g *grouper) metrics() ([]models.Metric, Stats) {
+	result := make([]models.Metric, 0, len(g.groups))
+	for _, item := range g.groups {
+		if !item.hasRPS && len(item.metric.Values) == 0 {
+			g.stats.Incomplete += item.values
+			continue
+		}
+		item.metric.MessageID = g.messageID(item.metric)
+		result = append(result, item.metric)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DeviceID != result[j].DeviceID {
+			return result[i].DeviceID < result[j].DeviceID
+		}
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, g.stats
+}
+
+// messageID строит идентификатор метрики для подавления повторов.
+// Повторная отправка тех же данных дает тот же идентификатор, а части
+// одного scrape, разнесенные по запросам, с разными полями — разные.
+func (g *grouper) messageID(m models.Metric) string {
+	var names []string
+	m.EachField(func(name string, value float64) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		value, _ := m.Field(name)
+		h.Write([]byte(name))
+		h.Write([]byte{'='})
+		h.Write(strconv.AppendFloat(nil, value, 'g', -1, 64))
+		h.Write([]byte{0})
+	}
+	return g.source + ":" + m.DeviceID + ":" + strconv.FormatInt(m.Timestamp.UnixNano(), 10) + ":" + strconv.FormatUint(h.Sum64(), 16)
+}
@@ -0,0 +1,140 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go-service/internal/models"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// InfluxMapping сопоставляет точки line protocol полям метрик устройств
+type InfluxMapping struct {
+	DeviceTag string // Тег с идентификатором устройства
+	// Поле точки -> поле models.Metric, основное или дополнительное. Ключ
+	// measurement.field имеет приоритет над field. Без сопоставления
+	// принимаются только поля точки с именем основного поля.
+	Fields map[string]string
+}
+
+// Validate проверяет имена полей сопоставления
+func (m InfluxMapping) Validate() error {
+	if m.DeviceTag == "" {
+		return errors.New("device tag is required")
+	}
+	for name, field := range m.Fields {
+		if !models.ValidFieldName(field) {
+			return fmt.Errorf("field %s mapped to invalid field %q", name, field)
+		}
+	}
+	return nil
+}
+
+// field возвращает поле метрики для поля точки
+func (m InfluxMapping) field(measurement, name string) (string, bool) {
+	if field, ok := m.Fields[measurement+"."+name]; ok {
+		return field, true
+	}
+	if field, ok := m.Fields[name]; ok {
+		return field, true
+	}
+	return name, models.IsLegacyField(name)
+}
+
+// Metrics собирает метрики устройств из точек, значения одного устройства
+// с одинаковым временем объединяются в одну метрику
+func (m InfluxMapping) Metrics(points []Point) ([]models.Metric, Stats) {
+	g := newGrouper("influx")
+	for _, point := range points {
+		device := point.Tags[m.DeviceTag]
+		for name, value := range point.Fields {
+			field, ok := m.field(point.Measurement, name)
+			if device == "" || !ok {
+				g.stats.Unmapped++
+				continue
+			}
+			g.add(device, point.Time, field, value)
+		}
+	}
+	return g.metrics()
+}
+
+// maxDatagramSize наибольший размер UDP-пакета
+const maxDatagramSize = 64 * 1024
+
+// UDPListener принимает line protocol по UDP. Каждый пакет обрабатывается
+// отдельно, время точек в наносекундах, ответов и повторов нет.
+type UDPListener struct {
+	conn      *net.UDPConn
+	processor Processor
+	mapping   InfluxMapping
+	logger    *zap.SugaredLogger
+	wg        sync.WaitGroup
+}
+
+// ListenUDP запускает прием line protocol на адресе addr
+func ListenUDP(addr string, processor Processor, mapping InfluxMapping, logger *zap.SugaredLogger) (*UDPListener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &UDPListener{conn: conn, processor: processor, mapping: mapping, logger: logger}
+	l.wg.Add(1)
+	go l.run()
+	return l, nil
+}
+
+// Addr возвращает адрес, на котором принимаются пакеты
+func (l *UDPListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Close останавливает прием и ждет обработки последнего пакета
+func (l *UDPListener) Close() error {
+	err := l.conn.Close()
+	l.wg.Wait()
+	return err
+}
+
+// run читает пакеты до закрытия соединения
+func (l *UDPListener) run() {
+	defer l.wg.Done()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := l.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			l.logger.Errorf("Failed to read UDP packet: %v", err)
+			continue
+		}
+
+		points, errs := ParseLines(string(buf[:n]), time.Nanosecond, time.Now())
+		if len(errs) > 0 {
+			metrics.RecordIngestedMetrics("influx_udp", "invalid", len(errs))
+			l.logger.Warnf("Dropped %d invalid line protocol lines: %v", len(errs), errs[0])
+		}
+
+		batch, stats := l.mapping.Metrics(points)
+		metrics.RecordIngestedMetrics("influx_udp", "unmapped", stats.Unmapped)
+		metrics.RecordIngestedMetrics("influx_udp", "incomplete", stats.Incomplete)
+		for _, metric := range batch {
+			if err := Process(context.Background(), l.processor, "influx_udp", metric); err != nil {
+				l.logger.Errorf("Failed to process UDP metric for device %s: %v", metric.DeviceID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+
+	"go-service/internal/analytics"
+	"go-service/internal/auth"
+	"go-service/internal/models"
+	"go-service/internal/ratelimit"
+	"go-service/pkg/metrics"
+)
+
+// Processor принимает метрики для анализа
+type Processor interface {
+	ProcessMetric(ctx context.Context, metric models.Metric) (*models.AnalyticsResult, error)
+}
+
+// Process передает метрику источника source в обработку и учитывает итог.
+// Опоздавшая, недопустимая, запрещенная ключом и превысившая предел
+// устройств арендатора метрики не считаются ошибкой: повтор их не исправит.
+// Возвращенная ошибка означает, что метрику стоит отправить повторно.
+func Process(ctx context.Context, p Processor, source string, metric models.Metric) error {
+	if !auth.Allowed(ctx, metric.DeviceID, auth.PermissionWrite) {
+		metrics.RecordIngestedMetrics(source, "forbidden", 1)
+		return nil
+	}
+	result, err := p.ProcessMetric(ctx, metric)
+	var lateErr *analytics.LateMetricError
+	var invalidErr *analytics.InvalidMetricError
+	var quotaErr *analytics.QuotaExceededError
+	switch {
+	case errors.As(err, &lateErr):
+		metrics.RecordIngestedMetrics(source, "late", 1)
+		return nil
+	case errors.As(err, &invalidErr):
+		metrics.RecordIngestedMetrics(source, "invalid", 1)
+		return nil
+	case errors.As(err, &quotaErr):
+		metrics.RecordIngestedMetrics(source, "quota_exceeded", 1)
+		return nil
+	case err != nil:
+		metrics.RecordIngestedMetrics(source, "failed", 1)
+		return err
+	case result.Duplicate:
+		metrics.RecordIngestedMetrics(source, "duplicate", 1)
+	default:
+		metrics.RecordIngestedMetrics(source, "accepted", 1)
+		metrics.RecordMetricProcessed()
+	}
+	return nil
+}
+
+// Admit списывает с limiter скорость ключа API на весь пакет источника
+// source и скорость устройств на их метрики. Возвращает метрики, которые
+// можно передать в Process. Превышение скорости ключа отклоняет пакет
+// целиком, метрики устройств сверх их скорости отбрасываются; в обоих
+// случаях возвращается *ratelimit.ExceededError с наибольшим временем
+// ожидания, и клиенту стоит повторить пакет позже. Метрики, запрещенные
+// ключом, скорость не расходуют и остаются для учета в Process.
+func Admit(ctx context.Context, limiter *ratelimit.Limiter, source string, batch []models.Metric) ([]models.Metric, error) {
+	if err := limiter.Key(ctx, source, len(batch)); err != nil {
+		metrics.RecordIngestedMetrics(source, "rate_limited", len(batch))
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for _, metric := range batch {
+		if auth.Allowed(ctx, metric.DeviceID, auth.PermissionWrite) {
+			counts[metric.DeviceID]++
+		}
+	}
+	throttled := limiter.Devices(ctx, source, counts)
+	if len(throttled) == 0 {
+		return batch, nil
+	}
+
+	var longest *ratelimit.ExceededError
+	for _, exceeded := range throttled {
+		if longest == nil || exceeded.RetryAfter > longest.RetryAfter {
+			longest = exceeded
+		}
+	}
+	admitted := make([]models.Metric, 0, len(batch))
+	for _, metric := range batch {
+		if _, ok := throttled[metric.DeviceID]; ok {
+			metrics.RecordIngestedMetrics(source, "rate_limited", 1)
+			continue
+		}
+		admitted = append(admitted, metric)
+	}
+	return admitted, longest
+}
@@ -0,0 +1,84 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go-service/internal/auth"
+	"go-service/internal/models"
+	"go-service/internal/ratelimit"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+func newTestLimiter(device, key ratelimit.Limit) *ratelimit.Limiter {
+	metrics.InitMetrics()
+	return ratelimit.New(ratelimit.NewMemory(), device, key, zap.NewNop().Sugar())
+}
+
+func batchOf(devices ...string) []models.Metric {
+	batch := make([]models.Metric, len(devices))
+	for i, id := range devices {
+		batch[i] = models.Metric{DeviceID: id}
+	}
+	return batch
+}
+
+func TestAdmitDropsThrottledDevices(t *testing.T) {
+	limiter := newTestLimiter(ratelimit.Limit{Rate: 0.001, Burst: 2}, ratelimit.Limit{})
+	ctx := context.Background()
+
+	if _, err := Admit(ctx, limiter, "test", batchOf("a", "a")); err != nil {
+		t.Fatalf("first batch: %v", err)
+	}
+	admitted, err := Admit(ctx, limiter, "test", batchOf("a", "b", "a", "b"))
+	var exceeded *ratelimit.ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Scope != ratelimit.ScopeDevice || exceeded.RetryAfter <= 0 {
+		t.Fatalf("err = %v, want device ExceededError with retry", err)
+	}
+	if len(admitted) != 2 || admitted[0].DeviceID != "b" || admitted[1].DeviceID != "b" {
+		t.Errorf("admitted = %v, want only device b", admitted)
+	}
+}
+
+func TestAdmitRejectsBatchOverKeyLimit(t *testing.T) {
+	limiter := newTestLimiter(ratelimit.Limit{}, ratelimit.Limit{Rate: 0.001, Burst: 3})
+	key := &auth.Key{ID: "k1", Devices: []string{"*"}, Roles: []auth.Role{auth.RoleIngest}}
+	ctx := auth.WithKey(context.Background(), key)
+
+	if _, err := Admit(ctx, limiter, "test", batchOf("a", "b", "c")); err != nil {
+		t.Fatalf("first batch: %v", err)
+	}
+	admitted, err := Admit(ctx, limiter, "test", batchOf("a"))
+	var exceeded *ratelimit.ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Scope != ratelimit.ScopeKey {
+		t.Fatalf("err = %v, want key ExceededError", err)
+	}
+	if len(admitted) != 0 {
+		t.Errorf("admitted = %v, want none", admitted)
+	}
+}
+
+func TestAdmitSkipsForbiddenDevices(t *testing.T) {
+	limiter := newTestLimiter(ratelimit.Limit{Rate: 0.001, Burst: 1}, ratelimit.Limit{})
+	key := &auth.Key{ID: "k1", Devices: []string{"a"}, Roles: []auth.Role{auth.RoleIngest}}
+	ctx := auth.WithKey(context.Background(), key)
+
+	// Запрещенное устройство не расходует скорость и остается для Process
+	for i := 0; i < 3; i++ {
+		admitted, err := Admit(ctx, limiter, "test", batchOf("b"))
+		if err != nil || len(admitted) != 1 {
+			t.Fatalf("batch %d: admitted %v, err %v", i, admitted, err)
+		}
+	}
+}
+
+func TestAdmitNilLimiter(t *testing.T) {
+	batch := batchOf("a", "b")
+	admitted, err := Admit(context.Background(), nil, "test", batch)
+	if err != nil || len(admitted) != len(batch) {
+		t.Errorf("admitted %v, err %v, want whole batch", admitted, err)
+	}
+}
@@ -0,0 +1,184 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point точка InfluxDB line protocol. Строковые поля пропускаются,
+// логические переводятся в 1 и 0.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]float64
+	Time        time.Time
+}
+
+// ParsePrecision переводит точность времени InfluxDB в длительность
+func ParsePrecision(value string) (time.Duration, error) {
+	switch value {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	return 0, fmt.Errorf("unknown precision %q", value)
+}
+
+// ParseLines разбирает строки line protocol. Ошибочные строки не мешают
+// разбору остальных и возвращаются отдельно. Точки без времени получают now.
+func ParseLines(data string, precision time.Duration, now time.Time) ([]Point, []error) {
+	var points []Point
+	var errs []error
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		point, err := ParseLine(line, precision, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", n+1, err))
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, errs
+}
+
+// ParseLine разбирает строку вида measurement[,tag=value...] field=value[,...] [timestamp]
+func ParseLine(line string, precision time.Duration, now time.Time) (Point, error) {
+	key, rest, found := cutUnescaped(line, ' ', false)
+	if !found {
+		return Point{}, errors.New("missing fields")
+	}
+	fieldSet, timestamp, _ := cutUnescaped(strings.TrimLeft(rest, " "), ' ', true)
+
+	point := Point{
+		Tags:   make(map[string]string),
+		Fields: make(map[string]float64),
+		Time:   now,
+	}
+
+	parts := splitUnescaped(key, ',', false)
+	point.Measurement = unescape(parts[0])
+	if point.Measurement == "" {
+		return Point{}, errors.New("missing measurement")
+	}
+	for _, tag := range parts[1:] {
+		name, value, ok := cutUnescaped(tag, '=', false)
+		if !ok || name == "" || value == "" {
+			return Point{}, fmt.Errorf("invalid tag %q", tag)
+		}
+		point.Tags[unescape(name)] = unescape(value)
+	}
+
+	for _, field := range splitUnescaped(fieldSet, ',', true) {
+		name, value, ok := cutUnescaped(field, '=', true)
+		if !ok || name == "" || value == "" {
+			return Point{}, fmt.Errorf("invalid field %q", field)
+		}
+		number, numeric, err := parseFieldValue(value)
+		if err != nil {
+			return Point{}, fmt.Errorf("field %s: %w", unescape(name), err)
+		}
+		if numeric {
+			point.Fields[unescape(name)] = number
+		}
+	}
+
+	if timestamp = strings.TrimSpace(timestamp); timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		point.Time = time.Unix(0, ts*int64(precision)).UTC()
+	}
+	return point, nil
+}
+
+// parseFieldValue разбирает значение поля. Для строки numeric = false.
+func parseFieldValue(value string) (number float64, numeric bool, err error) {
+	switch {
+	case value[0] == '"':
+		if len(value) < 2 || value[len(value)-1] != '"' {
+			return 0, false, errors.New("unterminated string")
+		}
+		return 0, false, nil
+	case value == "t" || value == "T" || value == "true" || value == "True" || value == "TRUE":
+		return 1, true, nil
+	case value == "f" || value == "F" || value == "false" || value == "False" || value == "FALSE":
+		return 0, true, nil
+	case strings.HasSuffix(value, "i"):
+		n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		return float64(n), err == nil, err
+	case strings.HasSuffix(value, "u"):
+		n, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+		return float64(n), err == nil, err
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	return n, err == nil, err
+}
+
+// cutUnescaped делит s по первому неэкранированному sep вне кавычек
+func cutUnescaped(s string, sep byte, quotes bool) (before, after string, found bool) {
+	if i := indexUnescaped(s, sep, quotes); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
+// splitUnescaped делит s по всем неэкранированным sep вне кавычек
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, sep, quotes)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// indexUnescaped ищет sep с учетом экранирования обратной косой чертой
+// и, если quotes, строковых значений в двойных кавычках
+func indexUnescaped(s string, sep byte, quotes bool) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			return i
+		}
+	}
+	return -1
+}
+
+// unescape убирает экранирование из имен и значений меток
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, ="\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,160 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ts := time.Unix(1709294400, 0).UTC()
+
+	tests := []struct {
+		name        string
+		line        string
+		precision   time.Duration
+		measurement string
+		tags        map[string]string
+		fields      map[string]float64
+		time        time.Time
+		wantErr     bool
+	}{
+		{
+			name:        "float field without timestamp",
+			line:        "cpu,host=h1 usage_active=12.5",
+			measurement: "cpu",
+			tags:        map[string]string{"host": "h1"},
+			fields:      map[string]float64{"usage_active": 12.5},
+			time:        now,
+		},
+		{
+			name:        "integer and unsigned suffixes",
+			line:        "net,host=h1 packets=42i,bytes=1024u,rate=-3i 1709294400000000000",
+			measurement: "net",
+			tags:        map[string]string{"host": "h1"},
+			fields:      map[string]float64{"packets": 42, "bytes": 1024, "rate": -3},
+			time:        ts,
+		},
+		{
+			name:        "booleans",
+			line:        "door,host=h1 open=t,locked=FALSE",
+			measurement: "door",
+			tags:        map[string]string{"host": "h1"},
+			fields:      map[string]float64{"open": 1, "locked": 0},
+			time:        now,
+		},
+		{
+			name:        "escaped commas spaces and equals",
+			line:        `my\ cpu\,total,host=rack\ 1\,a,role\=x=db\=main usage\ active=7`,
+			measurement: "my cpu,total",
+			tags:        map[string]string{"host": "rack 1,a", "role=x": "db=main"},
+			fields:      map[string]float64{"usage active": 7},
+			time:        now,
+		},
+		{
+			name:        "quoted string fields are skipped",
+			line:        `syslog,host=h1 message="disk full, retry in 5 s",escaped="say \"hi\"",level=3i 1709294400000000000`,
+			measurement: "syslog",
+			tags:        map[string]string{"host": "h1"},
+			fields:      map[string]float64{"level": 3},
+			time:        ts,
+		},
+		{
+			name:        "seconds precision",
+			line:        "cpu,host=h1 usage=1 1709294400",
+			precision:   time.Second,
+			measurement: "cpu",
+			tags:        map[string]string{"host": "h1"},
+			fields:      map[string]float64{"usage": 1},
+			time:        ts,
+		},
+		{
+			name:        "milliseconds precision",
+			line:        "cpu usage=1 1709294400500",
+			precision:   time.Millisecond,
+			measurement: "cpu",
+			tags:        map[string]string{},
+			fields:      map[string]float64{"usage": 1},
+			time:        ts.Add(500 * time.Millisecond),
+		},
+		{name: "missing fields", line: "cpu,host=h1", wantErr: true},
+		{name: "missing measurement", line: ",host=h1 usage=1", wantErr: true},
+		{name: "tag without value", line: "cpu,host usage=1", wantErr: true},
+		{name: "field without value", line: "cpu usage=", wantErr: true},
+		{name: "unterminated string", line: `cpu message="oops`, wantErr: true},
+		{name: "invalid integer", line: "cpu count=1.5i", wantErr: true},
+		{name: "invalid timestamp", line: "cpu usage=1 yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			precision := tt.precision
+			if precision == 0 {
+				precision = time.Nanosecond
+			}
+			point, err := ParseLine(tt.line, precision, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseLine(%q) = %+v, want error", tt.line, point)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLine(%q): %v", tt.line, err)
+			}
+			if point.Measurement != tt.measurement {
+				t.Errorf("measurement = %q, want %q", point.Measurement, tt.measurement)
+			}
+			if !equalMaps(point.Tags, tt.tags) {
+				t.Errorf("tags = %v, want %v", point.Tags, tt.tags)
+			}
+			if !equalMaps(point.Fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", point.Fields, tt.fields)
+			}
+			if !point.Time.Equal(tt.time) {
+				t.Errorf("time = %s, want %s", point.Time, tt.time)
+			}
+		})
+	}
+}
+
+func equalMaps[V comparable](got, want map[string]V) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for k, v := range want {
+		if g, ok := got[k]; !ok || g != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParsePrecision(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":   time.Nanosecond,
+		"ns": time.Nanosecond,
+		"u":  time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"h":  time.Hour,
+	}
+	for value, want := range tests {
+		if got, err := ParsePrecision(value); err != nil || got != want {
+			t.Errorf("ParsePrecision(%q) = %s, %v; want %s", value, got, err, want)
+		}
+	}
+	if _, err := ParsePrecision("d"); err == nil {
+		t.Error("ParsePrecision accepted unknown precision d")
+	}
+}
+
+func TestParseLinesSkipsBadLines(t *testing.T) {
+	data := "# comment\ncpu,host=h1 usage=1\n\ncpu,host=h2\ncpu,host=h3 usage=3\n"
+	points, errs := ParseLines(data, time.Nanosecond, time.Now())
+	if len(points) != 2 || points[0].Tags["host"] != "h1" || points[1].Tags["host"] != "h3" {
+		t.Errorf("points = %+v, want h1 and h3", points)
+	}
+	if len(errs) != 1 {
+		t.Errorf("errors = %v, want one for line 4", errs)
+	}
+}
@@ -0,0 +1,217 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-service/internal/models"
+	"go-service/pkg/metrics"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+)
+
+// DevicePlaceholder уровень шаблона топика с идентификатором устройства
+const DevicePlaceholder = "{device}"
+
+// mqttQoS уровень доставки подписок: метрика не теряется при обрыве связи
+const mqttQoS = 1
+
+// MQTTConfig настройки подписки на брокер MQTT
+type MQTTConfig struct {
+	Broker         string // Например tcp://localhost:1883
+	ClientID       string // Постоянный, чтобы брокер хранил сессию и QoS 1 сообщения
+	Username       string
+	Password       string
+	Topics         []string // Шаблоны вида devices/{device}/metrics
+	ConnectTimeout time.Duration
+	MaxReconnect   time.Duration // Наибольшая пауза между попытками подключения
+}
+
+// TopicPattern шаблон топика с позицией идентификатора устройства
+type TopicPattern struct {
+	levels      []string
+	deviceLevel int
+}
+
+// ParseTopicPattern разбирает шаблон. Уровень {device} становится
+// подстановкой +, допускаются и обычные подстановки MQTT + и #.
+func ParseTopicPattern(pattern string) (TopicPattern, error) {
+	p := TopicPattern{levels: strings.Split(pattern, "/"), deviceLevel: -1}
+	for i, level := range p.levels {
+		switch {
+		case level == DevicePlaceholder:
+			if p.deviceLevel >= 0 {
+				return TopicPattern{}, fmt.Errorf("topic %q has several %s levels", pattern, DevicePlaceholder)
+			}
+			p.deviceLevel = i
+		case level == "#" && i != len(p.levels)-1:
+			return TopicPattern{}, fmt.Errorf("topic %q: # must be the last level", pattern)
+		case strings.ContainsAny(level, "+#{}") && len(level) > 1:
+			return TopicPattern{}, fmt.Errorf("topic %q: invalid level %q", pattern, level)
+		}
+	}
+	if p.deviceLevel < 0 {
+		return TopicPattern{}, fmt.Errorf("topic %q has no %s level", pattern, DevicePlaceholder)
+	}
+	return p, nil
+}
+
+// Filter возвращает фильтр подписки MQTT
+func (p TopicPattern) Filter() string {
+	levels := append([]string(nil), p.levels...)
+	levels[p.deviceLevel] = "+"
+	return strings.Join(levels, "/")
+}
+
+// DeviceID извлекает идентификатор устройства из топика сообщения
+func (p TopicPattern) DeviceID(topic string) (string, bool) {
+	levels := strings.Split(topic, "/")
+	for i, level := range p.levels {
+		switch {
+		case level == "#":
+			return levels[p.deviceLevel], levels[p.deviceLevel] != ""
+		case i >= len(levels):
+			return "", false
+		case level != "+" && level != DevicePlaceholder && level != levels[i]:
+			return "", false
+		}
+	}
+	if len(levels) != len(p.levels) || levels[p.deviceLevel] == "" {
+		return "", false
+	}
+	return levels[p.deviceLevel], true
+}
+
+// MQTTSubscriber принимает метрики устройств из брокера MQTT. Сообщение
+// подтверждается после обработки, поэтому при падении узла брокер
+// доставит его повторно.
+type MQTTSubscriber struct {
+	client    mqtt.Client
+	patterns  []TopicPattern
+	processor Processor
+	logger    *zap.SugaredLogger
+}
+
+// NewMQTTSubscriber создает подписчика. Подключение начинается в Start.
+func NewMQTTSubscriber(cfg MQTTConfig, processor Processor, logger *zap.SugaredLogger) (*MQTTSubscriber, error) {
+	if cfg.Broker == "" {
+		return nil, errors.New("mqtt broker is required")
+	}
+	if len(cfg.Topics) == 0 {
+		return nil, errors.New("at least one mqtt topic is required")
+	}
+
+	s := &MQTTSubscriber{processor: processor, logger: logger}
+	for _, topic := range cfg.Topics {
+		pattern, err := ParseTopicPattern(topic)
+		if err != nil {
+			return nil, err
+		}
+		s.patterns = append(s.patterns, pattern)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(min(cfg.MaxReconnect, 5*time.Second)).
+		SetMaxReconnectInterval(cfg.MaxReconnect).
+		SetOnConnectHandler(s.onConnect).
+		SetConnectionLostHandler(s.onConnectionLost).
+		SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+			logger.Infof("Reconnecting to MQTT broker %s", cfg.Broker)
+		})
+	s.client = mqtt.NewClient(opts)
+	return s, nil
+}
+
+// Start начинает подключение к брокеру. Пока брокер недоступен, попытки
+// повторяются в фоне, а подписки восстанавливаются после каждого подключения.
+func (s *MQTTSubscriber) Start() {
+	s.client.Connect()
+}
+
+// Close отключается от брокера, давая обработчикам время завершиться
+func (s *MQTTSubscriber) Close() {
+	s.client.Disconnect(250)
+	metrics.SetMQTTConnected(false)
+}
+
+// onConnect подписывается на все шаблоны
+func (s *MQTTSubscriber) onConnect(client mqtt.Client) {
+	metrics.SetMQTTConnected(true)
+
+	filters := make(map[string]byte, len(s.patterns))
+	for _, pattern := range s.patterns {
+		filters[pattern.Filter()] = mqttQoS
+	}
+	token := client.SubscribeMultiple(filters, s.handleMessage)
+	go func() {
+		token.Wait()
+		if err := token.Error(); err != nil {
+			s.logger.Errorf("Failed to subscribe to MQTT topics: %v", err)
+			return
+		}
+		s.logger.Infof("Subscribed to %d MQTT topic filters", len(filters))
+	}()
+}
+
+// onConnectionLost фиксирует обрыв, переподключение выполняет клиент
+func (s *MQTTSubscriber) onConnectionLost(client mqtt.Client, err error) {
+	metrics.SetMQTTConnected(false)
+	s.logger.Warnf("MQTT connection lost: %v", err)
+}
+
+// handleMessage разбирает и обрабатывает сообщение. Неразбираемые
+// сообщения подтверждаются: повторная доставка их не исправит.
+func (s *MQTTSubscriber) handleMessage(client mqtt.Client, msg mqtt.Message) {
+	metric, err := s.decode(msg.Topic(), msg.Payload())
+	if err != nil {
+		metrics.RecordIngestedMetrics("mqtt", "invalid", 1)
+		s.logger.Warnf("Dropped MQTT message on %s: %v", msg.Topic(), err)
+		msg.Ack()
+		return
+	}
+
+	if err := Process(context.Background(), s.processor, "mqtt", metric); err != nil {
+		// Без подтверждения брокер доставит сообщение снова после переподключения
+		s.logger.Errorf("Failed to process MQTT metric for device %s: %v", metric.DeviceID, err)
+		return
+	}
+	msg.Ack()
+}
+
+// decode разбирает JSON-метрику. Устройство определяется топиком.
+func (s *MQTTSubscriber) decode(topic string, payload []byte) (models.Metric, error) {
+	var deviceID string
+	for _, pattern := range s.patterns {
+		if id, ok := pattern.DeviceID(topic); ok {
+			deviceID = id
+			break
+		}
+	}
+	if deviceID == "" {
+		return models.Metric{}, errors.New("topic does not match any pattern")
+	}
+
+	var metric models.Metric
+	if err := json.Unmarshal(payload, &metric); err != nil {
+		return models.Metric{}, err
+	}
+	metric.DeviceID = deviceID
+	if metric.RPS < 0 {
+		return models.Metric{}, errors.New("rps must not be negative")
+	}
+	return metric, nil
+}
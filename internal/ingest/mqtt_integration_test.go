@@ -0,0 +1,234 @@
+//go:build integration
+
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-service/internal/models"
+	"go-service/pkg/metrics"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+)
+
+// Тесты с брокером MQTT, например mosquitto:
+//
+//	docker run -d -p 1883:1883 eclipse-mosquitto:2 mosquitto -c /mosquitto-no-auth.conf
+//	MQTT_TEST_BROKER=tcp://localhost:1883 go test -tags integration ./internal/ingest -run MQTT
+
+// testBroker адрес брокера из MQTT_TEST_BROKER
+func testBroker(t *testing.T) string {
+	t.Helper()
+	broker := os.Getenv("MQTT_TEST_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_TEST_BROKER is not set")
+	}
+	return broker
+}
+
+// recordingProcessor запоминает метрики и отклоняет первые failures вызовов
+type recordingProcessor struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	received chan models.Metric
+}
+
+func newRecordingProcessor(failures int) *recordingProcessor {
+	return &recordingProcessor{failures: failures, received: make(chan models.Metric, 100)}
+}
+
+func (p *recordingProcessor) ProcessMetric(ctx context.Context, metric models.Metric) (*models.AnalyticsResult, error) {
+	p.mu.Lock()
+	p.calls++
+	fail := p.calls <= p.failures
+	p.mu.Unlock()
+	if fail {
+		return nil, errors.New("storage unavailable")
+	}
+	p.received <- metric
+	return &models.AnalyticsResult{DeviceID: metric.DeviceID}, nil
+}
+
+func (p *recordingProcessor) wait(t *testing.T, device string) models.Metric {
+	t.Helper()
+	for {
+		select {
+		case metric := <-p.received:
+			if metric.DeviceID == device {
+				return metric
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("metric of device %s was not processed", device)
+		}
+	}
+}
+
+// proxy TCP-посредник до брокера, обрывающий соединения по команде
+type proxy struct {
+	listener net.Listener
+	target   string
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newProxy(t *testing.T, broker string) *proxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxy{listener: listener, target: strings.TrimPrefix(broker, "tcp://")}
+	go p.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		p.drop()
+	})
+	return p
+}
+
+func (p *proxy) addr() string {
+	return "tcp://" + p.listener.Addr().String()
+}
+
+func (p *proxy) serve() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+		p.mu.Lock()
+		p.conns = append(p.conns, client, server)
+		p.mu.Unlock()
+		go io.Copy(server, client)
+		go io.Copy(client, server)
+	}
+}
+
+// drop обрывает все соединения, как при сбое сети
+func (p *proxy) drop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
+
+func newTestSubscriber(t *testing.T, broker, clientID, topic string, processor Processor) *MQTTSubscriber {
+	t.Helper()
+	metrics.InitMetrics()
+	s, err := NewMQTTSubscriber(MQTTConfig{
+		Broker:         broker,
+		ClientID:       clientID,
+		Topics:         []string{topic + "/{device}/metrics"},
+		ConnectTimeout: 5 * time.Second,
+		MaxReconnect:   time.Second,
+	}, processor, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// waitConnected ждет подключения и подписки подписчика
+func waitConnected(t *testing.T, s *MQTTSubscriber) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !s.client.IsConnectionOpen() {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber did not connect")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// Подписка отправляется из onConnect асинхронно
+	time.Sleep(200 * time.Millisecond)
+}
+
+func publish(t *testing.T, broker, topic, payload string) {
+	t.Helper()
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker).SetClientID(fmt.Sprintf("go-service-test-pub-%d", time.Now().UnixNano())))
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer client.Disconnect(100)
+	if token := client.Publish(topic, mqttQoS, false, payload); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+}
+
+func TestMQTTRedeliversAfterProcessingError(t *testing.T) {
+	broker := testBroker(t)
+	topic := fmt.Sprintf("go-service-test/%d", time.Now().UnixNano())
+	clientID := fmt.Sprintf("go-service-test-%d", time.Now().UnixNano())
+	proxy := newProxy(t, broker)
+
+	processor := newRecordingProcessor(1)
+	s := newTestSubscriber(t, proxy.addr(), clientID, topic, processor)
+	s.Start()
+	defer s.Close()
+	waitConnected(t, s)
+
+	publish(t, broker, topic+"/d1/metrics", `{"rps": 1, "message_id": "m1"}`)
+
+	// Первая обработка падает, сообщение остается неподтвержденным, и после
+	// переподключения постоянной сессии брокер доставляет его снова
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		processor.mu.Lock()
+		calls := processor.calls
+		processor.mu.Unlock()
+		if calls >= 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message was not delivered")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	proxy.drop()
+
+	metric := processor.wait(t, "d1")
+	if metric.MessageID != "m1" {
+		t.Errorf("redelivered message_id = %q, want m1", metric.MessageID)
+	}
+}
+
+func TestMQTTResubscribesOnReconnect(t *testing.T) {
+	broker := testBroker(t)
+	topic := fmt.Sprintf("go-service-test/%d", time.Now().UnixNano())
+	clientID := fmt.Sprintf("go-service-test-%d", time.Now().UnixNano())
+	proxy := newProxy(t, broker)
+
+	processor := newRecordingProcessor(0)
+	s := newTestSubscriber(t, proxy.addr(), clientID, topic, processor)
+	s.Start()
+	defer s.Close()
+	waitConnected(t, s)
+
+	publish(t, broker, topic+"/d1/metrics", `{"rps": 1}`)
+	processor.wait(t, "d1")
+
+	proxy.drop()
+	time.Sleep(100 * time.Millisecond)
+	waitConnected(t, s)
+
+	publish(t, broker, topic+"/d2/metrics", `{"rps": 2}`)
+	processor.wait(t, "d2")
+}
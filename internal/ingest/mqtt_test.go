@@ -0,0 +1,120 @@
+package ingest
+
+import (
+	"testing"
+)
+
+func TestParseTopicPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		filter  string
+		wantErr bool
+	}{
+		{"devices/{device}/metrics", "devices/+/metrics", false},
+		{"{device}", "+", false},
+		{"site/+/{device}/#", "site/+/+/#", false},
+		{"devices/{device}/#", "devices/+/#", false},
+		{"devices/+/metrics", "", true},
+		{"devices/{device}/{device}", "", true},
+		{"devices/#/{device}", "", true},
+		{"devices/{device}/metrics+", "", true},
+		{"devices/dev{device}", "", true},
+		{"devices/{device}/a#", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			p, err := ParseTopicPattern(tt.pattern)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseTopicPattern(%q) accepted, filter %q", tt.pattern, p.Filter())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTopicPattern(%q): %v", tt.pattern, err)
+			}
+			if got := p.Filter(); got != tt.filter {
+				t.Errorf("Filter() = %q, want %q", got, tt.filter)
+			}
+		})
+	}
+}
+
+func TestTopicPatternDeviceID(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		device  string
+		ok      bool
+	}{
+		{"devices/{device}/metrics", "devices/d1/metrics", "d1", true},
+		{"devices/{device}/metrics", "devices/d1/status", "", false},
+		{"devices/{device}/metrics", "devices/d1/metrics/extra", "", false},
+		{"devices/{device}/metrics", "devices/d1", "", false},
+		{"devices/{device}/metrics", "devices//metrics", "", false},
+		{"devices/{device}/metrics", "sensors/d1/metrics", "", false},
+		{"site/+/{device}", "site/eu/d2", "d2", true},
+		{"site/+/{device}", "site/eu", "", false},
+		{"devices/{device}/#", "devices/d3/metrics/cpu", "d3", true},
+		{"devices/{device}/#", "devices/d3", "d3", true},
+		{"devices/{device}/#", "devices/", "", false},
+		{"devices/{device}/#", "other/d3/metrics", "", false},
+		{"{device}", "d4", "d4", true},
+		{"{device}", "d4/metrics", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.topic, func(t *testing.T) {
+			p, err := ParseTopicPattern(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			device, ok := p.DeviceID(tt.topic)
+			if device != tt.device || ok != tt.ok {
+				t.Errorf("DeviceID(%q) = %q, %v, want %q, %v", tt.topic, device, ok, tt.device, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMQTTDecode(t *testing.T) {
+	s := &MQTTSubscriber{}
+	for _, topic := range []string{"devices/{device}/metrics", "site/+/{device}/#"} {
+		p, err := ParseTopicPattern(topic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.patterns = append(s.patterns, p)
+	}
+
+	tests := []struct {
+		name    string
+		topic   string
+		payload string
+		device  string
+		wantErr bool
+	}{
+		{"first pattern", "devices/d1/metrics", `{"cpu": 10, "rps": 5}`, "d1", false},
+		{"second pattern", "site/eu/d2/telemetry", `{"rps": 1}`, "d2", false},
+		{"topic overrides payload device", "devices/d1/metrics", `{"device_id": "other", "rps": 1}`, "d1", false},
+		{"unmatched topic", "other/d1", `{"rps": 1}`, "", true},
+		{"invalid json", "devices/d1/metrics", `{"rps":`, "", true},
+		{"negative rps", "devices/d1/metrics", `{"rps": -1}`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, err := s.decode(tt.topic, []byte(tt.payload))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decode accepted %q on %q", tt.payload, tt.topic)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if metric.DeviceID != tt.device {
+				t.Errorf("device = %q, want %q", metric.DeviceID, tt.device)
+			}
+		})
+	}
+}
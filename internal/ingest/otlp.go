@@ -0,0 +1,181 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"go-service/internal/models"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// MaxOTLPSize предел размера запроса OTLP после распаковки
+const MaxOTLPSize = 32 << 20
+
+// Кодировки OTLP/HTTP
+const (
+	OTLPProtobuf = "application/x-protobuf"
+	OTLPJSON     = "application/json"
+)
+
+// ErrUnsupportedEncoding возвращается для кодировки, отличной от protobuf и JSON
+var ErrUnsupportedEncoding = errors.New("unsupported OTLP encoding")
+
+// OTLPRequest запрос экспорта метрик OTLP
+type OTLPRequest = colmetricspb.ExportMetricsServiceRequest
+
+// DecodeOTLP разбирает запрос экспорта метрик в кодировке encoding
+func DecodeOTLP(data []byte, encoding string) (*OTLPRequest, error) {
+	req := &OTLPRequest{}
+	var err error
+	switch encoding {
+	case OTLPProtobuf:
+		err = proto.Unmarshal(data, req)
+	case OTLPJSON:
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, req)
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid export request: %w", err)
+	}
+	return req, nil
+}
+
+// EncodeOTLPResponse кодирует ответ на экспорт. rejected и message
+// заполняют partial_success, если часть точек не принята.
+func EncodeOTLPResponse(encoding string, rejected int64, message string) ([]byte, error) {
+	resp := &colmetricspb.ExportMetricsServiceResponse{}
+	if rejected > 0 || message != "" {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: rejected,
+			ErrorMessage:       message,
+		}
+	}
+	if encoding == OTLPJSON {
+		return protojson.Marshal(resp)
+	}
+	return proto.Marshal(resp)
+}
+
+// OTLPMapping сопоставляет метрики OpenTelemetry полям метрик устройств
+type OTLPMapping struct {
+	// Атрибуты ресурса с идентификатором устройства, проверяются по порядку
+	DeviceAttributes []string
+	// Имя метрики OTLP -> поле models.Metric, основное или дополнительное.
+	// Без сопоставления принимаются только метрики с именем основного поля.
+	Fields map[string]string
+}
+
+// Validate проверяет имена полей сопоставления
+func (m OTLPMapping) Validate() error {
+	if len(m.DeviceAttributes) == 0 {
+		return errors.New("at least one device attribute is required")
+	}
+	for name, field := range m.Fields {
+		if !models.ValidFieldName(field) {
+			return fmt.Errorf("metric %s mapped to invalid field %q", name, field)
+		}
+	}
+	return nil
+}
+
+// field возвращает поле метрики для имени метрики OTLP
+func (m OTLPMapping) field(name string) (string, bool) {
+	if field, ok := m.Fields[name]; ok {
+		return field, true
+	}
+	return name, models.IsLegacyField(name)
+}
+
+// device возвращает идентификатор устройства из атрибутов ресурса
+func (m OTLPMapping) device(attributes []*commonpb.KeyValue) string {
+	for _, key := range m.DeviceAttributes {
+		for _, attr := range attributes {
+			if attr.GetKey() == key && attr.GetValue().GetStringValue() != "" {
+				return attr.GetValue().GetStringValue()
+			}
+		}
+	}
+	return ""
+}
+
+// Metrics собирает метрики устройств из точек gauge и sum. Значения одного
+// устройства с одинаковым временем объединяются в одну метрику. Sum
+// передается как есть, поэтому для rps экспортеру стоит использовать
+// delta-временность или gauge. Гистограммы и сводки не сопоставляются.
+func (m OTLPMapping) Metrics(req *OTLPRequest, now time.Time) ([]models.Metric, Stats) {
+	g := newGrouper("otlp")
+	for _, rm := range req.GetResourceMetrics() {
+		device := m.device(rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, metric := range sm.GetMetrics() {
+				var points []*metricspb.NumberDataPoint
+				switch data := metric.GetData().(type) {
+				case *metricspb.Metric_Gauge:
+					points = data.Gauge.GetDataPoints()
+				case *metricspb.Metric_Sum:
+					points = data.Sum.GetDataPoints()
+				default:
+					g.stats.Unmapped += dataPointCount(metric)
+					continue
+				}
+
+				field, ok := m.field(metric.GetName())
+				if device == "" || !ok {
+					g.stats.Unmapped += len(points)
+					continue
+				}
+				for _, point := range points {
+					value, ok := pointValue(point)
+					if !ok {
+						g.stats.Unmapped++
+						continue
+					}
+					ts := now
+					if point.GetTimeUnixNano() > 0 {
+						ts = time.Unix(0, int64(point.GetTimeUnixNano())).UTC()
+					}
+					g.add(device, ts, field, value)
+				}
+			}
+		}
+	}
+	return g.metrics()
+}
+
+// pointValue возвращает значение точки. Точки без значения и с флагом
+// отсутствия данных пропускаются.
+func pointValue(point *metricspb.NumberDataPoint) (float64, bool) {
+	if point.GetFlags()&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0 {
+		return 0, false
+	}
+	switch v := point.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		return v.AsDouble, !math.IsNaN(v.AsDouble)
+	case *metricspb.NumberDataPoint_AsInt:
+		return float64(v.AsInt), true
+	default:
+		return 0, false
+	}
+}
+
+// dataPointCount число точек метрики несопоставляемого типа
+func dataPointCount(metric *metricspb.Metric) int {
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Histogram:
+		return len(data.Histogram.GetDataPoints())
+	case *metricspb.Metric_ExponentialHistogram:
+		return len(data.ExponentialHistogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		return len(data.Summary.GetDataPoints())
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,197 @@
+package ingest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var otlpTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// otlpRequest запрос экспорта с одним ресурсом и его метриками
+func otlpRequest(attributes map[string]string, metrics ...*metricspb.Metric) *OTLPRequest {
+	resource := &resourcepb.Resource{}
+	for key, value := range attributes {
+		resource.Attributes = append(resource.Attributes, &commonpb.KeyValue{
+			Key:   key,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+		})
+	}
+	return &OTLPRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource:     resource,
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func doublePoint(value float64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		TimeUnixNano: uint64(otlpTime.UnixNano()),
+		Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+	}
+}
+
+func intPoint(value int64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		TimeUnixNano: uint64(otlpTime.UnixNano()),
+		Value:        &metricspb.NumberDataPoint_AsInt{AsInt: value},
+	}
+}
+
+func gauge(name string, points ...*metricspb.NumberDataPoint) *metricspb.Metric {
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: points}}}
+}
+
+func sum(name string, points ...*metricspb.NumberDataPoint) *metricspb.Metric {
+	return &metricspb.Metric{Name: name, Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+		DataPoints:             points,
+		AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+		IsMonotonic:            true,
+	}}}
+}
+
+func TestDecodeOTLP(t *testing.T) {
+	want := otlpRequest(map[string]string{"host.name": "h1"}, gauge("rps", doublePoint(12.5)))
+	data, err := proto.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeOTLP(data, OTLPProtobuf)
+	if err != nil {
+		t.Fatalf("DecodeOTLP protobuf: %v", err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("DecodeOTLP protobuf = %v, want %v", got, want)
+	}
+
+	// Запрос в OTLP/JSON, как его отправляет OpenTelemetry Collector
+	jsonData := `{"resourceMetrics":[{"resource":{"attributes":[{"key":"host.name","value":{"stringValue":"h1"}}]},
+		"scopeMetrics":[{"scope":{"name":"otelcol/hostmetricsreceiver"},"metrics":[{"name":"rps","unit":"1",
+		"gauge":{"dataPoints":[{"timeUnixNano":"1709294400000000000","asDouble":12.5}]}}]}]}]}`
+	got, err = DecodeOTLP([]byte(jsonData), OTLPJSON)
+	if err != nil {
+		t.Fatalf("DecodeOTLP JSON: %v", err)
+	}
+	batch, _ := OTLPMapping{DeviceAttributes: []string{"host.name"}}.Metrics(got, time.Now())
+	if len(batch) != 1 || batch[0].DeviceID != "h1" || batch[0].RPS != 12.5 || !batch[0].Timestamp.Equal(otlpTime) {
+		t.Errorf("metrics from JSON request = %+v", batch)
+	}
+
+	if _, err := DecodeOTLP(data, "text/plain"); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("DecodeOTLP text/plain: %v, want ErrUnsupportedEncoding", err)
+	}
+	if _, err := DecodeOTLP([]byte("{not json"), OTLPJSON); err == nil {
+		t.Error("DecodeOTLP accepted malformed JSON")
+	}
+}
+
+func TestOTLPMetrics(t *testing.T) {
+	mapping := OTLPMapping{
+		DeviceAttributes: []string{"device.id", "host.name"},
+		Fields: map[string]string{
+			"http.server.request_rate": "rps",
+			"system.cpu.utilization":   "cpu",
+			"sensor.temperature":       "temperature",
+		},
+	}
+	host := map[string]string{"host.name": "h1"}
+	histogram := &metricspb.Metric{Name: "http.server.duration", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+		DataPoints: []*metricspb.HistogramDataPoint{{TimeUnixNano: uint64(otlpTime.UnixNano()), Count: 3}, {Count: 1}},
+	}}}
+	summary := &metricspb.Metric{Name: "rps", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+		DataPoints: []*metricspb.SummaryDataPoint{{Count: 1}},
+	}}}
+	exponential := &metricspb.Metric{Name: "rps", Data: &metricspb.Metric_ExponentialHistogram{ExponentialHistogram: &metricspb.ExponentialHistogram{
+		DataPoints: []*metricspb.ExponentialHistogramDataPoint{{Count: 1}},
+	}}}
+	noValue := doublePoint(1)
+	noValue.Flags = uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK)
+
+	tests := []struct {
+		name   string
+		req    *OTLPRequest
+		want   map[string]map[string]float64 // Устройство -> поле -> значение
+		unmap  int
+		incomp int
+	}{
+		{
+			name: "gauge",
+			req:  otlpRequest(host, gauge("rps", doublePoint(12.5))),
+			want: map[string]map[string]float64{"h1": {"rps": 12.5}},
+		},
+		{
+			name: "int sum mapped by name",
+			req:  otlpRequest(host, sum("http.server.request_rate", intPoint(40))),
+			want: map[string]map[string]float64{"h1": {"rps": 40}},
+		},
+		{
+			name: "gauge and sum merged",
+			req:  otlpRequest(host, sum("rps", intPoint(40)), gauge("system.cpu.utilization", doublePoint(0.5)), gauge("sensor.temperature", doublePoint(21))),
+			want: map[string]map[string]float64{"h1": {"rps": 40, "cpu": 0.5, "temperature": 21}},
+		},
+		{
+			name: "device attribute order",
+			req:  otlpRequest(map[string]string{"host.name": "h1", "device.id": "d1"}, gauge("rps", doublePoint(1))),
+			want: map[string]map[string]float64{"d1": {"rps": 1}},
+		},
+		{
+			name:  "no device attribute",
+			req:   otlpRequest(map[string]string{"service.name": "api"}, gauge("rps", doublePoint(1), doublePoint(2))),
+			unmap: 2,
+		},
+		{
+			name:  "unknown metric",
+			req:   otlpRequest(host, gauge("process.threads", doublePoint(8))),
+			unmap: 1,
+		},
+		{
+			name:  "histogram rejected",
+			req:   otlpRequest(host, histogram),
+			unmap: 2,
+		},
+		{
+			name:  "summary and exponential histogram rejected",
+			req:   otlpRequest(host, summary, exponential),
+			unmap: 2,
+		},
+		{
+			name:  "no recorded value",
+			req:   otlpRequest(host, gauge("rps", noValue)),
+			unmap: 1,
+		},
+		{
+			name:   "legacy fields without rps",
+			req:    otlpRequest(host, gauge("system.cpu.utilization", doublePoint(0.5))),
+			incomp: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, stats := mapping.Metrics(tt.req, time.Now())
+			if stats.Unmapped != tt.unmap || stats.Incomplete != tt.incomp {
+				t.Errorf("stats = %+v, want unmapped %d, incomplete %d", stats, tt.unmap, tt.incomp)
+			}
+			if len(batch) != len(tt.want) {
+				t.Fatalf("got %d metrics, want %d: %+v", len(batch), len(tt.want), batch)
+			}
+			for _, m := range batch {
+				fields, ok := tt.want[m.DeviceID]
+				if !ok {
+					t.Fatalf("unexpected device %q", m.DeviceID)
+				}
+				if !m.Timestamp.Equal(otlpTime) {
+					t.Errorf("timestamp = %s, want %s", m.Timestamp, otlpTime)
+				}
+				for name, want := range fields {
+					if got, ok := m.Field(name); !ok || got != want {
+						t.Errorf("%s = %v (present %v), want %v", name, got, ok, want)
+					}
+				}
+			}
+		})
+	}
+}
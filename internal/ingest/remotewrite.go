@@ -0,0 +1,213 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"go-service/internal/models"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MaxRemoteWriteSize предел размера распакованного запроса remote_write
+const MaxRemoteWriteSize = 32 << 20
+
+// Label метка временного ряда Prometheus
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample значение ряда с временем в миллисекундах
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// TimeSeries временной ряд из запроса remote_write
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Label возвращает значение метки по имени
+func (ts TimeSeries) Label(name string) string {
+	for _, label := range ts.Labels {
+		if label.Name == name {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+// DecodeRemoteWrite распаковывает snappy и разбирает prometheus.WriteRequest.
+// Читаются только ряды с метками и значениями, метаданные, экземпляры и
+// гистограммы пропускаются.
+func DecodeRemoteWrite(compressed []byte) ([]TimeSeries, error) {
+	size, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+	if size > MaxRemoteWriteSize {
+		return nil, fmt.Errorf("decoded payload of %d bytes exceeds %d", size, MaxRemoteWriteSize)
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy payload: %w", err)
+	}
+
+	var series []TimeSeries
+	err = walkMessage(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		ts, err := decodeTimeSeries(value)
+		if err != nil {
+			return err
+		}
+		series = append(series, ts)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid write request: %w", err)
+	}
+	return series, nil
+}
+
+// decodeTimeSeries разбирает prometheus.TimeSeries
+func decodeTimeSeries(data []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	err := walkMessage(data, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			var label Label
+			err := walkMessage(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				if typ != protowire.BytesType {
+					return nil
+				}
+				switch num {
+				case 1:
+					label.Name = string(value)
+				case 2:
+					label.Value = string(value)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, label)
+		case 2:
+			var sample Sample
+			err := walkMessage(value, func(num protowire.Number, typ protowire.Type, value []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					v, _ := protowire.ConsumeFixed64(value)
+					sample.Value = math.Float64frombits(v)
+				case num == 2 && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(value)
+					sample.Timestamp = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, sample)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+// walkMessage перебирает поля protobuf-сообщения. Для BytesType value
+// содержит само значение, для остальных типов — его необработанную запись.
+func walkMessage(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		if typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value, data = v, data[n:]
+		} else {
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			value, data = data[:n], data[n:]
+		}
+
+		if err := fn(num, typ, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoteWriteMapping сопоставляет ряды Prometheus полям метрик устройств
+type RemoteWriteMapping struct {
+	DeviceLabel string            // Метка с идентификатором устройства
+	Fields      map[string]string // Имя метрики Prometheus -> поле models.Metric
+}
+
+// ErrNoDeviceLabel возвращается для пустого имени метки устройства
+var ErrNoDeviceLabel = errors.New("device label is required")
+
+// Validate проверяет имена полей сопоставления
+func (m RemoteWriteMapping) Validate() error {
+	if m.DeviceLabel == "" {
+		return ErrNoDeviceLabel
+	}
+	for name, field := range m.Fields {
+		if !models.ValidFieldName(field) {
+			return fmt.Errorf("metric %s mapped to invalid field %q", name, field)
+		}
+	}
+	return nil
+}
+
+// field возвращает поле метрики для имени ряда. Без явного сопоставления
+// имя ряда должно совпадать с основным полем.
+func (m RemoteWriteMapping) field(name string) (string, bool) {
+	if field, ok := m.Fields[name]; ok {
+		return field, true
+	}
+	return name, models.IsLegacyField(name)
+}
+
+// Metrics собирает метрики устройств из рядов, значения одного устройства
+// с одинаковым временем объединяются в одну метрику, как при одном scrape
+func (m RemoteWriteMapping) Metrics(series []TimeSeries) ([]models.Metric, Stats) {
+	g := newGrouper("remote_write")
+	for _, ts := range series {
+		device := ts.Label(m.DeviceLabel)
+		field, ok := m.field(ts.Label("__name__"))
+		if device == "" || !ok {
+			g.stats.Unmapped += len(ts.Samples)
+			continue
+		}
+
+		for _, sample := range ts.Samples {
+			if math.IsNaN(sample.Value) {
+				// Stale-маркеры Prometheus приходят как NaN
+				continue
+			}
+			g.add(device, time.UnixMilli(sample.Timestamp).UTC(), field, sample.Value)
+		}
+	}
+	return g.metrics()
+}
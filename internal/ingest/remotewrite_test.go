@@ -0,0 +1,111 @@
+package ingest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// encodeRemoteWrite кодирует ряды как prometheus.WriteRequest со snappy
+func encodeRemoteWrite(series []TimeSeries) []byte {
+	var req []byte
+	for _, ts := range series {
+		var msg []byte
+		for _, label := range ts.Labels {
+			var l []byte
+			l = protowire.AppendTag(l, 1, protowire.BytesType)
+			l = protowire.AppendString(l, label.Name)
+			l = protowire.AppendTag(l, 2, protowire.BytesType)
+			l = protowire.AppendString(l, label.Value)
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, l)
+		}
+		for _, sample := range ts.Samples {
+			var s []byte
+			s = protowire.AppendTag(s, 1, protowire.Fixed64Type)
+			s = protowire.AppendFixed64(s, math.Float64bits(sample.Value))
+			s = protowire.AppendTag(s, 2, protowire.VarintType)
+			s = protowire.AppendVarint(s, uint64(sample.Timestamp))
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, s)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, msg)
+	}
+	return snappy.Encode(nil, req)
+}
+
+// series ряд устройства device с одним значением метрики name
+func series(name, device string, value float64, ts int64) TimeSeries {
+	return TimeSeries{
+		Labels:  []Label{{Name: "__name__", Value: name}, {Name: "device", Value: device}},
+		Samples: []Sample{{Value: value, Timestamp: ts}},
+	}
+}
+
+func TestDecodeRemoteWrite(t *testing.T) {
+	want := []TimeSeries{series("rps", "sensor-1", 12.5, 1700000000000), series("cpu", "sensor-2", 40, 1700000001000)}
+	got, err := DecodeRemoteWrite(encodeRemoteWrite(want))
+	if err != nil {
+		t.Fatalf("DecodeRemoteWrite() error = %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("DecodeRemoteWrite() = %d series, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Label("__name__") != want[i].Label("__name__") || got[i].Label("device") != want[i].Label("device") ||
+			len(got[i].Samples) != 1 || got[i].Samples[0] != want[i].Samples[0] {
+			t.Errorf("series %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := DecodeRemoteWrite([]byte("not snappy")); err == nil {
+		t.Error("DecodeRemoteWrite() of invalid payload error = nil")
+	}
+}
+
+func TestRemoteWriteSplitSeries(t *testing.T) {
+	mapping := RemoteWriteMapping{DeviceLabel: "device", Fields: map[string]string{"node_temperature": "temperature"}}
+	const ts = 1700000000000
+
+	// Prometheus разносит ряды одного scrape по запросам
+	first, _ := mapping.Metrics([]TimeSeries{series("rps", "sensor-1", 10, ts), series("cpu", "sensor-1", 40, ts)})
+	second, _ := mapping.Metrics([]TimeSeries{series("rps", "sensor-1", 10, ts), series("node_temperature", "sensor-1", 21.5, ts)})
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("got %d and %d metrics, want 1 and 1", len(first), len(second))
+	}
+	if first[0].MessageID == second[0].MessageID {
+		t.Errorf("parts of one scrape share message id %s", first[0].MessageID)
+	}
+
+	// Повтор той же части дает тот же идентификатор
+	retry, _ := mapping.Metrics([]TimeSeries{series("cpu", "sensor-1", 40, ts), series("rps", "sensor-1", 10, ts)})
+	if len(retry) != 1 || retry[0].MessageID != first[0].MessageID {
+		t.Errorf("retry message id = %v, want %s", retry, first[0].MessageID)
+	}
+
+	// Другое устройство в тот же момент не совпадает с первым
+	other, _ := mapping.Metrics([]TimeSeries{series("rps", "sensor-2", 10, ts), series("cpu", "sensor-2", 40, ts)})
+	if len(other) != 1 || other[0].MessageID == first[0].MessageID {
+		t.Errorf("other device message id = %v, want different from %s", other, first[0].MessageID)
+	}
+}
+
+func TestRemoteWriteIncomplete(t *testing.T) {
+	mapping := RemoteWriteMapping{DeviceLabel: "device"}
+	batch, stats := mapping.Metrics([]TimeSeries{
+		series("cpu", "sensor-1", 40, 1000),
+		series("memory", "sensor-1", 60, 1000),
+		series("rps", "sensor-2", 5, 1000),
+		series("go_goroutines", "sensor-2", 12, 1000),
+		{Labels: []Label{{Name: "__name__", Value: "rps"}}, Samples: []Sample{{Value: 1, Timestamp: 1000}}},
+	})
+	if len(batch) != 1 || batch[0].DeviceID != "sensor-2" {
+		t.Fatalf("Metrics() = %+v, want only sensor-2", batch)
+	}
+	if stats.Incomplete != 2 || stats.Unmapped != 2 {
+		t.Errorf("stats = %+v, want 2 incomplete values and 2 unmapped", stats)
+	}
+}
@@ -0,0 +1,357 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-service/internal/cache"
+	"go-service/internal/models"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// streamRetryDelay пауза после ошибки чтения потока
+const streamRetryDelay = time.Second
+
+// MinClaimIdle наименьший ClaimIdle: Redis считает простой в миллисекундах,
+// а записи проверяются каждые ClaimIdle/2
+const MinClaimIdle = 2 * time.Millisecond
+
+// StreamConfig настройки чтения потока Redis группой потребителей
+type StreamConfig struct {
+	Stream        string
+	Group         string
+	Consumer      string // Постоянное имя узла, чтобы после перезапуска дочитать свои записи
+	BatchSize     int64
+	Block         time.Duration // Ожидание новых записей в одном XREADGROUP
+	ClaimIdle     time.Duration // Записи, не подтвержденные дольше, забираются у других потребителей
+	MaxDeliveries int64         // После стольких доставок запись уходит в <stream>:dead
+}
+
+// DeadLetterStream поток для записей, которые не удалось обработать
+func (c StreamConfig) DeadLetterStream() string {
+	return c.Stream + ":dead"
+}
+
+// Validate проверяет настройки
+func (c StreamConfig) Validate() error {
+	switch {
+	case c.Stream == "" || c.Group == "" || c.Consumer == "":
+		return errors.New("stream, group and consumer are required")
+	case c.BatchSize <= 0:
+		return errors.New("batch size must be positive")
+	case c.Block <= 0:
+		return errors.New("block must be positive")
+	case c.ClaimIdle < MinClaimIdle:
+		return fmt.Errorf("claim idle must be at least %s, got %s", MinClaimIdle, c.ClaimIdle)
+	case c.MaxDeliveries <= 0:
+		return errors.New("max deliveries must be positive")
+	}
+	return nil
+}
+
+// StreamConsumer читает метрики из потока Redis в составе группы
+// потребителей. Запись подтверждается после обработки; записи упавших
+// потребителей забираются через ClaimIdle, а после MaxDeliveries попыток
+// переносятся в поток недоставленных.
+//
+// Запись содержит JSON-метрику в поле metric либо плоские поля device_id,
+// timestamp, message_id и числовые поля метрики, основные или
+// дополнительные. Нечисловые посторонние поля пропускаются. Без message_id
+// ключом дедупликации служит идентификатор записи, поэтому повторная
+// доставка не учитывается в окне дважды.
+type StreamConsumer struct {
+	client    *cache.RedisClient
+	cfg       StreamConfig
+	processor Processor
+	logger    *zap.SugaredLogger
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewStreamConsumer создает потребителя. Чтение начинается в Start.
+func NewStreamConsumer(client *cache.RedisClient, cfg StreamConfig, processor Processor, logger *zap.SugaredLogger) (*StreamConsumer, error) {
+	if client == nil {
+		return nil, errors.New("redis client is required")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &StreamConsumer{client: client, cfg: cfg, processor: processor, logger: logger}, nil
+}
+
+// Start запускает чтение и фоновый возврат зависших записей
+func (s *StreamConsumer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		s.readLoop(ctx)
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.claimLoop(ctx)
+	}()
+}
+
+// Close останавливает чтение и ждет завершения текущего пакета.
+// Неподтвержденные записи останутся в группе и будут дочитаны позже.
+func (s *StreamConsumer) Close() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+// readLoop сначала дочитывает записи, доставленные этому потребителю до
+// перезапуска, затем читает новые
+func (s *StreamConsumer) readLoop(ctx context.Context) {
+	s.ensureGroup(ctx)
+
+	pendingFrom := "0"
+	for ctx.Err() == nil {
+		id := ">"
+		if pendingFrom != "" {
+			id = pendingFrom
+		}
+
+		messages, err := s.client.ReadGroup(ctx, s.cfg.Stream, s.cfg.Group, s.cfg.Consumer, id, s.cfg.BatchSize, s.cfg.Block)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Errorf("Failed to read stream %s: %v", s.cfg.Stream, err)
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				// Поток или группа удалены: создаем заново
+				s.ensureGroup(ctx)
+			}
+			sleep(ctx, streamRetryDelay)
+			continue
+		}
+
+		if pendingFrom != "" {
+			if len(messages) == 0 {
+				pendingFrom = ""
+				continue
+			}
+			// Неудавшиеся записи остаются в группе, поэтому продолжаем после последней
+			pendingFrom = messages[len(messages)-1].ID
+		}
+		s.handle(ctx, messages)
+	}
+}
+
+// ensureGroup создает группу, повторяя попытки, пока Redis недоступен
+func (s *StreamConsumer) ensureGroup(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := s.client.CreateGroup(ctx, s.cfg.Stream, s.cfg.Group)
+		if err == nil {
+			return
+		}
+		s.logger.Errorf("Failed to create consumer group %s on stream %s: %v", s.cfg.Group, s.cfg.Stream, err)
+		sleep(ctx, streamRetryDelay)
+	}
+}
+
+// handle обрабатывает пакет и подтверждает обработанные записи.
+// Неразбираемые записи подтверждаются сразу: повторная доставка их не исправит.
+func (s *StreamConsumer) handle(ctx context.Context, messages []cache.StreamMessage) {
+	// Начатый пакет доводится до конца и при остановке
+	ctx = context.WithoutCancel(ctx)
+
+	acked := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		metric, err := decodeStreamEntry(msg)
+		if err != nil {
+			metrics.RecordIngestedMetrics("stream", "invalid", 1)
+			s.logger.Warnf("Dropped stream entry %s: %v", msg.ID, err)
+			acked = append(acked, msg.ID)
+			continue
+		}
+		if err := Process(ctx, s.processor, "stream", metric); err != nil {
+			s.logger.Errorf("Failed to process stream entry %s for device %s: %v", msg.ID, metric.DeviceID, err)
+			continue
+		}
+		acked = append(acked, msg.ID)
+	}
+
+	if err := s.client.Ack(ctx, s.cfg.Stream, s.cfg.Group, acked...); err != nil {
+		s.logger.Errorf("Failed to acknowledge %d stream entries: %v", len(acked), err)
+	}
+}
+
+// claimLoop периодически переносит исчерпавшие попытки записи в поток
+// недоставленных, забирает зависшие записи и обновляет метрики отставания
+func (s *StreamConsumer) claimLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.ClaimIdle / 2)
+	defer ticker.Stop()
+
+	for {
+		s.updateLag(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.deadLetter(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Errorf("Failed to move exhausted entries of stream %s: %v", s.cfg.Stream, err)
+		}
+		if err := s.claim(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Errorf("Failed to claim idle entries of stream %s: %v", s.cfg.Stream, err)
+		}
+	}
+}
+
+// claim забирает записи, не подтвержденные дольше ClaimIdle, и обрабатывает их
+func (s *StreamConsumer) claim(ctx context.Context) error {
+	start := "0-0"
+	for ctx.Err() == nil {
+		messages, next, err := s.client.ClaimIdle(ctx, s.cfg.Stream, s.cfg.Group, s.cfg.Consumer, s.cfg.ClaimIdle, start, s.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(messages) > 0 {
+			metrics.RecordStreamClaimed(len(messages))
+			s.logger.Infof("Claimed %d idle entries of stream %s", len(messages), s.cfg.Stream)
+			s.handle(ctx, messages)
+		}
+		if next == "0-0" || next == "" {
+			return nil
+		}
+		start = next
+	}
+	return nil
+}
+
+// deadLetter переносит записи, доставленные MaxDeliveries раз, в поток
+// недоставленных и подтверждает их в основном
+func (s *StreamConsumer) deadLetter(ctx context.Context) error {
+	pending, err := s.client.PendingIdle(ctx, s.cfg.Stream, s.cfg.Group, s.cfg.ClaimIdle, s.cfg.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range pending {
+		if entry.RetryCount < s.cfg.MaxDeliveries {
+			continue
+		}
+
+		values := map[string]interface{}{
+			"source_id":  entry.ID,
+			"consumer":   entry.Consumer,
+			"deliveries": entry.RetryCount,
+		}
+		messages, err := s.client.StreamRange(ctx, s.cfg.Stream, entry.ID, entry.ID)
+		if err != nil {
+			return err
+		}
+		// Запись может быть уже вытеснена из потока через MAXLEN
+		if len(messages) > 0 {
+			for k, v := range messages[0].Values {
+				values[k] = v
+			}
+		}
+
+		if _, err := s.client.StreamAdd(ctx, s.cfg.DeadLetterStream(), values); err != nil {
+			return err
+		}
+		if err := s.client.Ack(ctx, s.cfg.Stream, s.cfg.Group, entry.ID); err != nil {
+			return err
+		}
+		metrics.RecordStreamDeadLettered()
+		s.logger.Warnf("Moved stream entry %s to %s after %d deliveries", entry.ID, s.cfg.DeadLetterStream(), entry.RetryCount)
+	}
+	return nil
+}
+
+// updateLag обновляет число непрочитанных и неподтвержденных записей группы
+func (s *StreamConsumer) updateLag(ctx context.Context) {
+	info, err := s.client.GroupInfo(ctx, s.cfg.Stream, s.cfg.Group)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.Warnf("Failed to get consumer group %s info: %v", s.cfg.Group, err)
+		}
+		return
+	}
+	metrics.SetStreamLag(info.Lag, info.Pending)
+}
+
+// decodeStreamEntry разбирает запись потока в метрику
+func decodeStreamEntry(msg cache.StreamMessage) (models.Metric, error) {
+	var metric models.Metric
+	if raw, ok := msg.Values["metric"]; ok {
+		if err := json.Unmarshal([]byte(fmt.Sprint(raw)), &metric); err != nil {
+			return models.Metric{}, fmt.Errorf("invalid metric: %w", err)
+		}
+	} else {
+		for name, raw := range msg.Values {
+			value := fmt.Sprint(raw)
+			switch name {
+			case "device_id":
+				metric.DeviceID = value
+			case "message_id":
+				metric.MessageID = value
+			case "timestamp":
+				ts, err := parseStreamTimestamp(value)
+				if err != nil {
+					return models.Metric{}, err
+				}
+				metric.Timestamp = ts
+			default:
+				v, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					if models.IsLegacyField(name) {
+						return models.Metric{}, fmt.Errorf("invalid %s: %w", name, err)
+					}
+					continue
+				}
+				metric.SetField(name, v)
+			}
+		}
+	}
+
+	if metric.DeviceID == "" {
+		return models.Metric{}, errors.New("device_id is required")
+	}
+	if metric.RPS < 0 {
+		return models.Metric{}, errors.New("rps must not be negative")
+	}
+	if metric.MessageID == "" {
+		metric.MessageID = "stream:" + msg.ID
+	}
+	return metric, nil
+}
+
+// parseStreamTimestamp принимает RFC3339 или секунды Unix
+func parseStreamTimestamp(value string) (time.Time, error) {
+	if ts, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return ts, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+// sleep ждет d или отмены ctx
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,40 @@
+package ingest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamConfigValidate(t *testing.T) {
+	valid := StreamConfig{
+		Stream:        "metrics",
+		Group:         "go-service",
+		Consumer:      "node-1",
+		BatchSize:     100,
+		Block:         5 * time.Second,
+		ClaimIdle:     time.Minute,
+		MaxDeliveries: 5,
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*StreamConfig)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(*StreamConfig) {}},
+		{name: "minimal claim idle", modify: func(c *StreamConfig) { c.ClaimIdle = MinClaimIdle }},
+		{name: "nanosecond claim idle", modify: func(c *StreamConfig) { c.ClaimIdle = time.Nanosecond }, wantErr: true},
+		{name: "sub-millisecond claim idle", modify: func(c *StreamConfig) { c.ClaimIdle = 500 * time.Microsecond }, wantErr: true},
+		{name: "zero block", modify: func(c *StreamConfig) { c.Block = 0 }, wantErr: true},
+		{name: "missing consumer", modify: func(c *StreamConfig) { c.Consumer = "" }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
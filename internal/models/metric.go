@@ -1,28 +1,156 @@
 package models
 
 import (
+	"fmt"
 	"time"
 )
 
-// Metric представляет метрику от IoT устройства
+// Metric представляет метрику от IoT устройства. Кроме четырех
+// основных полей метрика может нести произвольные поля в Values.
 type Metric struct {
-	DeviceID  string    `json:"device_id"`
-	Timestamp time.Time `json:"timestamp"`
-	CPU       float64   `json:"cpu"`     // Загрузка CPU в процентах
-	Memory    float64   `json:"memory"`  // Использование памяти в процентах
-	RPS       float64   `json:"rps"`     // Запросов в секунду
-	Network   float64   `json:"network"` // Сетевая активность в Мбит/с
+	DeviceID  string             `json:"device_id"`
+	Timestamp time.Time          `json:"timestamp"`
+	CPU       float64            `json:"cpu"`                  // Загрузка CPU в процентах
+	Memory    float64            `json:"memory"`               // Использование памяти в процентах
+	RPS       float64            `json:"rps"`                  // Запросов в секунду
+	Network   float64            `json:"network"`              // Сетевая активность в Мбит/с
+	Values    map[string]float64 `json:"values,omitempty"`     // Дополнительные поля: temperature, voltage и т.п.
+	MessageID string             `json:"message_id,omitempty"` // Идентификатор сообщения для подавления повторов
+}
+
+// maxFieldNameLength предел длины имени поля
+const maxFieldNameLength = 64
+
+// IsLegacyField проверяет, что имя относится к основному полю метрики:
+// cpu, memory, rps или network. Основные поля есть в каждой метрике.
+func IsLegacyField(name string) bool {
+	switch name {
+	case "cpu", "memory", "rps", "network":
+		return true
+	}
+	return false
+}
+
+// ValidFieldName проверяет имя поля: строчные латинские буквы, цифры,
+// '_', '.' и '-', не длиннее 64 символов
+func ValidFieldName(name string) bool {
+	if name == "" || len(name) > maxFieldNameLength {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '.', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Field возвращает значение поля метрики по имени. Основные поля есть
+// всегда, дополнительные — только если переданы.
+func (m Metric) Field(name string) (float64, bool) {
+	switch name {
+	case "cpu":
+		return m.CPU, true
+	case "memory":
+		return m.Memory, true
+	case "rps":
+		return m.RPS, true
+	case "network":
+		return m.Network, true
+	}
+	value, ok := m.Values[name]
+	return value, ok
+}
+
+// SetField записывает значение поля метрики по имени. Имена, не
+// относящиеся к основным полям, попадают в Values. Возвращает false для
+// недопустимого имени.
+func (m *Metric) SetField(name string, value float64) bool {
+	switch name {
+	case "cpu":
+		m.CPU = value
+	case "memory":
+		m.Memory = value
+	case "rps":
+		m.RPS = value
+	case "network":
+		m.Network = value
+	default:
+		if !ValidFieldName(name) {
+			return false
+		}
+		if m.Values == nil {
+			m.Values = make(map[string]float64)
+		}
+		m.Values[name] = value
+	}
+	return true
+}
+
+// EachField вызывает fn для каждого числового поля метрики: сначала
+// основных, затем дополнительных
+func (m Metric) EachField(fn func(name string, value float64)) {
+	fn("cpu", m.CPU)
+	fn("memory", m.Memory)
+	fn("rps", m.RPS)
+	fn("network", m.Network)
+	for name, value := range m.Values {
+		if !IsLegacyField(name) {
+			fn(name, value)
+		}
+	}
+}
+
+// Normalize переносит основные поля, переданные в Values, в поля метрики
+// и проверяет имена дополнительных полей. Исходный Values не меняется.
+func (m *Metric) Normalize() error {
+	var values map[string]float64
+	for name, value := range m.Values {
+		if IsLegacyField(name) {
+			m.SetField(name, value)
+			continue
+		}
+		if !ValidFieldName(name) {
+			return fmt.Errorf("invalid field name %q", name)
+		}
+		if values == nil {
+			values = make(map[string]float64, len(m.Values))
+		}
+		values[name] = value
+	}
+	m.Values = values
+	return nil
 }
 
 // AnalyticsResult представляет результат аналитики
 type AnalyticsResult struct {
-	Timestamp      time.Time `json:"timestamp"`
+	Timestamp      time.Time `json:"timestamp"`    // Время события метрики
+	ProcessedAt    time.Time `json:"processed_at"` // Время обработки
 	DeviceID       string    `json:"device_id"`
+	Tenant         string    `json:"tenant,omitempty"` // Арендатор устройства
 	RollingAverage float64   `json:"rolling_average"`
 	StdDev         float64   `json:"std_dev"`
 	ZScore         float64   `json:"z_score"`
 	IsAnomaly      bool      `json:"is_anomaly"`
 	CurrentValue   float64   `json:"current_value"`
+	Duplicate      bool      `json:"duplicate,omitempty"`           // Результат повторно отправленной метрики
+	Diverted       bool      `json:"diverted_to_history,omitempty"` // Опоздавшая метрика сохранена только в историю
+	// Статистика дополнительных полей и основных полей с описанием.
+	// IsAnomaly выставляется и при аномалии любого из них.
+	Fields map[string]FieldResult `json:"fields,omitempty"`
+}
+
+// FieldResult статистика одного поля метрики по окну устройства
+type FieldResult struct {
+	Value          float64 `json:"value"`
+	Unit           string  `json:"unit,omitempty"`
+	RollingAverage float64 `json:"rolling_average"`
+	StdDev         float64 `json:"std_dev"`
+	ZScore         float64 `json:"z_score"`
+	IsAnomaly      bool    `json:"is_anomaly"`
+	Samples        int     `json:"samples"` // Метрик с этим полем в окне
 }
 
 // HealthResponse представляет ответ о состоянии сервиса
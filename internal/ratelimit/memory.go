@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval как часто удалять полные корзины
+const sweepInterval = time.Minute
+
+// bucket корзина жетонов. Жетонов может быть меньше нуля после запроса
+// больше емкости.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// refill пополняет корзину на время now
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate)
+	b.updated = now
+}
+
+// Memory корзины в памяти процесса для одиночного узла
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemory создает пустой набор корзин
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+// Take списывает жетоны из корзины key
+func (m *Memory) Take(ctx context.Context, key string, limit Limit, n int) (bool, time.Duration, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		m.buckets[key] = b
+	}
+	b.refill(now)
+
+	need := math.Min(float64(n), float64(limit.Burst))
+	if b.tokens < need {
+		wait := (need - b.tokens) / limit.Rate
+		return false, time.Duration(math.Ceil(wait * float64(time.Second))), nil
+	}
+	b.tokens -= float64(n)
+	return true, 0, nil
+}
+
+// sweep удаляет корзины, пополнившиеся до емкости: они не отличаются от
+// новых. Вызывается под блокировкой.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-service/internal/auth"
+	"go-service/internal/tenant"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// Области ограничения
+const (
+	ScopeDevice = "device"
+	ScopeKey    = "key"
+)
+
+// Limit скорость пополнения корзины в метриках в секунду и ее емкость.
+// Нулевая скорость отключает ограничение.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled проверяет, что ограничение включено
+func (l Limit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// Validate проверяет емкость включенного ограничения
+func (l Limit) Validate() error {
+	if l.Rate < 0 {
+		return fmt.Errorf("rate must not be negative, got %v", l.Rate)
+	}
+	if l.Enabled() && l.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1, got %d", l.Burst)
+	}
+	return nil
+}
+
+// Store корзины жетонов по ключу
+type Store interface {
+	// Take списывает n жетонов из корзины key. Если жетонов не хватает,
+	// возвращает false и время до их накопления. Запрос больше емкости
+	// пропускается при полной корзине.
+	Take(ctx context.Context, key string, limit Limit, n int) (bool, time.Duration, error)
+}
+
+// ExceededError возвращается, когда устройство или ключ превысили скорость
+type ExceededError struct {
+	Scope      string // device или key
+	ID         string
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("rate limit exceeded for %s %s, retry after %s", e.Scope, e.ID, e.RetryAfter.Round(time.Millisecond))
+}
+
+// Limiter ограничение скорости приема метрик по устройствам и ключам API.
+// Нулевой *Limiter ничего не ограничивает.
+type Limiter struct {
+	store  Store
+	device Limit
+	key    Limit
+	logger *zap.SugaredLogger
+}
+
+// New создает ограничение с корзинами в store
+func New(store Store, device, key Limit, logger *zap.SugaredLogger) *Limiter {
+	return &Limiter{store: store, device: device, key: key, logger: logger}
+}
+
+// Device списывает n метрик устройства арендатора из контекста или
+// возвращает *ExceededError
+func (l *Limiter) Device(ctx context.Context, handler, deviceID string, n int) error {
+	if l == nil || !l.device.Enabled() {
+		return nil
+	}
+	return l.take(ctx, handler, ScopeDevice, tenant.Scope(tenant.FromContext(ctx), deviceID), l.device, n)
+}
+
+// Key списывает n метрик ключа API из контекста или возвращает
+// *ExceededError. Запросы без ключа не ограничиваются.
+func (l *Limiter) Key(ctx context.Context, handler string, n int) error {
+	if l == nil || !l.key.Enabled() {
+		return nil
+	}
+	key, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return l.take(ctx, handler, ScopeKey, key.ID, l.key, n)
+}
+
+// Devices списывает скорость устройств пакета, по counts[устройство]
+// метрик с каждого, и возвращает превысившие ее устройства
+func (l *Limiter) Devices(ctx context.Context, handler string, counts map[string]int) map[string]*ExceededError {
+	throttled := make(map[string]*ExceededError)
+	for deviceID, n := range counts {
+		var exceeded *ExceededError
+		if errors.As(l.Device(ctx, handler, deviceID, n), &exceeded) {
+			throttled[deviceID] = exceeded
+		}
+	}
+	return throttled
+}
+
+// take списывает жетоны. Недоступное хранилище не блокирует прием метрик.
+func (l *Limiter) take(ctx context.Context, handler, scope, id string, limit Limit, n int) error {
+	allowed, retryAfter, err := l.store.Take(ctx, scope+":"+id, limit, n)
+	if err != nil {
+		l.logger.Warnf("Rate limit for %s %s is not checked: %v", scope, id, err)
+		return nil
+	}
+	if allowed {
+		return nil
+	}
+	metrics.RecordRateLimited(handler, scope)
+	return &ExceededError{Scope: scope, ID: id, RetryAfter: retryAfter}
+}
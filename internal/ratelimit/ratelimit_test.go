@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-service/internal/auth"
+	"go-service/internal/tenant"
+	"go-service/pkg/metrics"
+
+	"go.uber.org/zap"
+)
+
+// testStore проверяет общее для хранилищ поведение корзин. Ключи получают
+// префикс prefix, чтобы не пересекаться с корзинами прошлых запусков.
+func testStore(t *testing.T, store Store, prefix string) {
+	ctx := context.Background()
+
+	t.Run("Burst", func(t *testing.T) {
+		key := prefix + "burst"
+		limit := Limit{Rate: 1, Burst: 3}
+		for i := 0; i < 3; i++ {
+			if allowed, _, err := store.Take(ctx, key, limit, 1); !allowed || err != nil {
+				t.Fatalf("take %d within burst: allowed %v, err %v", i, allowed, err)
+			}
+		}
+		allowed, retryAfter, err := store.Take(ctx, key, limit, 1)
+		if allowed || err != nil {
+			t.Fatalf("take over burst: allowed %v, err %v", allowed, err)
+		}
+		if retryAfter <= 0 || retryAfter > time.Second {
+			t.Errorf("retry after %s, want up to 1s", retryAfter)
+		}
+	})
+
+	t.Run("OversizedOnFullBucket", func(t *testing.T) {
+		key := prefix + "oversized"
+		limit := Limit{Rate: 1, Burst: 2}
+		if allowed, _, err := store.Take(ctx, key, limit, 5); !allowed || err != nil {
+			t.Fatalf("oversized take on full bucket: allowed %v, err %v", allowed, err)
+		}
+		if allowed, _, err := store.Take(ctx, key, limit, 1); allowed || err != nil {
+			t.Fatalf("take after oversized: allowed %v, err %v", allowed, err)
+		}
+	})
+
+	t.Run("Refill", func(t *testing.T) {
+		key := prefix + "refill"
+		limit := Limit{Rate: 100, Burst: 1}
+		if allowed, _, err := store.Take(ctx, key, limit, 1); !allowed || err != nil {
+			t.Fatalf("first take: allowed %v, err %v", allowed, err)
+		}
+		allowed, retryAfter, err := store.Take(ctx, key, limit, 1)
+		if allowed || err != nil {
+			t.Fatalf("take on empty bucket: allowed %v, err %v", allowed, err)
+		}
+		time.Sleep(retryAfter + 5*time.Millisecond)
+		if allowed, _, err := store.Take(ctx, key, limit, 1); !allowed || err != nil {
+			t.Fatalf("take after refill: allowed %v, err %v", allowed, err)
+		}
+	})
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory(), "")
+}
+
+func TestLimiterScopes(t *testing.T) {
+	metrics.InitMetrics()
+	limiter := New(NewMemory(), Limit{Rate: 0.001, Burst: 2}, Limit{Rate: 0.001, Burst: 3}, zap.NewNop().Sugar())
+	key := &auth.Key{ID: "k1", Devices: []string{"*"}, Roles: []auth.Role{auth.RoleIngest}}
+	ctx := auth.WithKey(tenant.WithTenant(context.Background(), "acme"), key)
+
+	// Корзины устройств независимы, а корзина ключа общая для его устройств
+	if err := limiter.Device(ctx, "test", "a", 2); err != nil {
+		t.Fatalf("device a within burst: %v", err)
+	}
+	var exceeded *ExceededError
+	if err := limiter.Device(ctx, "test", "a", 1); !errors.As(err, &exceeded) || exceeded.Scope != ScopeDevice {
+		t.Fatalf("device a over burst: %v, want device ExceededError", err)
+	}
+	if err := limiter.Device(ctx, "test", "b", 2); err != nil {
+		t.Errorf("device b has its own bucket: %v", err)
+	}
+
+	// Устройство с тем же именем у другого арендатора не затронуто
+	other := tenant.WithTenant(context.Background(), "globex")
+	if err := limiter.Device(other, "test", "a", 2); err != nil {
+		t.Errorf("device a of another tenant: %v", err)
+	}
+
+	if err := limiter.Key(ctx, "test", 3); err != nil {
+		t.Fatalf("key within burst: %v", err)
+	}
+	if err := limiter.Key(ctx, "test", 1); !errors.As(err, &exceeded) || exceeded.Scope != ScopeKey || exceeded.ID != "k1" {
+		t.Fatalf("key over burst: %v, want key ExceededError", err)
+	}
+
+	// Запросы без ключа API ограничиваются только по устройствам
+	if err := limiter.Key(context.Background(), "test", 10); err != nil {
+		t.Errorf("request without key: %v", err)
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	var nilLimiter *Limiter
+	if err := nilLimiter.Device(context.Background(), "test", "a", 100); err != nil {
+		t.Errorf("nil limiter: %v", err)
+	}
+
+	limiter := New(NewMemory(), Limit{}, Limit{}, zap.NewNop().Sugar())
+	if err := limiter.Device(context.Background(), "test", "a", 100); err != nil {
+		t.Errorf("zero rate limiter: %v", err)
+	}
+}
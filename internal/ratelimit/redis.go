@@ -0,0 +1,27 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"go-service/internal/cache"
+)
+
+// keyPrefix префикс корзин в Redis
+const keyPrefix = "ratelimit:"
+
+// Redis корзины в Redis, общие для всех узлов сервиса. Время пополнения
+// берется с сервера Redis, поэтому расхождение часов узлов не влияет.
+type Redis struct {
+	client *cache.RedisClient
+}
+
+// NewRedis создает корзины в Redis
+func NewRedis(client *cache.RedisClient) *Redis {
+	return &Redis{client: client}
+}
+
+// Take списывает жетоны атомарно скриптом Redis
+func (r *Redis) Take(ctx context.Context, key string, limit Limit, n int) (bool, time.Duration, error) {
+	return r.client.TakeTokens(ctx, keyPrefix+key, limit.Rate, limit.Burst, n)
+}
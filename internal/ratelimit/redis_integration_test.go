@@ -0,0 +1,62 @@
+//go:build integration
+
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-service/internal/cache"
+)
+
+// Тесты с Redis:
+//
+//	docker run -d -p 6379:6379 redis:7
+//	REDIS_TEST_ADDR=localhost:6379 go test -tags integration ./internal/ratelimit
+
+// testRedisClient подключается к Redis из REDIS_TEST_ADDR
+func testRedisClient(t *testing.T) *cache.RedisClient {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("invalid REDIS_TEST_ADDR %q: %v", addr, err)
+	}
+	t.Setenv("REDIS_HOST", host)
+	t.Setenv("REDIS_PORT", port)
+
+	client := cache.NewRedisClient()
+	t.Cleanup(func() { client.Close() })
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Redis at %s is unavailable: %v", addr, err)
+	}
+	return client
+}
+
+func TestRedis(t *testing.T) {
+	client := testRedisClient(t)
+	// Корзины одного запуска не пересекаются с корзинами прошлых
+	testStore(t, NewRedis(client), "test:"+strconv.FormatInt(time.Now().UnixNano(), 10)+":")
+}
+
+func TestRedisSharedBetweenNodes(t *testing.T) {
+	client := testRedisClient(t)
+	key := "test:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":shared"
+	limit := Limit{Rate: 0.001, Burst: 2}
+	ctx := context.Background()
+
+	first, second := NewRedis(client), NewRedis(client)
+	if allowed, _, err := first.Take(ctx, key, limit, 2); !allowed || err != nil {
+		t.Fatalf("take on first node: allowed %v, err %v", allowed, err)
+	}
+	if allowed, _, err := second.Take(ctx, key, limit, 1); allowed || err != nil {
+		t.Fatalf("take on second node: allowed %v, err %v; want bucket shared with first", allowed, err)
+	}
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Бакеты встроенной базы
+var (
+	resultsBucket   = []byte("results")
+	windowsBucket   = []byte("windows")
+	anomaliesBucket = []byte("anomalies")
+	metaBucket      = []byte("meta")
+)
+
+// Версия схемы ключей базы. Ключи без арендатора, записанные до версии
+// schemaTenantKeys, переносятся к арендатору по умолчанию при открытии.
+var schemaVersionKey = []byte("schema")
+
+// Disk встроенное хранилище на локальном диске поверх bbolt
+type Disk struct {
+	db *bolt.DB
+}
+
+// NewDisk открывает или создает файл базы по указанному пути
+func NewDisk(path string) (*Disk, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{resultsBucket, windowsBucket, anomaliesBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return migrateLegacyKeys(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Disk{db: db}, nil
+}
+
+// migrateLegacyKeys однократно переносит записи, сохраненные до
+// разделения по арендаторам под ключом <device>, к арендатору по
+// умолчанию и записывает версию схемы. Ключи с арендатором не затрагиваются.
+func migrateLegacyKeys(tx *bolt.Tx) error {
+	meta := tx.Bucket(metaBucket)
+	if string(meta.Get(schemaVersionKey)) == schemaTenantKeys {
+		return nil
+	}
+
+	for _, name := range [][]byte{resultsBucket, windowsBucket} {
+		bucket := tx.Bucket(name)
+		// Бакет нельзя менять во время обхода, поэтому ключи собираются заранее
+		var keys [][]byte
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if isLegacyKey(string(k)) {
+				keys = append(keys, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			value := append([]byte(nil), bucket.Get(key)...)
+			if err := bucket.Put([]byte(tenant.Scope(tenant.Default, string(key))), value); err != nil {
+				return err
+			}
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Журналы аномалий хранятся во вложенных бакетах устройств
+	anomalies := tx.Bucket(anomaliesBucket)
+	var devices [][]byte
+	if err := anomalies.ForEach(func(k, v []byte) error {
+		if v == nil && isLegacyKey(string(k)) {
+			devices = append(devices, k)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, device := range devices {
+		old := anomalies.Bucket(device)
+		moved, err := anomalies.CreateBucket([]byte(tenant.Scope(tenant.Default, string(device))))
+		if err != nil {
+			return err
+		}
+		if err := old.ForEach(moved.Put); err != nil {
+			return err
+		}
+		if err := moved.SetSequence(old.Sequence()); err != nil {
+			return err
+		}
+		if err := anomalies.DeleteBucket(device); err != nil {
+			return err
+		}
+	}
+
+	return meta.Put(schemaVersionKey, []byte(schemaTenantKeys))
+}
+
+// SaveResult сохраняет последний результат аналитики устройства
+func (d *Disk) SaveResult(ctx context.Context, result *models.AnalyticsResult) error {
+	return d.put(resultsBucket, tenant.Scope(result.Tenant, result.DeviceID), result)
+}
+
+// GetResult возвращает последний результат устройства
+func (d *Disk) GetResult(ctx context.Context, tenantID, deviceID string) (*models.AnalyticsResult, error) {
+	var result models.AnalyticsResult
+	err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(resultsBucket).Get([]byte(tenant.Scope(tenantID, deviceID)))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SaveWindow сохраняет снимок окна метрик устройства
+func (d *Disk) SaveWindow(ctx context.Context, tenantID, deviceID string, metrics []models.Metric) error {
+	return d.put(windowsBucket, tenant.Scope(tenantID, deviceID), metrics)
+}
+
+// LoadWindows возвращает сохраненные окна всех устройств
+func (d *Disk) LoadWindows(ctx context.Context) (map[string][]models.Metric, error) {
+	windows := make(map[string][]models.Metric)
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(windowsBucket).ForEach(func(k, v []byte) error {
+			var metrics []models.Metric
+			if err := json.Unmarshal(v, &metrics); err != nil {
+				return err
+			}
+			windows[string(k)] = metrics
+			return nil
+		})
+	})
+	return windows, err
+}
+
+// SaveAnomaly добавляет аномалию в журнал устройства.
+// Журнал хранится во вложенном бакете с последовательными ключами.
+func (d *Disk) SaveAnomaly(ctx context.Context, result *models.AnalyticsResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return d.db.Batch(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(anomaliesBucket).CreateBucketIfNotExists([]byte(tenant.Scope(result.Tenant, result.DeviceID)))
+		if err != nil {
+			return err
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(sequenceKey(seq), data); err != nil {
+			return err
+		}
+
+		// Удаляем самые старые записи сверх лимита
+		if seq > MaxAnomaliesPerDevice {
+			return bucket.Delete(sequenceKey(seq - MaxAnomaliesPerDevice))
+		}
+		return nil
+	})
+}
+
+// ListAnomalies возвращает последние аномалии устройства, новые первыми
+func (d *Disk) ListAnomalies(ctx context.Context, tenantID, deviceID string, limit int) ([]models.AnalyticsResult, error) {
+	var anomalies []models.AnalyticsResult
+	err := d.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(anomaliesBucket).Bucket([]byte(tenant.Scope(tenantID, deviceID)))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(anomalies) >= limit {
+				break
+			}
+			var result models.AnalyticsResult
+			if err := json.Unmarshal(v, &result); err != nil {
+				return err
+			}
+			anomalies = append(anomalies, result)
+		}
+		return nil
+	})
+	return anomalies, err
+}
+
+// Close закрывает файл базы
+func (d *Disk) Close(ctx context.Context) error {
+	return d.db.Close()
+}
+
+// put сериализует значение и записывает его в бакет
+func (d *Disk) put(bucket []byte, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	// Batch объединяет конкурентные записи в одну транзакцию
+	return d.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+// sequenceKey кодирует номер записи так, чтобы ключи сортировались по порядку
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// writeLegacyDisk создает базу в формате до разделения по арендаторам
+func writeLegacyDisk(t *testing.T, path string) {
+	t.Helper()
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error = %v", err)
+	}
+	defer db.Close()
+
+	put := func(bucket *bolt.Bucket, key []byte, value interface{}) {
+		data, _ := json.Marshal(value)
+		if err := bucket.Put(key, data); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		results, _ := tx.CreateBucket(resultsBucket)
+		put(results, []byte("sensor-1"), models.AnalyticsResult{DeviceID: "sensor-1", ZScore: 1.5})
+		windows, _ := tx.CreateBucket(windowsBucket)
+		put(windows, []byte("sensor-1"), []models.Metric{{DeviceID: "sensor-1", CPU: 42}})
+
+		anomalies, _ := tx.CreateBucket(anomaliesBucket)
+		device, _ := anomalies.CreateBucket([]byte("sensor-1"))
+		for i := 0; i < 3; i++ {
+			seq, _ := device.NextSequence()
+			put(device, sequenceKey(seq), models.AnalyticsResult{DeviceID: "sensor-1", ZScore: float64(i)})
+		}
+
+		// Записи арендатора без версии схемы: узел, не успевший ее записать
+		put(results, []byte(tenant.Scope("acme", "sensor-1")), models.AnalyticsResult{Tenant: "acme", DeviceID: "sensor-1", ZScore: 7})
+		put(windows, []byte(tenant.Scope("acme", "sensor-1")), []models.Metric{{DeviceID: "sensor-1", CPU: 7}})
+		tenantDevice, _ := anomalies.CreateBucket([]byte(tenant.Scope("acme", "sensor-1")))
+		seq, _ := tenantDevice.NextSequence()
+		put(tenantDevice, sequenceKey(seq), models.AnalyticsResult{Tenant: "acme", DeviceID: "sensor-1", ZScore: 7})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("write legacy database: %v", err)
+	}
+}
+
+func TestDiskMigratesLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "analytics.db")
+	writeLegacyDisk(t, path)
+
+	store, err := NewDisk(path)
+	if err != nil {
+		t.Fatalf("NewDisk() error = %v", err)
+	}
+
+	result, err := store.GetResult(ctx, tenant.Default, "sensor-1")
+	if err != nil || result.ZScore != 1.5 {
+		t.Fatalf("GetResult() = %+v, %v, want legacy result", result, err)
+	}
+	windows, err := store.LoadWindows(ctx)
+	if err != nil {
+		t.Fatalf("LoadWindows() error = %v", err)
+	}
+	if len(windows) != 2 || len(windows[tenant.Scope(tenant.Default, "sensor-1")]) != 1 {
+		t.Errorf("LoadWindows() = %v, want legacy window of the default tenant", windows)
+	}
+	checkTenantKeys(t, store)
+
+	// Журнал продолжается после перенесенных записей
+	if err := store.SaveAnomaly(ctx, &models.AnalyticsResult{Tenant: tenant.Default, DeviceID: "sensor-1", ZScore: 3}); err != nil {
+		t.Fatalf("SaveAnomaly() error = %v", err)
+	}
+	anomalies, err := store.ListAnomalies(ctx, tenant.Default, "sensor-1", 0)
+	if err != nil || len(anomalies) != 4 {
+		t.Fatalf("ListAnomalies() = %d anomalies, %v, want 4", len(anomalies), err)
+	}
+	for i, a := range anomalies {
+		if a.ZScore != float64(3-i) {
+			t.Errorf("anomaly %d z-score = %v, want %v", i, a.ZScore, 3-i)
+		}
+	}
+	store.Close(ctx)
+
+	// Повторный перенос без версии схемы не трогает ключи арендаторов
+	clearSchemaVersion(t, path)
+	store, err = NewDisk(path)
+	if err != nil {
+		t.Fatalf("NewDisk() reopen error = %v", err)
+	}
+	defer store.Close(ctx)
+	if _, err := store.GetResult(ctx, tenant.Default, "sensor-1"); err != nil {
+		t.Errorf("GetResult() after second migration error = %v", err)
+	}
+	if _, err := store.GetResult(ctx, tenant.Default, tenant.Scope(tenant.Default, "sensor-1")); err == nil {
+		t.Error("default tenant key was migrated twice")
+	}
+	checkTenantKeys(t, store)
+}
+
+// checkTenantKeys проверяет, что записи арендатора acme остались на месте
+func checkTenantKeys(t *testing.T, store *Disk) {
+	t.Helper()
+	ctx := context.Background()
+	if result, err := store.GetResult(ctx, "acme", "sensor-1"); err != nil || result.ZScore != 7 {
+		t.Errorf("GetResult(acme) = %+v, %v, want tenant result", result, err)
+	}
+	windows, err := store.LoadWindows(ctx)
+	if err != nil || len(windows[tenant.Scope("acme", "sensor-1")]) != 1 {
+		t.Errorf("LoadWindows() = %v, %v, want tenant window", windows, err)
+	}
+	if _, ok := windows[tenant.Scope(tenant.Default, tenant.Scope("acme", "sensor-1"))]; ok {
+		t.Error("tenant window was moved to the default tenant")
+	}
+	if anomalies, err := store.ListAnomalies(ctx, "acme", "sensor-1", 0); err != nil || len(anomalies) != 1 {
+		t.Errorf("ListAnomalies(acme) = %d anomalies, %v, want 1", len(anomalies), err)
+	}
+}
+
+// clearSchemaVersion удаляет версию схемы, как будто перенос не завершился
+func clearSchemaVersion(t *testing.T, path string) {
+	t.Helper()
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error = %v", err)
+	}
+	defer db.Close()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Delete(schemaVersionKey)
+	}); err != nil {
+		t.Fatalf("clear schema version: %v", err)
+	}
+}
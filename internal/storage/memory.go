@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+)
+
+// Memory хранилище в памяти процесса для тестов и одиночного узла
+type Memory struct {
+	// Результаты пишутся на каждую метрику по непересекающимся ключам,
+	// поэтому хранятся в sync.Map без общей блокировки
+	results sync.Map
+
+	mu        sync.RWMutex
+	windows   map[string][]models.Metric
+	anomalies map[string][]models.AnalyticsResult
+}
+
+// NewMemory создает пустое хранилище в памяти
+func NewMemory() *Memory {
+	return &Memory{
+		windows:   make(map[string][]models.Metric),
+		anomalies: make(map[string][]models.AnalyticsResult),
+	}
+}
+
+// SaveResult сохраняет последний результат аналитики устройства
+func (m *Memory) SaveResult(ctx context.Context, result *models.AnalyticsResult) error {
+	m.results.Store(tenant.Scope(result.Tenant, result.DeviceID), *result)
+	return nil
+}
+
+// GetResult возвращает последний результат устройства
+func (m *Memory) GetResult(ctx context.Context, tenantID, deviceID string) (*models.AnalyticsResult, error) {
+	value, exists := m.results.Load(tenant.Scope(tenantID, deviceID))
+	if !exists {
+		return nil, ErrNotFound
+	}
+	result := value.(models.AnalyticsResult)
+	return &result, nil
+}
+
+// SaveWindow сохраняет снимок окна метрик устройства
+func (m *Memory) SaveWindow(ctx context.Context, tenantID, deviceID string, metrics []models.Metric) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.windows[tenant.Scope(tenantID, deviceID)] = append([]models.Metric(nil), metrics...)
+	return nil
+}
+
+// LoadWindows возвращает сохраненные окна всех устройств
+func (m *Memory) LoadWindows(ctx context.Context) (map[string][]models.Metric, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	windows := make(map[string][]models.Metric, len(m.windows))
+	for key, metrics := range m.windows {
+		windows[key] = append([]models.Metric(nil), metrics...)
+	}
+	return windows, nil
+}
+
+// SaveAnomaly добавляет аномалию в журнал устройства
+func (m *Memory) SaveAnomaly(ctx context.Context, result *models.AnalyticsResult) error {
+	key := tenant.Scope(result.Tenant, result.DeviceID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := append(m.anomalies[key], *result)
+	if len(list) > MaxAnomaliesPerDevice {
+		list = list[len(list)-MaxAnomaliesPerDevice:]
+	}
+	m.anomalies[key] = list
+	return nil
+}
+
+// ListAnomalies возвращает последние аномалии устройства, новые первыми
+func (m *Memory) ListAnomalies(ctx context.Context, tenantID, deviceID string, limit int) ([]models.AnalyticsResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := m.anomalies[tenant.Scope(tenantID, deviceID)]
+	if limit <= 0 || limit > len(list) {
+		limit = len(list)
+	}
+	anomalies := make([]models.AnalyticsResult, 0, limit)
+	for i := len(list) - 1; i >= 0 && len(anomalies) < limit; i-- {
+		anomalies = append(anomalies, list[i])
+	}
+	return anomalies, nil
+}
+
+// Close ничего не делает для хранилища в памяти
+func (m *Memory) Close(ctx context.Context) error {
+	return nil
+}
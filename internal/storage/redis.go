@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"go-service/internal/cache"
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+)
+
+// Префиксы ключей в Redis, за префиксом следует ключ tenant.Scope:
+// analytics:<tenant>:<device>
+const (
+	resultKeyPrefix  = "analytics:"
+	windowKeyPrefix  = "window:"
+	anomalyKeyPrefix = "anomalies:"
+)
+
+// Версия схемы ключей в Redis. Ключи без арендатора, записанные до
+// версии schemaTenantKeys, переносятся к арендатору по умолчанию.
+const (
+	schemaKey        = "storage:schema"
+	schemaTenantKeys = "2"
+)
+
+// Redis хранилище поверх общего Redis. Результаты пишутся через
+// очередь отложенной записи, если она задана.
+type Redis struct {
+	client    *cache.RedisClient
+	writer    *cache.WriteBehind
+	resultTTL time.Duration
+}
+
+// NewRedis создает хранилище в Redis. writer может быть nil,
+// тогда результаты записываются синхронно.
+func NewRedis(client *cache.RedisClient, writer *cache.WriteBehind, resultTTL time.Duration) *Redis {
+	return &Redis{
+		client:    client,
+		writer:    writer,
+		resultTTL: resultTTL,
+	}
+}
+
+// MigrateLegacyKeys однократно переносит записи, сохраненные до
+// разделения по арендаторам (analytics:<device>), к арендатору по
+// умолчанию (analytics:default:<device>) и записывает версию схемы.
+// Ключи с арендатором не затрагиваются, поэтому перенос можно повторять,
+// в том числе одновременно на нескольких узлах. Возвращает количество
+// перенесенных ключей.
+func (r *Redis) MigrateLegacyKeys(ctx context.Context) (int, error) {
+	version, err := r.client.GetRaw(ctx, schemaKey)
+	if err != nil && !errors.Is(err, cache.ErrNil) {
+		return 0, err
+	}
+	if string(version) == schemaTenantKeys {
+		return 0, nil
+	}
+
+	moved := 0
+	for _, prefix := range []string{resultKeyPrefix, windowKeyPrefix, anomalyKeyPrefix} {
+		keys, err := r.client.ScanKeys(ctx, prefix+"*")
+		if err != nil {
+			return moved, err
+		}
+		for _, key := range keys {
+			deviceID := strings.TrimPrefix(key, prefix)
+			if !isLegacyKey(deviceID) {
+				continue
+			}
+			renamed, err := r.client.RenameNX(ctx, key, prefix+tenant.Scope(tenant.Default, deviceID))
+			if err != nil {
+				return moved, err
+			}
+			if !renamed {
+				// Под новым ключом уже записаны более свежие данные
+				if err := r.client.Delete(ctx, key); err != nil {
+					return moved, err
+				}
+				continue
+			}
+			moved++
+		}
+	}
+	_, err = r.client.SetNX(ctx, schemaKey, []byte(schemaTenantKeys), 0)
+	return moved, err
+}
+
+// SaveResult сохраняет последний результат аналитики устройства
+func (r *Redis) SaveResult(ctx context.Context, result *models.AnalyticsResult) error {
+	key := resultKeyPrefix + tenant.Scope(result.Tenant, result.DeviceID)
+	if r.writer != nil {
+		if !r.writer.Enqueue(key, result) {
+			return errors.New("write-behind queue is full")
+		}
+		return nil
+	}
+	return r.client.Set(ctx, key, result, r.resultTTL)
+}
+
+// GetResult возвращает последний результат устройства
+func (r *Redis) GetResult(ctx context.Context, tenantID, deviceID string) (*models.AnalyticsResult, error) {
+	key := resultKeyPrefix + tenant.Scope(tenantID, deviceID)
+	var result models.AnalyticsResult
+	if r.writer != nil && r.writer.Get(key, &result) {
+		return &result, nil
+	}
+	if err := r.client.Get(ctx, key, &result); err != nil {
+		if errors.Is(err, cache.ErrNil) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SaveWindow сохраняет снимок окна метрик устройства
+func (r *Redis) SaveWindow(ctx context.Context, tenantID, deviceID string, metrics []models.Metric) error {
+	return r.client.Set(ctx, windowKeyPrefix+tenant.Scope(tenantID, deviceID), metrics, 0)
+}
+
+// LoadWindows возвращает сохраненные окна всех устройств
+func (r *Redis) LoadWindows(ctx context.Context) (map[string][]models.Metric, error) {
+	keys, err := r.client.ScanKeys(ctx, windowKeyPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	windows := make(map[string][]models.Metric, len(keys))
+	for _, key := range keys {
+		var metrics []models.Metric
+		if err := r.client.Get(ctx, key, &metrics); err != nil {
+			if errors.Is(err, cache.ErrNil) {
+				continue
+			}
+			return nil, err
+		}
+		windows[strings.TrimPrefix(key, windowKeyPrefix)] = metrics
+	}
+	return windows, nil
+}
+
+// SaveAnomaly добавляет аномалию в журнал устройства
+func (r *Redis) SaveAnomaly(ctx context.Context, result *models.AnalyticsResult) error {
+	return r.client.PushCapped(ctx, anomalyKeyPrefix+tenant.Scope(result.Tenant, result.DeviceID), result, MaxAnomaliesPerDevice)
+}
+
+// ListAnomalies возвращает последние аномалии устройства, новые первыми
+func (r *Redis) ListAnomalies(ctx context.Context, tenantID, deviceID string, limit int) ([]models.AnalyticsResult, error) {
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(limit) - 1
+	}
+	items, err := r.client.ListRange(ctx, anomalyKeyPrefix+tenant.Scope(tenantID, deviceID), 0, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	anomalies := make([]models.AnalyticsResult, 0, len(items))
+	for _, item := range items {
+		var result models.AnalyticsResult
+		if err := json.Unmarshal([]byte(item), &result); err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, result)
+	}
+	return anomalies, nil
+}
+
+// Close сбрасывает очередь отложенной записи
+func (r *Redis) Close(ctx context.Context) error {
+	if r.writer != nil {
+		return r.writer.Close(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go-service/internal/cache"
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+
+	"go.uber.org/zap"
+)
+
+// Тесты с Redis. Ключи проверочных арендаторов удаляются перед каждой
+// проверкой. Проверка переноса переносит все ключи без арендатора, поэтому
+// нужен отдельный Redis:
+//
+//	docker run -d -p 6379:6379 redis:7
+//	REDIS_TEST_ADDR=localhost:6379 go test -tags integration ./internal/storage
+
+// testRedisClient подключается к Redis из REDIS_TEST_ADDR
+func testRedisClient(t *testing.T) *cache.RedisClient {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("invalid REDIS_TEST_ADDR %q: %v", addr, err)
+	}
+	t.Setenv("REDIS_HOST", host)
+	t.Setenv("REDIS_PORT", port)
+
+	client := cache.NewRedisClient()
+	t.Cleanup(func() { client.Close() })
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Redis at %s is unavailable: %v", addr, err)
+	}
+	return client
+}
+
+// clearTestKeys удаляет ключи проверочных арендаторов
+func clearTestKeys(t *testing.T, client *cache.RedisClient) {
+	t.Helper()
+	ctx := context.Background()
+	for _, prefix := range []string{resultKeyPrefix, windowKeyPrefix, anomalyKeyPrefix} {
+		for _, tenantID := range []string{tenantA, tenantB} {
+			keys, err := client.ScanKeys(ctx, prefix+tenantID+":*")
+			if err != nil {
+				t.Fatalf("ScanKeys() error = %v", err)
+			}
+			if len(keys) > 0 {
+				if err := client.Delete(ctx, keys...); err != nil {
+					t.Fatalf("Delete() error = %v", err)
+				}
+			}
+		}
+	}
+}
+
+func TestRedisConformance(t *testing.T) {
+	client := testRedisClient(t)
+	testConformance(t, func(t *testing.T) Storage {
+		clearTestKeys(t, client)
+		return NewRedis(client, nil, time.Hour)
+	})
+}
+
+func TestRedisWriteBehindConformance(t *testing.T) {
+	client := testRedisClient(t)
+	testConformance(t, func(t *testing.T) Storage {
+		clearTestKeys(t, client)
+		writer := cache.NewWriteBehind(client, time.Hour, 10*time.Millisecond, 100, 1000, zap.NewNop().Sugar())
+		return NewRedis(client, writer, time.Hour)
+	})
+}
+
+func TestRedisResultTTL(t *testing.T) {
+	ctx := context.Background()
+	client := testRedisClient(t)
+	clearTestKeys(t, client)
+	store := NewRedis(client, nil, time.Second)
+
+	if err := store.SaveResult(ctx, &models.AnalyticsResult{Tenant: tenantA, DeviceID: "sensor-1"}); err != nil {
+		t.Fatalf("SaveResult() error = %v", err)
+	}
+	if _, err := store.GetResult(ctx, tenantA, "sensor-1"); err != nil {
+		t.Fatalf("GetResult() before expiry error = %v", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := store.GetResult(ctx, tenantA, "sensor-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetResult() after expiry error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRedisMigratesLegacyKeys(t *testing.T) {
+	ctx := context.Background()
+	client := testRedisClient(t)
+	const deviceID = "legacy-sensor"
+	legacy := []string{resultKeyPrefix + deviceID, windowKeyPrefix + deviceID, anomalyKeyPrefix + deviceID}
+	migrated := []string{
+		resultKeyPrefix + tenant.Scope(tenant.Default, deviceID),
+		windowKeyPrefix + tenant.Scope(tenant.Default, deviceID),
+		anomalyKeyPrefix + tenant.Scope(tenant.Default, deviceID),
+	}
+	cleanup := func() { client.Delete(ctx, append(append(legacy, migrated...), schemaKey)...) }
+	cleanup()
+	t.Cleanup(cleanup)
+
+	if err := client.Set(ctx, legacy[0], models.AnalyticsResult{DeviceID: deviceID, ZScore: 1.5}, time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := client.Set(ctx, legacy[1], []models.Metric{{DeviceID: deviceID, CPU: 42}}, 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := client.PushCapped(ctx, legacy[2], models.AnalyticsResult{DeviceID: deviceID, ZScore: float64(i)}, MaxAnomaliesPerDevice); err != nil {
+			t.Fatalf("PushCapped() error = %v", err)
+		}
+	}
+
+	// Записи арендатора без версии схемы: узел, не успевший ее записать
+	clearTestKeys(t, client)
+	t.Cleanup(func() { clearTestKeys(t, client) })
+	store := NewRedis(client, nil, time.Hour)
+	tenantResult := models.AnalyticsResult{Tenant: tenantA, DeviceID: deviceID, ZScore: 7}
+	if err := store.SaveResult(ctx, &tenantResult); err != nil {
+		t.Fatalf("SaveResult() error = %v", err)
+	}
+	if err := store.SaveWindow(ctx, tenantA, deviceID, []models.Metric{{DeviceID: deviceID, CPU: 7}}); err != nil {
+		t.Fatalf("SaveWindow() error = %v", err)
+	}
+	if err := store.SaveAnomaly(ctx, &tenantResult); err != nil {
+		t.Fatalf("SaveAnomaly() error = %v", err)
+	}
+
+	moved, err := store.MigrateLegacyKeys(ctx)
+	if err != nil || moved < len(legacy) {
+		t.Fatalf("MigrateLegacyKeys() = %d, %v, want at least %d keys", moved, err, len(legacy))
+	}
+
+	result, err := store.GetResult(ctx, tenant.Default, deviceID)
+	if err != nil || result.ZScore != 1.5 {
+		t.Errorf("GetResult() = %+v, %v, want legacy result", result, err)
+	}
+	windows, err := store.LoadWindows(ctx)
+	if err != nil || len(windows[tenant.Scope(tenant.Default, deviceID)]) != 1 {
+		t.Errorf("LoadWindows() error = %v, legacy window missing", err)
+	}
+	anomalies, err := store.ListAnomalies(ctx, tenant.Default, deviceID, 0)
+	if err != nil || len(anomalies) != 3 || anomalies[0].ZScore != 2 {
+		t.Errorf("ListAnomalies() = %+v, %v, want 3 legacy anomalies, newest first", anomalies, err)
+	}
+
+	// Повторный перенос без версии схемы не трогает ключи арендаторов
+	if err := client.Delete(ctx, schemaKey); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.MigrateLegacyKeys(ctx); err != nil {
+		t.Fatalf("second MigrateLegacyKeys() error = %v", err)
+	}
+	if result, err := store.GetResult(ctx, tenantA, deviceID); err != nil || result.ZScore != 7 {
+		t.Errorf("GetResult(%s) = %+v, %v, want tenant result", tenantA, result, err)
+	}
+	if anomalies, err := store.ListAnomalies(ctx, tenantA, deviceID, 0); err != nil || len(anomalies) != 1 {
+		t.Errorf("ListAnomalies(%s) = %d anomalies, %v, want 1", tenantA, len(anomalies), err)
+	}
+	windows, err = store.LoadWindows(ctx)
+	if err != nil || len(windows[tenant.Scope(tenantA, deviceID)]) != 1 {
+		t.Errorf("LoadWindows() error = %v, tenant window missing", err)
+	}
+	for key := range windows {
+		if strings.HasPrefix(key, tenant.Scope(tenant.Default, tenantA)) || strings.HasPrefix(key, tenant.Scope(tenant.Default, tenant.Default)) {
+			t.Errorf("LoadWindows() has key %s moved twice", key)
+		}
+	}
+	if result, err := store.GetResult(ctx, tenant.Default, deviceID); err != nil || result.ZScore != 1.5 {
+		t.Errorf("GetResult() after second migration = %+v, %v, want legacy result", result, err)
+	}
+
+	// Версия схемы записана: новые ключи без арендатора больше не переносятся
+	if err := client.Set(ctx, legacy[0], models.AnalyticsResult{DeviceID: deviceID}, time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if moved, err := store.MigrateLegacyKeys(ctx); err != nil || moved != 0 {
+		t.Errorf("second MigrateLegacyKeys() = %d, %v, want 0", moved, err)
+	}
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go-service/internal/models"
+)
+
+// ErrNotFound возвращается, когда запись отсутствует в хранилище
+var ErrNotFound = errors.New("not found")
+
+// Storage хранит окна метрик, результаты аналитики и аномалии устройств.
+// Записи разных арендаторов хранятся под ключами tenant.Scope и не пересекаются.
+// Записи, сохраненные до разделения по арендаторам, Redis и Disk переносят
+// к арендатору по умолчанию.
+type Storage interface {
+	// SaveResult сохраняет последний результат аналитики устройства арендатора result.Tenant
+	SaveResult(ctx context.Context, result *models.AnalyticsResult) error
+	// GetResult возвращает последний результат или ErrNotFound
+	GetResult(ctx context.Context, tenantID, deviceID string) (*models.AnalyticsResult, error)
+
+	// SaveWindow сохраняет снимок окна метрик устройства
+	SaveWindow(ctx context.Context, tenantID, deviceID string, metrics []models.Metric) error
+	// LoadWindows возвращает сохраненные окна всех устройств по ключам tenant.Scope
+	LoadWindows(ctx context.Context) (map[string][]models.Metric, error)
+
+	// SaveAnomaly добавляет аномалию в журнал устройства арендатора result.Tenant
+	SaveAnomaly(ctx context.Context, result *models.AnalyticsResult) error
+	// ListAnomalies возвращает последние аномалии устройства, новые первыми
+	ListAnomalies(ctx context.Context, tenantID, deviceID string, limit int) ([]models.AnalyticsResult, error)
+
+	// Close сбрасывает отложенные записи и освобождает ресурсы
+	Close(ctx context.Context) error
+}
+
+// MaxAnomaliesPerDevice ограничивает журнал аномалий одного устройства
+const MaxAnomaliesPerDevice = 1000
+
+// isLegacyKey проверяет, что ключ записан до разделения по арендаторам.
+// В ключе tenant.Scope есть двоеточие, а идентификаторы устройств того
+// времени двоеточий не содержат.
+func isLegacyKey(key string) bool {
+	return !strings.Contains(key, ":")
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-service/internal/models"
+	"go-service/internal/tenant"
+)
+
+// Арендаторы общих проверок хранилищ. Одинаковое устройство у обоих
+// проверяет, что записи арендаторов не пересекаются.
+const (
+	tenantA = "conformance-a"
+	tenantB = "conformance-b"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) Storage { return NewMemory() })
+}
+
+func TestDiskConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) Storage {
+		store, err := NewDisk(filepath.Join(t.TempDir(), "analytics.db"))
+		if err != nil {
+			t.Fatalf("NewDisk() error = %v", err)
+		}
+		return store
+	})
+}
+
+// testConformance проверяет поведение, общее для всех хранилищ. open
+// возвращает пустое хранилище, его закрывает сама проверка.
+func testConformance(t *testing.T, open func(t *testing.T) Storage) {
+	t.Run("Results", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+		defer store.Close(ctx)
+
+		if _, err := store.GetResult(ctx, tenantA, "sensor-1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetResult() of missing device error = %v, want ErrNotFound", err)
+		}
+
+		at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		saved := []models.AnalyticsResult{
+			{Tenant: tenantA, DeviceID: "sensor-1", Timestamp: at, ZScore: 1},
+			{Tenant: tenantA, DeviceID: "sensor-1", Timestamp: at.Add(time.Second), ZScore: 2.5, IsAnomaly: true},
+			{Tenant: tenantB, DeviceID: "sensor-1", Timestamp: at, ZScore: -1},
+		}
+		for i := range saved {
+			if err := store.SaveResult(ctx, &saved[i]); err != nil {
+				t.Fatalf("SaveResult() error = %v", err)
+			}
+		}
+
+		for _, want := range saved[1:] {
+			got, err := store.GetResult(ctx, want.Tenant, want.DeviceID)
+			if err != nil {
+				t.Fatalf("GetResult(%s, %s) error = %v", want.Tenant, want.DeviceID, err)
+			}
+			if got.ZScore != want.ZScore || got.IsAnomaly != want.IsAnomaly || !got.Timestamp.Equal(want.Timestamp) {
+				t.Errorf("GetResult(%s, %s) = %+v, want %+v", want.Tenant, want.DeviceID, got, want)
+			}
+		}
+	})
+
+	t.Run("Windows", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+		defer store.Close(ctx)
+
+		at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		window := []models.Metric{
+			{DeviceID: "sensor-1", CPU: 10, Timestamp: at},
+			{DeviceID: "sensor-1", CPU: 20, Values: map[string]float64{"temperature": 21.5}, Timestamp: at.Add(time.Second)},
+		}
+		if err := store.SaveWindow(ctx, tenantA, "sensor-1", window[:1]); err != nil {
+			t.Fatalf("SaveWindow() error = %v", err)
+		}
+		// Новый снимок заменяет прежний
+		if err := store.SaveWindow(ctx, tenantA, "sensor-1", window); err != nil {
+			t.Fatalf("SaveWindow() error = %v", err)
+		}
+		if err := store.SaveWindow(ctx, tenantB, "sensor-1", window[:1]); err != nil {
+			t.Fatalf("SaveWindow() error = %v", err)
+		}
+
+		windows, err := store.LoadWindows(ctx)
+		if err != nil {
+			t.Fatalf("LoadWindows() error = %v", err)
+		}
+		got := windows[tenant.Scope(tenantA, "sensor-1")]
+		if len(got) != 2 || got[1].CPU != 20 || got[1].Values["temperature"] != 21.5 || !got[1].Timestamp.Equal(window[1].Timestamp) {
+			t.Errorf("window of %s = %+v, want %+v", tenantA, got, window)
+		}
+		if got := windows[tenant.Scope(tenantB, "sensor-1")]; len(got) != 1 {
+			t.Errorf("window of %s has %d metrics, want 1", tenantB, len(got))
+		}
+	})
+
+	t.Run("Anomalies", func(t *testing.T) {
+		ctx := context.Background()
+		store := open(t)
+		defer store.Close(ctx)
+
+		at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 5; i++ {
+			result := models.AnalyticsResult{Tenant: tenantA, DeviceID: "sensor-1", Timestamp: at.Add(time.Duration(i) * time.Second), ZScore: float64(i), IsAnomaly: true}
+			if err := store.SaveAnomaly(ctx, &result); err != nil {
+				t.Fatalf("SaveAnomaly() error = %v", err)
+			}
+		}
+		if err := store.SaveAnomaly(ctx, &models.AnalyticsResult{Tenant: tenantB, DeviceID: "sensor-1", IsAnomaly: true}); err != nil {
+			t.Fatalf("SaveAnomaly() error = %v", err)
+		}
+
+		tests := []struct {
+			tenantID, deviceID string
+			limit              int
+			want               []float64 // z-score, новые первыми
+		}{
+			{tenantA, "sensor-1", 0, []float64{4, 3, 2, 1, 0}},
+			{tenantA, "sensor-1", 2, []float64{4, 3}},
+			{tenantA, "sensor-1", 10, []float64{4, 3, 2, 1, 0}},
+			{tenantB, "sensor-1", 0, []float64{0}},
+			{tenantA, "sensor-2", 0, nil},
+		}
+		for _, tt := range tests {
+			anomalies, err := store.ListAnomalies(ctx, tt.tenantID, tt.deviceID, tt.limit)
+			if err != nil {
+				t.Fatalf("ListAnomalies() error = %v", err)
+			}
+			var got []float64
+			for _, a := range anomalies {
+				got = append(got, a.ZScore)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("ListAnomalies(%s, %s, %d) = %v, want %v", tt.tenantID, tt.deviceID, tt.limit, got, tt.want)
+				continue
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ListAnomalies(%s, %s, %d) = %v, want %v", tt.tenantID, tt.deviceID, tt.limit, got, tt.want)
+					break
+				}
+			}
+		}
+	})
+}
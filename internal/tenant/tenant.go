@@ -0,0 +1,70 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Default арендатор запросов без арендатора: ключи без поля tenant,
+// доверенные источники (MQTT, поток Redis, UDP) и работа без аутентификации
+const Default = "default"
+
+// maxLength предел длины идентификатора арендатора
+const maxLength = 64
+
+// Valid проверяет идентификатор арендатора: строчные латинские буквы,
+// цифры, '_' и '-', не длиннее 64 символов. Двоеточие запрещено, оно
+// отделяет арендатора в ключах хранилищ.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Validate возвращает ошибку для недопустимого идентификатора
+func Validate(id string) error {
+	if !Valid(id) {
+		return fmt.Errorf("invalid tenant %q", id)
+	}
+	return nil
+}
+
+// Scope ключ устройства арендатора в хранилищах и окнах: <tenant>:<device>.
+// Устройства разных арендаторов с одинаковым именем не пересекаются.
+func Scope(tenantID, deviceID string) string {
+	return tenantID + ":" + deviceID
+}
+
+// Split разбирает ключ Scope на арендатора и устройство
+func Split(key string) (tenantID, deviceID string, ok bool) {
+	tenantID, deviceID, ok = strings.Cut(key, ":")
+	if !ok || !Valid(tenantID) {
+		return "", "", false
+	}
+	return tenantID, deviceID, true
+}
+
+// contextKey ключ контекста для арендатора запроса
+type contextKey struct{}
+
+// WithTenant сохраняет арендатора запроса в контексте
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenantID)
+}
+
+// FromContext возвращает арендатора запроса или Default
+func FromContext(ctx context.Context) string {
+	if tenantID, ok := ctx.Value(contextKey{}).(string); ok && tenantID != "" {
+		return tenantID
+	}
+	return Default
+}
@@ -0,0 +1,107 @@
+package tsdb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-service/internal/models"
+)
+
+// blockExt расширение файлов блоков: метрики в JSON построчно, сжатые gzip
+const blockExt = ".json.gz"
+
+// blocksDir каталог блоков внутри каталога хранилища
+func blocksDir(dir string) string {
+	return filepath.Join(dir, "blocks")
+}
+
+// readBlock читает все метрики блока. Отсутствующий блок считается пустым.
+func readBlock(path string) ([]models.Metric, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var metrics []models.Metric
+	dec := json.NewDecoder(zr)
+	for {
+		var m models.Metric
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				return metrics, nil
+			}
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+// writeBlock атомарно записывает блок через временный файл
+func writeBlock(path string, metrics []models.Metric) error {
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	for _, m := range metrics {
+		if err := enc.Encode(m); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// listBlocks возвращает начала разделов всех блоков в каталоге по возрастанию
+func listBlocks(dir string) ([]int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var starts []int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, blockExt) {
+			continue
+		}
+		start, err := strconv.ParseInt(strings.TrimSuffix(name, blockExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	return starts, nil
+}
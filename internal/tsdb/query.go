@@ -0,0 +1,246 @@
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go-service/internal/models"
+)
+
+// ResolutionRaw обозначает ответ по сырым метрикам
+const ResolutionRaw = "raw"
+
+// Point точка временного ряда: значение поля или агрегат за шаг
+type Point struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"` // Среднее за шаг
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Count     int       `json:"count"`
+	P95       float64   `json:"p95"`
+	// P95 объединяет оценки нескольких интервалов агрегатов и не является
+	// квантилем значений шага: квантили интервалов не складываются
+	P95Approximate bool `json:"p95_approximate,omitempty"`
+}
+
+// QuerySeries возвращает ряд поля устройства в интервале [from, to) и
+// использованное разрешение. Разрешение выбирается по шагу: самые крупные
+// агрегаты, не превышающие шаг и еще хранящиеся для from. Без шага
+// возвращаются сырые метрики. Интервал шире самого долгого срока хранения
+// отклоняется с ErrInvalidRange. Ряд с шагом больше limit точек
+// отклоняется до чтения, без шага чтение прерывается после limit метрик.
+func (db *DB) QuerySeries(ctx context.Context, deviceID, field string, from, to time.Time, step time.Duration, limit int) ([]Point, string, error) {
+	now := time.Now()
+	to, err := db.clampTo(from, to, now)
+	if err != nil {
+		return nil, "", err
+	}
+	if retention := db.maxRetention(); retention > 0 && to.Sub(from) > retention {
+		return nil, "", fmt.Errorf("%w: range is wider than retention %s", ErrInvalidRange, retention)
+	}
+	if step > 0 && limit > 0 && (to.Sub(from)+step-1)/step > time.Duration(limit) {
+		return nil, "", fmt.Errorf("%w: more than %d steps of %s", ErrTooManyPoints, limit, step)
+	}
+
+	level := db.pickLevel(from, step, now)
+	if level == nil {
+		sampleLimit := limit
+		if step > 0 {
+			// Число точек уже проверено, метрик в шаге может быть сколько угодно
+			sampleLimit = 0
+		}
+		samples, err := db.Query(ctx, deviceID, from, to, sampleLimit)
+		if err != nil {
+			return nil, "", err
+		}
+		return Series(samples, field, from, step), ResolutionRaw, nil
+	}
+
+	// Агрегаты выровнены по своему разрешению, шаги ряда выравниваем так же
+	origin := from.Truncate(level.Resolution)
+
+	rollups, err := db.queryRollups(ctx, level, deviceID, field, origin, to)
+	if err != nil {
+		return nil, "", err
+	}
+	return rollupSeries(rollups, origin, step), level.Name, nil
+}
+
+// maxRetention самый долгий срок хранения сырых метрик и агрегатов,
+// 0 если хотя бы что-то хранится бессрочно
+func (db *DB) maxRetention() time.Duration {
+	retention := db.opts.Retention
+	if retention <= 0 {
+		return 0
+	}
+	for _, level := range db.rollups.levels {
+		if level.Retention <= 0 {
+			return 0
+		}
+		retention = max(retention, level.Retention)
+	}
+	return retention
+}
+
+// pickLevel выбирает разрешение агрегатов или nil для сырых метрик
+func (db *DB) pickLevel(from time.Time, step time.Duration, now time.Time) *rollupLevel {
+	if step <= 0 {
+		return nil
+	}
+	covers := func(retention time.Duration) bool {
+		return retention <= 0 || !from.Before(now.Add(-retention))
+	}
+
+	var chosen *rollupLevel
+	for _, level := range db.rollups.levels {
+		if level.Resolution <= step && covers(level.Retention) {
+			chosen = level
+		}
+	}
+	if chosen != nil {
+		return chosen
+	}
+	if covers(db.opts.Retention) {
+		return nil
+	}
+
+	// Данные за from остались только в более крупных агрегатах
+	for _, level := range db.rollups.levels {
+		if covers(level.Retention) {
+			return level
+		}
+	}
+	if n := len(db.rollups.levels); n > 0 {
+		return db.rollups.levels[n-1]
+	}
+	return nil
+}
+
+// queryRollups читает агрегаты разрешения с диска и из памяти
+func (db *DB) queryRollups(ctx context.Context, level *rollupLevel, deviceID, field string, from, to time.Time) ([]Rollup, error) {
+	db.blocksMu.RLock()
+	defer db.blocksMu.RUnlock()
+
+	var result []Rollup
+	var err error
+	for start := level.partitionStart(from); start < to.UnixNano(); start += int64(level.Partition) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result, err = level.readRollups(result, start, deviceID, field, from, to)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db.mu.RLock()
+	result = level.collect(result, deviceID, field, from, to)
+	db.mu.RUnlock()
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Start.Before(result[j].Start)
+	})
+	return result, nil
+}
+
+// rollupSeries объединяет агрегаты в точки с шагом step. Интервал мог
+// быть записан несколькими частями, они складываются. P95 объединенных
+// интервалов оценивается средним, взвешенным по количеству значений, и
+// помечается как приблизительный.
+func rollupSeries(rollups []Rollup, origin time.Time, step time.Duration) []Point {
+	points := make([]Point, 0)
+	var p95Weighted float64
+
+	for _, r := range rollups {
+		if r.Count == 0 {
+			continue
+		}
+		ts := origin.Add(r.Start.Sub(origin) / step * step)
+
+		if n := len(points); n > 0 && points[n-1].Timestamp.Equal(ts) {
+			p := &points[n-1]
+			p.Value += r.Sum
+			p.Min = min(p.Min, r.Min)
+			p.Max = max(p.Max, r.Max)
+			p.Count += r.Count
+			p.P95Approximate = true
+			p95Weighted += r.P95 * float64(r.Count)
+			continue
+		}
+
+		finishPoint(points, p95Weighted)
+		points = append(points, Point{
+			Timestamp: ts,
+			Value:     r.Sum,
+			Min:       r.Min,
+			Max:       r.Max,
+			Count:     r.Count,
+		})
+		p95Weighted = r.P95 * float64(r.Count)
+	}
+	finishPoint(points, p95Weighted)
+
+	return points
+}
+
+// finishPoint переводит накопленные суммы последней точки в средние
+func finishPoint(points []Point, p95Weighted float64) {
+	if n := len(points); n > 0 {
+		p := &points[n-1]
+		p.Value /= float64(p.Count)
+		p.P95 = p95Weighted / float64(p.Count)
+	}
+}
+
+// Series переводит метрики в точки ряда по полю. При step > 0 значения
+// агрегируются в интервалы [from+k*step, from+(k+1)*step), иначе
+// возвращается каждая метрика. Пустые интервалы пропускаются.
+func Series(metrics []models.Metric, field string, from time.Time, step time.Duration) []Point {
+	points := make([]Point, 0)
+	var values []float64
+
+	for _, m := range metrics {
+		value, ok := m.Field(field)
+		if !ok {
+			continue
+		}
+
+		ts := m.Timestamp
+		if step > 0 {
+			ts = from.Add(m.Timestamp.Sub(from) / step * step)
+		}
+
+		if n := len(points); step > 0 && n > 0 && points[n-1].Timestamp.Equal(ts) {
+			p := &points[n-1]
+			p.Value += (value - p.Value) / float64(p.Count+1)
+			p.Min = min(p.Min, value)
+			p.Max = max(p.Max, value)
+			p.Count++
+			values = append(values, value)
+			continue
+		}
+
+		finishPercentile(points, values)
+		points = append(points, Point{
+			Timestamp: ts,
+			Value:     value,
+			Min:       value,
+			Max:       value,
+			Count:     1,
+		})
+		values = append(values[:0], value)
+	}
+	finishPercentile(points, values)
+
+	return points
+}
+
+// finishPercentile вычисляет точный P95 последней точки по ее значениям
+func finishPercentile(points []Point, values []float64) {
+	if n := len(points); n > 0 {
+		sort.Float64s(values)
+		points[n-1].P95 = percentile(values, 0.95)
+	}
+}
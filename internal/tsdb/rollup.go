@@ -0,0 +1,376 @@
+package tsdb
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go-service/internal/models"
+)
+
+// rollupGrace сколько ждать опоздавшие метрики после конца интервала
+const rollupGrace = time.Minute
+
+// Rollup агрегат поля устройства за один интервал разрешения
+type Rollup struct {
+	DeviceID string    `json:"device_id"`
+	Field    string    `json:"field"`
+	Start    time.Time `json:"start"`
+	Count    int       `json:"count"`
+	Sum      float64   `json:"sum"`
+	Min      float64   `json:"min"`
+	Max      float64   `json:"max"`
+	P95      float64   `json:"p95"`
+}
+
+// RollupLevel настройки одного разрешения агрегатов
+type RollupLevel struct {
+	Name       string
+	Resolution time.Duration
+	Partition  time.Duration // Период одного файла агрегатов
+	Retention  time.Duration
+}
+
+// DefaultRollupLevels разрешения 1m, 1h и 1d с дефолтными сроками хранения
+func DefaultRollupLevels() []RollupLevel {
+	return []RollupLevel{
+		{Name: "1m", Resolution: time.Minute, Partition: 6 * time.Hour, Retention: 30 * 24 * time.Hour},
+		{Name: "1h", Resolution: time.Hour, Partition: 7 * 24 * time.Hour, Retention: 365 * 24 * time.Hour},
+		{Name: "1d", Resolution: 24 * time.Hour, Partition: 90 * 24 * time.Hour, Retention: 5 * 365 * 24 * time.Hour},
+	}
+}
+
+// rollupKey идентифицирует открытый интервал
+type rollupKey struct {
+	device string
+	field  string
+	start  int64
+}
+
+// rollupBucket накапливает агрегат открытого интервала
+type rollupBucket struct {
+	count int
+	sum   float64
+	min   float64
+	max   float64
+	p95   *p2Quantile
+}
+
+func (b *rollupBucket) add(value float64) {
+	if b.count == 0 {
+		b.min, b.max = value, value
+	}
+	b.count++
+	b.sum += value
+	b.min = min(b.min, value)
+	b.max = max(b.max, value)
+	b.p95.Add(value)
+}
+
+// rollupLevel открытые и закрытые, но еще не записанные агрегаты разрешения
+type rollupLevel struct {
+	RollupLevel
+	dir     string
+	open    map[rollupKey]*rollupBucket
+	pending []Rollup
+}
+
+// rollups непрерывно агрегирует поступающие метрики. Все методы
+// вызываются под блокировкой DB.mu.
+type rollups struct {
+	levels   []*rollupLevel
+	latest   map[string]time.Time // Последнее время события по устройству
+	lastSeen map[string]time.Time // Последнее время приема по устройству
+}
+
+func newRollups(dir string, levels []RollupLevel) (*rollups, error) {
+	r := &rollups{
+		latest:   make(map[string]time.Time),
+		lastSeen: make(map[string]time.Time),
+	}
+	for _, level := range levels {
+		levelDir := filepath.Join(dir, "rollups", level.Name)
+		if err := os.MkdirAll(levelDir, 0o755); err != nil {
+			return nil, err
+		}
+		r.levels = append(r.levels, &rollupLevel{
+			RollupLevel: level,
+			dir:         levelDir,
+			open:        make(map[rollupKey]*rollupBucket),
+		})
+	}
+	return r, nil
+}
+
+// add учитывает метрику во всех разрешениях
+func (r *rollups) add(m models.Metric, now time.Time) {
+	if m.Timestamp.After(r.latest[m.DeviceID]) {
+		r.latest[m.DeviceID] = m.Timestamp
+	}
+	r.lastSeen[m.DeviceID] = now
+
+	for _, level := range r.levels {
+		start := m.Timestamp.Truncate(level.Resolution).UnixNano()
+		m.EachField(func(name string, value float64) {
+			level.bucket(rollupKey{device: m.DeviceID, field: name, start: start}).add(value)
+		})
+	}
+}
+
+// restore учитывает метрику при восстановлении после перезапуска: только
+// в интервалах разрешения i с началом не раньше froms[i], которых нет
+// среди записанных written[i]. Возвращает, попала ли метрика хоть в один.
+func (r *rollups) restore(m models.Metric, now time.Time, froms []time.Time, written []map[rollupKey]bool) bool {
+	restored := false
+	for i, level := range r.levels {
+		if m.Timestamp.Before(froms[i]) {
+			continue
+		}
+		start := m.Timestamp.Truncate(level.Resolution).UnixNano()
+		m.EachField(func(name string, value float64) {
+			key := rollupKey{device: m.DeviceID, field: name, start: start}
+			if written[i][key] {
+				return
+			}
+			level.bucket(key).add(value)
+			restored = true
+		})
+	}
+	if restored {
+		if m.Timestamp.After(r.latest[m.DeviceID]) {
+			r.latest[m.DeviceID] = m.Timestamp
+		}
+		r.lastSeen[m.DeviceID] = now
+	}
+	return restored
+}
+
+// bucket возвращает открытый интервал, создавая его при необходимости
+func (level *rollupLevel) bucket(key rollupKey) *rollupBucket {
+	bucket, exists := level.open[key]
+	if !exists {
+		bucket = &rollupBucket{p95: newP2Quantile(0.95)}
+		level.open[key] = bucket
+	}
+	return bucket
+}
+
+// seal закрывает интервалы, в которые уже не ожидается метрик: время
+// событий устройства ушло дальше конца интервала или устройство молчит.
+// С force закрываются все интервалы.
+func (r *rollups) seal(now time.Time, force bool) {
+	for _, level := range r.levels {
+		for key, bucket := range level.open {
+			end := time.Unix(0, key.start).Add(level.Resolution + rollupGrace)
+			idle := now.Sub(r.lastSeen[key.device]) > level.Resolution+rollupGrace
+			if !force && end.After(r.latest[key.device]) && !idle {
+				continue
+			}
+
+			level.pending = append(level.pending, Rollup{
+				DeviceID: key.device,
+				Field:    key.field,
+				Start:    time.Unix(0, key.start).UTC(),
+				Count:    bucket.count,
+				Sum:      bucket.sum,
+				Min:      bucket.min,
+				Max:      bucket.max,
+				P95:      bucket.p95.Value(),
+			})
+			delete(level.open, key)
+		}
+	}
+
+	// Забываем устройства без открытых интервалов
+	for device, seen := range r.lastSeen {
+		if force || now.Sub(seen) > r.maxResolution()+rollupGrace {
+			delete(r.lastSeen, device)
+			delete(r.latest, device)
+		}
+	}
+}
+
+// maxResolution самое грубое разрешение
+func (r *rollups) maxResolution() time.Duration {
+	var res time.Duration
+	for _, level := range r.levels {
+		res = max(res, level.Resolution)
+	}
+	return res
+}
+
+// collect возвращает незаписанные агрегаты устройства и поля из интервала
+func (level *rollupLevel) collect(dst []Rollup, deviceID, field string, from, to time.Time) []Rollup {
+	for _, rollup := range level.pending {
+		if matchRollup(rollup, deviceID, field, from, to) {
+			dst = append(dst, rollup)
+		}
+	}
+	for key, bucket := range level.open {
+		if key.device != deviceID || key.field != field {
+			continue
+		}
+		rollup := Rollup{
+			DeviceID: key.device,
+			Field:    key.field,
+			Start:    time.Unix(0, key.start).UTC(),
+			Count:    bucket.count,
+			Sum:      bucket.sum,
+			Min:      bucket.min,
+			Max:      bucket.max,
+			P95:      bucket.p95.Value(),
+		}
+		if matchRollup(rollup, deviceID, field, from, to) {
+			dst = append(dst, rollup)
+		}
+	}
+	return dst
+}
+
+// partitionStart начало файла агрегатов для момента времени
+func (level *rollupLevel) partitionStart(t time.Time) int64 {
+	return t.Truncate(level.Partition).UnixNano()
+}
+
+// path путь к файлу агрегатов раздела
+func (level *rollupLevel) path(start int64) string {
+	return filepath.Join(level.dir, fmt.Sprintf("%d%s", start, blockExt))
+}
+
+// writeRollups дописывает агрегаты в файлы разделов. Каждая запись добавляет
+// к файлу новый gzip-поток, поэтому файлы не переписываются.
+func (level *rollupLevel) writeRollups(items []Rollup) error {
+	byPartition := make(map[int64][]Rollup)
+	for _, item := range items {
+		start := level.partitionStart(item.Start)
+		byPartition[start] = append(byPartition[start], item)
+	}
+
+	for start, batch := range byPartition {
+		f, err := os.OpenFile(level.path(start), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+
+		zw := gzip.NewWriter(f)
+		enc := json.NewEncoder(zw)
+		for _, item := range batch {
+			if err := enc.Encode(item); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if err := zw.Close(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRollups читает агрегаты устройства и поля из раздела
+func (level *rollupLevel) readRollups(dst []Rollup, start int64, deviceID, field string, from, to time.Time) ([]Rollup, error) {
+	err := level.scanRollups(start, func(rollup Rollup) {
+		if matchRollup(rollup, deviceID, field, from, to) {
+			dst = append(dst, rollup)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// writtenKeys возвращает интервалы разрешения с началом не раньше from,
+// уже записанные на диск
+func (level *rollupLevel) writtenKeys(from time.Time) (map[rollupKey]bool, error) {
+	starts, err := listBlocks(level.dir)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[rollupKey]bool)
+	for _, start := range starts {
+		if start+int64(level.Partition) <= from.UnixNano() {
+			continue
+		}
+		err := level.scanRollups(start, func(rollup Rollup) {
+			if !rollup.Start.Before(from) {
+				keys[rollupKey{device: rollup.DeviceID, field: rollup.Field, start: rollup.Start.UnixNano()}] = true
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// scanRollups передает fn все агрегаты раздела
+func (level *rollupLevel) scanRollups(start int64, fn func(Rollup)) error {
+	f, err := os.Open(level.path(start))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// gzip.Reader по умолчанию читает все последовательные потоки файла
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	dec := json.NewDecoder(zr)
+	for {
+		var rollup Rollup
+		if err := dec.Decode(&rollup); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		fn(rollup)
+	}
+}
+
+// applyRetention удаляет файлы агрегатов старше срока хранения разрешения
+func (level *rollupLevel) applyRetention(now time.Time) error {
+	if level.Retention <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-level.Retention).UnixNano()
+
+	starts, err := listBlocks(level.dir)
+	if err != nil {
+		return err
+	}
+	for _, start := range starts {
+		if start+int64(level.Partition) <= cutoff {
+			if err := os.Remove(level.path(start)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchRollup проверяет принадлежность агрегата устройству, полю и интервалу
+func matchRollup(rollup Rollup, deviceID, field string, from, to time.Time) bool {
+	return rollup.DeviceID == deviceID && rollup.Field == field &&
+		!rollup.Start.Before(from) && rollup.Start.Before(to)
+}
@@ -0,0 +1,549 @@
+package tsdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go-service/internal/models"
+
+	"go.uber.org/zap"
+)
+
+const walFileName = "wal.log"
+
+// DefaultMaxClockSkew насколько метрика может опережать часы узла
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// ErrFutureTimestamp возвращается для метрики из будущего дальше MaxClockSkew
+var ErrFutureTimestamp = errors.New("metric timestamp is too far in the future")
+
+// Ошибки запросов истории
+var (
+	ErrInvalidRange  = errors.New("invalid time range")
+	ErrTooManyPoints = errors.New("too many points")
+)
+
+// Options настройки хранилища истории
+type Options struct {
+	Dir           string
+	Partition     time.Duration // Длительность одного блока
+	Retention     time.Duration // Сколько хранить сырые метрики
+	FlushInterval time.Duration // Период сброса закрытых разделов и fsync журнала
+	Rollups       []RollupLevel // Разрешения агрегатов от мелкого к крупному
+	MaxClockSkew  time.Duration // Насколько метрика может опережать часы узла
+}
+
+// DB встроенное хранилище истории метрик: журнал упреждающей записи
+// плюс сжатые блоки на диске, разбитые по временным разделам
+type DB struct {
+	opts   Options
+	logger *zap.SugaredLogger
+
+	mu       sync.RWMutex
+	wal      *os.File
+	walBuf   *bufio.Writer
+	head     map[int64][]models.Metric // Несброшенные метрики по началу раздела
+	rollups  *rollups
+	blocksMu sync.RWMutex // Сериализует перезапись файлов блоков с чтением
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+// Open открывает хранилище, восстанавливает несброшенные метрики из журнала
+// и запускает фоновое обслуживание
+func Open(opts Options, logger *zap.SugaredLogger) (*DB, error) {
+	if opts.Partition <= 0 {
+		opts.Partition = 2 * time.Hour
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Minute
+	}
+	if opts.MaxClockSkew <= 0 {
+		opts.MaxClockSkew = DefaultMaxClockSkew
+	}
+	if err := os.MkdirAll(blocksDir(opts.Dir), 0o755); err != nil {
+		return nil, err
+	}
+	sort.Slice(opts.Rollups, func(i, j int) bool {
+		return opts.Rollups[i].Resolution < opts.Rollups[j].Resolution
+	})
+	rollups, err := newRollups(opts.Dir, opts.Rollups)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{
+		opts:    opts,
+		logger:  logger,
+		head:    make(map[int64][]models.Metric),
+		rollups: rollups,
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+
+	replayed, err := db.replayWAL()
+	if err != nil {
+		return nil, fmt.Errorf("replay wal: %w", err)
+	}
+	if replayed > 0 {
+		logger.Infof("Replayed %d metrics from history WAL", replayed)
+	}
+	restored, err := db.rebuildRollups(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("rebuild rollups: %w", err)
+	}
+	if restored > 0 {
+		logger.Infof("Rebuilt open rollups from %d history metrics", restored)
+	}
+
+	wal, err := os.OpenFile(filepath.Join(opts.Dir, walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	db.wal = wal
+	db.walBuf = bufio.NewWriter(wal)
+
+	go db.run()
+	return db, nil
+}
+
+// Append записывает метрику в журнал и в текущий раздел. Метрика из
+// будущего дальше MaxClockSkew отклоняется с ErrFutureTimestamp: она
+// закрыла бы агрегаты устройства раньше времени.
+func (db *DB) Append(metric models.Metric) error {
+	now := time.Now()
+	if metric.Timestamp.After(now.Add(db.opts.MaxClockSkew)) {
+		return fmt.Errorf("%w: %s", ErrFutureTimestamp, metric.Timestamp.Format(time.RFC3339))
+	}
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.walBuf == nil {
+		return errors.New("history store is closed")
+	}
+	if _, err := db.walBuf.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	start := db.partitionStart(metric.Timestamp)
+	db.head[start] = append(db.head[start], metric)
+	db.rollups.add(metric, now)
+	return nil
+}
+
+// Query возвращает метрики устройства в интервале [from, to), упорядоченные
+// по времени. Интервал обрезается сроком хранения и MaxClockSkew от часов
+// узла. При limit > 0 чтение прекращается с ErrTooManyPoints, как только
+// метрик становится больше limit.
+func (db *DB) Query(ctx context.Context, deviceID string, from, to time.Time, limit int) ([]models.Metric, error) {
+	now := time.Now()
+	to, err := db.clampTo(from, to, now)
+	if err != nil {
+		return nil, err
+	}
+	// Данные старше срока хранения уже удалены
+	if db.opts.Retention > 0 {
+		if oldest := now.Add(-db.opts.Retention); from.Before(oldest) {
+			from = oldest
+		}
+	}
+
+	var result []models.Metric
+	for start := db.partitionStart(from); start < to.UnixNano(); start += int64(db.opts.Partition) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// Блок и голова раздела читаются под одной блокировкой, чтобы сброс
+		// не перенес метрики между ними; между разделами сброс не ждет запрос
+		db.blocksMu.RLock()
+		block, err := db.readBlock(start)
+		if err != nil {
+			db.blocksMu.RUnlock()
+			return nil, err
+		}
+		result = appendMatching(result, block, deviceID, from, to)
+		db.mu.RLock()
+		result = appendMatching(result, db.head[start], deviceID, from, to)
+		db.mu.RUnlock()
+		db.blocksMu.RUnlock()
+
+		if limit > 0 && len(result) > limit {
+			return nil, fmt.Errorf("%w: more than %d metrics", ErrTooManyPoints, limit)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
+// clampTo ограничивает конец интервала запроса часами узла плюс
+// MaxClockSkew: дальше метрик нет. Конец раньше начала отклоняется.
+func (db *DB) clampTo(from, to, now time.Time) (time.Time, error) {
+	if latest := now.Add(db.opts.MaxClockSkew); to.After(latest) {
+		to = latest
+	}
+	if to.Before(from) {
+		return to, fmt.Errorf("%w: %s is before %s", ErrInvalidRange, to.Format(time.RFC3339), from.Format(time.RFC3339))
+	}
+	return to, nil
+}
+
+// Close сбрасывает все разделы в блоки и закрывает журнал
+func (db *DB) Close() error {
+	db.once.Do(func() { close(db.stopCh) })
+	<-db.doneCh
+
+	if err := db.flush(time.Now(), true); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.walBuf = nil
+	return db.wal.Close()
+}
+
+// run периодически сбрасывает закрытые разделы и удаляет устаревшие блоки
+func (db *DB) run() {
+	defer close(db.doneCh)
+
+	ticker := time.NewTicker(db.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		if err := db.syncWAL(); err != nil {
+			db.logger.Errorf("Failed to sync history WAL: %v", err)
+		}
+		if err := db.flush(time.Now(), false); err != nil {
+			db.logger.Errorf("Failed to flush history partitions: %v", err)
+		}
+		if err := db.applyRetention(time.Now()); err != nil {
+			db.logger.Errorf("Failed to apply history retention: %v", err)
+		}
+	}
+}
+
+// syncWAL сбрасывает буфер журнала на диск
+func (db *DB) syncWAL() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.walBuf == nil {
+		return nil
+	}
+	if err := db.walBuf.Flush(); err != nil {
+		return err
+	}
+	return db.wal.Sync()
+}
+
+// flush записывает разделы в блоки. Без all сбрасываются только разделы,
+// закончившиеся по часам узла больше одного раздела назад, чтобы
+// опоздавшие метрики успели попасть в голову. После сброса журнал
+// переписывается остатком головы.
+func (db *DB) flush(now time.Time, all bool) error {
+	db.blocksMu.Lock()
+	defer db.blocksMu.Unlock()
+
+	if err := db.flushRollups(now, all); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	cutoff := db.partitionStart(now) - int64(db.opts.Partition)
+	sealed := make(map[int64][]models.Metric)
+	for start, metrics := range db.head {
+		if all || start < cutoff {
+			sealed[start] = metrics
+		}
+	}
+	db.mu.Unlock()
+
+	if len(sealed) == 0 {
+		return nil
+	}
+
+	for start, metrics := range sealed {
+		if err := db.mergeBlock(start, metrics); err != nil {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for start, metrics := range sealed {
+		// Пока шла запись, в раздел могли добавиться метрики: оставляем их в голове
+		if rest := db.head[start][len(metrics):]; len(rest) > 0 {
+			db.head[start] = append([]models.Metric(nil), rest...)
+		} else {
+			delete(db.head, start)
+		}
+	}
+	return db.rewriteWAL()
+}
+
+// flushRollups закрывает завершенные интервалы агрегатов и дописывает их на диск
+func (db *DB) flushRollups(now time.Time, all bool) error {
+	db.mu.Lock()
+	db.rollups.seal(now, all)
+	pending := make([][]Rollup, len(db.rollups.levels))
+	for i, level := range db.rollups.levels {
+		pending[i] = level.pending
+		level.pending = nil
+	}
+	db.mu.Unlock()
+
+	for i, level := range db.rollups.levels {
+		if len(pending[i]) == 0 {
+			continue
+		}
+		if err := level.writeRollups(pending[i]); err != nil {
+			// Возвращаем агрегаты, чтобы записать их при следующем сбросе
+			db.mu.Lock()
+			level.pending = append(pending[i], level.pending...)
+			db.mu.Unlock()
+			return fmt.Errorf("write %s rollups: %w", level.Name, err)
+		}
+	}
+	return nil
+}
+
+// mergeBlock объединяет метрики с существующим блоком раздела
+func (db *DB) mergeBlock(start int64, metrics []models.Metric) error {
+	existing, err := db.readBlock(start)
+	if err != nil {
+		return err
+	}
+
+	merged := make([]models.Metric, 0, len(existing)+len(metrics))
+	merged = append(merged, existing...)
+	merged = append(merged, metrics...)
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].DeviceID != merged[j].DeviceID {
+			return merged[i].DeviceID < merged[j].DeviceID
+		}
+		return merged[i].Timestamp.Before(merged[j].Timestamp)
+	})
+
+	return writeBlock(db.blockPath(start), merged)
+}
+
+// rewriteWAL заменяет журнал содержимым головы. Вызывается под db.mu.
+func (db *DB) rewriteWAL() error {
+	if db.walBuf == nil {
+		return nil
+	}
+
+	path := filepath.Join(db.opts.Dir, walFileName)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(buf)
+	for _, metrics := range db.head {
+		for _, m := range metrics {
+			if err := enc.Encode(m); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	db.wal.Close()
+	wal, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	db.wal = wal
+	db.walBuf = bufio.NewWriter(wal)
+	return nil
+}
+
+// replayWAL загружает несброшенные метрики из журнала в голову.
+// Агрегаты восстанавливает rebuildRollups.
+func (db *DB) replayWAL() (int, error) {
+	f, err := os.Open(filepath.Join(db.opts.Dir, walFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var m models.Metric
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				break
+			}
+			// Оборванная последняя запись после сбоя не мешает восстановлению
+			db.logger.Warnf("Stopped history WAL replay at corrupted record: %v", err)
+			break
+		}
+		start := db.partitionStart(m.Timestamp)
+		db.head[start] = append(db.head[start], m)
+		count++
+	}
+	return count, nil
+}
+
+// rebuildRollups восстанавливает после перезапуска открытые интервалы
+// агрегатов по сырым метрикам из блоков и головы. Пересчитываются интервалы,
+// которые к остановке еще могли принимать метрики, и интервалы метрик из
+// журнала. Интервалы, уже записанные на диск, пропускаются, чтобы не учесть
+// их дважды. Возвращает число учтенных метрик.
+func (db *DB) rebuildRollups(now time.Time) (int, error) {
+	levels := db.rollups.levels
+	if len(levels) == 0 {
+		return 0, nil
+	}
+
+	oldestHead := now
+	for start := range db.head {
+		if t := time.Unix(0, start); t.Before(oldestHead) {
+			oldestHead = t
+		}
+	}
+
+	froms := make([]time.Time, len(levels))
+	written := make([]map[rollupKey]bool, len(levels))
+	from := now
+	for i, level := range levels {
+		levelFrom := now.Add(-level.Resolution - rollupGrace)
+		if oldestHead.Before(levelFrom) {
+			levelFrom = oldestHead
+		}
+		froms[i] = levelFrom.Truncate(level.Resolution)
+		keys, err := level.writtenKeys(froms[i])
+		if err != nil {
+			return 0, fmt.Errorf("read %s rollups: %w", level.Name, err)
+		}
+		written[i] = keys
+		if froms[i].Before(from) {
+			from = froms[i]
+		}
+	}
+
+	restored := 0
+	to := now.Add(db.opts.MaxClockSkew).UnixNano()
+	for start := db.partitionStart(from); start < to; start += int64(db.opts.Partition) {
+		block, err := db.readBlock(start)
+		if err != nil {
+			return 0, err
+		}
+		for _, metrics := range [][]models.Metric{block, db.head[start]} {
+			for _, m := range metrics {
+				if db.rollups.restore(m, now, froms, written) {
+					restored++
+				}
+			}
+		}
+	}
+	return restored, nil
+}
+
+// applyRetention удаляет блоки и метрики старше срока хранения
+func (db *DB) applyRetention(now time.Time) error {
+	if db.opts.Retention <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-db.opts.Retention).UnixNano()
+
+	db.blocksMu.Lock()
+	defer db.blocksMu.Unlock()
+
+	starts, err := listBlocks(blocksDir(db.opts.Dir))
+	if err != nil {
+		return err
+	}
+	for _, start := range starts {
+		if start+int64(db.opts.Partition) <= cutoff {
+			if err := os.Remove(db.blockPath(start)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	for _, level := range db.rollups.levels {
+		if err := level.applyRetention(now); err != nil {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for start := range db.head {
+		if start+int64(db.opts.Partition) <= cutoff {
+			delete(db.head, start)
+		}
+	}
+	return nil
+}
+
+// partitionStart возвращает начало раздела для момента времени в наносекундах
+func (db *DB) partitionStart(t time.Time) int64 {
+	return t.Truncate(db.opts.Partition).UnixNano()
+}
+
+// readBlock читает блок раздела, если он существует
+func (db *DB) readBlock(start int64) ([]models.Metric, error) {
+	return readBlock(db.blockPath(start))
+}
+
+// blockPath путь к файлу блока раздела
+func (db *DB) blockPath(start int64) string {
+	return filepath.Join(blocksDir(db.opts.Dir), fmt.Sprintf("%d%s", start, blockExt))
+}
+
+// appendMatching добавляет метрики устройства из интервала [from, to)
+func appendMatching(dst, src []models.Metric, deviceID string, from, to time.Time) []models.Metric {
+	for _, m := range src {
+		if m.DeviceID == deviceID && !m.Timestamp.Before(from) && m.Timestamp.Before(to) {
+			dst = append(dst, m)
+		}
+	}
+	return dst
+}
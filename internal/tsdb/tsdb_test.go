@@ -0,0 +1,220 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-service/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// openTestDB открывает хранилище без фонового сброса в пределах теста
+func openTestDB(t *testing.T, dir string) *DB {
+	t.Helper()
+	db, err := Open(Options{
+		Dir:           dir,
+		Partition:     time.Hour,
+		FlushInterval: time.Hour,
+		Rollups:       DefaultRollupLevels(),
+	}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestAppendRejectsFutureTimestamp(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	err := db.Append(models.Metric{DeviceID: "d1", RPS: 1, Timestamp: time.Now().Add(time.Hour)})
+	if !errors.Is(err, ErrFutureTimestamp) {
+		t.Fatalf("Append future metric: %v, want ErrFutureTimestamp", err)
+	}
+	if err := db.Append(models.Metric{DeviceID: "d1", RPS: 1, Timestamp: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("Append metric within skew: %v", err)
+	}
+}
+
+func TestFlushSealsByWallClock(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	now := time.Now()
+	old := now.Add(-3 * time.Hour)
+	for _, ts := range []time.Time{old, now} {
+		if err := db.Append(models.Metric{DeviceID: "d1", RPS: 1, Timestamp: ts}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.flush(now, false); err != nil {
+		t.Fatal(err)
+	}
+	db.mu.RLock()
+	_, oldInHead := db.head[db.partitionStart(old)]
+	_, currentInHead := db.head[db.partitionStart(now)]
+	db.mu.RUnlock()
+	if oldInHead || !currentInHead {
+		t.Errorf("after flush old partition in head %v, current %v; want only current", oldInHead, currentInHead)
+	}
+
+	block, err := db.readBlock(db.partitionStart(old))
+	if err != nil || len(block) != 1 {
+		t.Errorf("old block has %d metrics, err %v; want 1", len(block), err)
+	}
+	metrics, err := db.Query(context.Background(), "d1", old.Add(-time.Minute), now.Add(time.Minute), 0)
+	if err != nil || len(metrics) != 2 {
+		t.Errorf("Query returned %d metrics, err %v; want 2", len(metrics), err)
+	}
+}
+
+// crash останавливает хранилище без сброса, как при падении процесса:
+// на диске остаются только журнал и уже записанные файлы
+func crash(t *testing.T, db *DB) {
+	t.Helper()
+	if err := db.syncWAL(); err != nil {
+		t.Fatal(err)
+	}
+	db.once.Do(func() { close(db.stopCh) })
+	<-db.doneCh
+	db.mu.Lock()
+	db.walBuf = nil
+	db.wal.Close()
+	db.mu.Unlock()
+}
+
+// hourCount количество значений rps устройства в часовых агрегатах интервала
+func hourCount(t *testing.T, db *DB, from, to time.Time) int {
+	t.Helper()
+	points, resolution, err := db.QuerySeries(context.Background(), "d1", "rps", from, to, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution != "1h" {
+		t.Fatalf("resolution = %s, want 1h", resolution)
+	}
+	count := 0
+	for _, p := range points {
+		count += p.Count
+	}
+	return count
+}
+
+func TestReopenRebuildsOpenRollups(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	now := time.Now()
+	hour := now.Truncate(time.Hour)
+	old := hour.Add(-3 * time.Hour)
+	var current int
+	for ts := old; ts.Before(now); ts = ts.Add(10 * time.Minute) {
+		if err := db.Append(models.Metric{DeviceID: "d1", RPS: 1, Timestamp: ts}); err != nil {
+			t.Fatal(err)
+		}
+		if !ts.Before(hour) {
+			current++
+		}
+	}
+	total := hourCount(t, db, old, now.Add(time.Minute))
+
+	// Закрытые часы записываются на диск, текущий остается открытым
+	if err := db.flushRollups(now, false); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, db)
+
+	db = openTestDB(t, dir)
+	defer db.Close()
+	if got := hourCount(t, db, hour, now.Add(time.Minute)); got != current {
+		t.Errorf("open hour after restart has %d values, want %d", got, current)
+	}
+	if got := hourCount(t, db, old, now.Add(time.Minute)); got != total {
+		t.Errorf("hours after restart have %d values, want %d without double counting", got, total)
+	}
+}
+
+func TestRollupSeriesMarksMergedP95(t *testing.T) {
+	origin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rollups := []Rollup{
+		{Start: origin, Count: 10, Sum: 10, Min: 1, Max: 1, P95: 1},
+		{Start: origin.Add(time.Minute), Count: 30, Sum: 90, Min: 3, Max: 3, P95: 3},
+		{Start: origin.Add(time.Hour), Count: 5, Sum: 5, Min: 1, Max: 1, P95: 1},
+	}
+	points := rollupSeries(rollups, origin, time.Hour)
+	if len(points) != 2 {
+		t.Fatalf("got %d points, want 2", len(points))
+	}
+	if !points[0].P95Approximate || points[0].P95 != 2.5 || points[0].Value != 2.5 {
+		t.Errorf("merged point = %+v, want approximate p95 2.5", points[0])
+	}
+	if points[1].P95Approximate {
+		t.Errorf("single interval point marked approximate: %+v", points[1])
+	}
+}
+
+func TestQueryRange(t *testing.T) {
+	db, err := Open(Options{
+		Dir:           t.TempDir(),
+		Partition:     time.Hour,
+		Retention:     24 * time.Hour,
+		FlushInterval: time.Hour,
+		Rollups: []RollupLevel{
+			{Name: "1h", Resolution: time.Hour, Partition: 24 * time.Hour, Retention: 48 * time.Hour},
+		},
+	}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		if err := db.Append(models.Metric{DeviceID: "d1", RPS: 1, Timestamp: now.Add(-time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		step     time.Duration
+		limit    int
+		want     int
+		wantErr  error
+	}{
+		{name: "to before from", from: now, to: now.Add(-time.Hour), wantErr: ErrInvalidRange},
+		{name: "wider than retention", from: now.Add(-72 * time.Hour), to: now, step: time.Hour, wantErr: ErrInvalidRange},
+		{name: "future to is clamped", from: now.Add(-time.Hour), to: now.AddDate(100, 0, 0), want: 10},
+		{name: "future range", from: now.Add(time.Hour), to: now.Add(2 * time.Hour), wantErr: ErrInvalidRange},
+		{name: "raw over limit", from: now.Add(-time.Hour), to: now.Add(time.Minute), limit: 5, wantErr: ErrTooManyPoints},
+		{name: "raw within limit", from: now.Add(-time.Hour), to: now.Add(time.Minute), limit: 10, want: 10},
+		{name: "steps over limit", from: now.Add(-24 * time.Hour), to: now, step: time.Minute, limit: 100, wantErr: ErrTooManyPoints},
+		{name: "steps within limit", from: now.Add(-time.Hour), to: now.Add(time.Minute), step: time.Hour, limit: 100, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, _, err := db.QuerySeries(context.Background(), "d1", "rps", tt.from, tt.to, tt.step, tt.limit)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("QuerySeries error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			count := 0
+			for _, p := range points {
+				count += p.Count
+			}
+			if count != tt.want {
+				t.Errorf("got %d values, want %d", count, tt.want)
+			}
+		})
+	}
+}
@@ -25,6 +25,31 @@ var (
 	RedisOperations      *prometheus.CounterVec
 	RollingAverageValues prometheus.Histogram
 	ZScoreValues         prometheus.Histogram
+
+	LateMetrics      *prometheus.CounterVec
+	DuplicateMetrics prometheus.Counter
+	IngestedMetrics  *prometheus.CounterVec
+	MQTTConnected    prometheus.Gauge
+
+	StreamLag          prometheus.Gauge
+	StreamPending      prometheus.Gauge
+	StreamClaimed      prometheus.Counter
+	StreamDeadLettered prometheus.Counter
+
+	AuthFailures     *prometheus.CounterVec
+	AuditWriteErrors prometheus.Counter
+	RateLimited      *prometheus.CounterVec
+
+	InFlightRequests *prometheus.GaugeVec
+	ShedRequests     *prometheus.CounterVec
+	ConcurrencyLimit prometheus.Gauge
+
+	WriteBehindPending       prometheus.Gauge
+	WriteBehindCoalesced     prometheus.Counter
+	WriteBehindDropped       prometheus.Counter
+	WriteBehindFlushed       prometheus.Counter
+	WriteBehindFlushErrors   prometheus.Counter
+	WriteBehindFlushDuration prometheus.Histogram
 )
 
 // InitMetrics инициализирует метрики
@@ -91,6 +116,153 @@ func InitMetrics() {
 				Buckets: prometheus.LinearBuckets(-10, 1, 40),
 			},
 		)
+
+		LateMetrics = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "app_late_metrics_total",
+				Help: "Total number of out-of-order metrics by outcome",
+			},
+			[]string{"outcome"},
+		)
+
+		DuplicateMetrics = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "app_duplicate_metrics_total",
+				Help: "Total number of replayed metrics suppressed by idempotency key",
+			},
+		)
+
+		IngestedMetrics = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "app_ingested_metrics_total",
+				Help: "Total number of metrics received through ingestion protocols by source and outcome",
+			},
+			[]string{"source", "outcome"},
+		)
+
+		MQTTConnected = promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "app_mqtt_connected",
+				Help: "Whether the MQTT subscriber is connected to the broker",
+			},
+		)
+
+		StreamLag = promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "app_stream_lag",
+				Help: "Number of Redis stream entries not yet delivered to the consumer group",
+			},
+		)
+
+		StreamPending = promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "app_stream_pending",
+				Help: "Number of Redis stream entries delivered but not acknowledged",
+			},
+		)
+
+		StreamClaimed = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "app_stream_claimed_total",
+				Help: "Total number of idle Redis stream entries claimed from other consumers",
+			},
+		)
+
+		StreamDeadLettered = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "app_stream_dead_lettered_total",
+				Help: "Total number of Redis stream entries moved to the dead-letter stream",
+			},
+		)
+
+		AuthFailures = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "app_auth_failures_total",
+				Help: "Total number of rejected requests by reason (missing, invalid, forbidden)",
+			},
+			[]string{"reason"},
+		)
+
+		AuditWriteErrors = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "app_audit_write_errors_total",
+				Help: "Total number of administrative actions that could not be written to the audit log",
+			},
+		)
+
+		RateLimited = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "app_rate_limited_requests_total",
+				Help: "Total number of ingestion requests throttled by device or API key rate limits",
+			},
+			[]string{"handler", "scope"},
+		)
+
+		InFlightRequests = promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "app_inflight_requests",
+				Help: "Number of HTTP requests being served by priority",
+			},
+			[]string{"priority"},
+		)
+
+		ShedRequests = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "app_shed_requests_total",
+				Help: "Total number of HTTP requests rejected with 503 by the concurrency limit",
+			},
+			[]string{"priority"},
+		)
+
+		ConcurrencyLimit = promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "app_concurrency_limit",
+				Help: "Current adaptive limit of concurrent HTTP requests",
+			},
+		)
+
+		WriteBehindPending = promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "app_write_behind_pending",
+				Help: "Number of values waiting to be flushed to Redis",
+			},
+		)
+
+		WriteBehindCoalesced = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "app_write_behind_coalesced_total",
+				Help: "Total number of pending values replaced by newer ones",
+			},
+		)
+
+		WriteBehindDropped = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "app_write_behind_dropped_total",
+				Help: "Total number of values dropped because the write-behind queue was full",
+			},
+		)
+
+		WriteBehindFlushed = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "app_write_behind_flushed_total",
+				Help: "Total number of values flushed to Redis",
+			},
+		)
+
+		WriteBehindFlushErrors = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "app_write_behind_flush_errors_total",
+				Help: "Total number of failed write-behind pipeline flushes",
+			},
+		)
+
+		WriteBehindFlushDuration = promauto.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "app_write_behind_flush_duration_seconds",
+				Help:    "Duration of write-behind flushes in seconds",
+				Buckets: prometheus.DefBuckets,
+			},
+		)
 	})
 }
 
@@ -122,3 +294,84 @@ func RecordRequestDuration(endpoint string, duration time.Duration) {
 func RecordMetricProcessed() {
 	MetricsProcessed.Inc()
 }
+
+func RecordLateMetric(outcome string) {
+	LateMetrics.WithLabelValues(outcome).Inc()
+}
+
+func RecordDuplicateMetric() {
+	DuplicateMetrics.Inc()
+}
+
+func RecordIngestedMetrics(source, outcome string, count int) {
+	IngestedMetrics.WithLabelValues(source, outcome).Add(float64(count))
+}
+
+func SetMQTTConnected(connected bool) {
+	if connected {
+		MQTTConnected.Set(1)
+	} else {
+		MQTTConnected.Set(0)
+	}
+}
+
+func SetStreamLag(lag, pending int64) {
+	StreamLag.Set(float64(lag))
+	StreamPending.Set(float64(pending))
+}
+
+func RecordStreamClaimed(count int) {
+	StreamClaimed.Add(float64(count))
+}
+
+func RecordStreamDeadLettered() {
+	StreamDeadLettered.Inc()
+}
+
+func RecordAuthFailure(reason string) {
+	AuthFailures.WithLabelValues(reason).Inc()
+}
+
+func RecordAuditWriteError() {
+	AuditWriteErrors.Inc()
+}
+
+func RecordRateLimited(handler, scope string) {
+	RateLimited.WithLabelValues(handler, scope).Inc()
+}
+
+func SetInFlightRequests(priority string, n int) {
+	InFlightRequests.WithLabelValues(priority).Set(float64(n))
+}
+
+func RecordShedRequest(priority string) {
+	ShedRequests.WithLabelValues(priority).Inc()
+}
+
+func SetConcurrencyLimit(limit int) {
+	ConcurrencyLimit.Set(float64(limit))
+}
+
+func SetWriteBehindPending(size int) {
+	WriteBehindPending.Set(float64(size))
+}
+
+func RecordWriteBehindCoalesced() {
+	WriteBehindCoalesced.Inc()
+}
+
+func RecordWriteBehindDropped() {
+	WriteBehindDropped.Inc()
+}
+
+func RecordWriteBehindFlushed(count int) {
+	WriteBehindFlushed.Add(float64(count))
+}
+
+func RecordWriteBehindFlushError() {
+	WriteBehindFlushErrors.Inc()
+}
+
+func RecordWriteBehindFlushDuration(duration time.Duration) {
+	WriteBehindFlushDuration.Observe(duration.Seconds())
+}